@@ -0,0 +1,105 @@
+//go:build e2e
+// +build e2e
+
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/http-wasm/http-wasm-host-go/handler"
+	nethttp "github.com/http-wasm/http-wasm-host-go/handler/nethttp"
+)
+
+const benchDirectives = `
+	Include @coraza.conf-recommended
+	Include @crs-setup.conf.example
+	Include @owasp_crs/*.conf
+	SecRuleEngine On
+	SecResponseBodyAccess On
+`
+
+func BenchmarkHandler(b *testing.B) {
+	mw, err := nethttp.NewMiddleware(testCtx, guest,
+		handler.GuestConfig([]byte(fmt.Sprintf("{\"directives\": [ %q ]}", benchDirectives))),
+	)
+	if err != nil {
+		b.Fatalf("failed to create middleware: %v", err)
+	}
+	defer mw.Close(testCtx)
+
+	wrapped := mw.NewHandler(testCtx, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("Hello world, transaction not disrupted."))
+	}))
+
+	var multipartBody bytes.Buffer
+	mpw := multipart.NewWriter(&multipartBody)
+	fw, _ := mpw.CreateFormFile("file", "upload.txt")
+	_, _ = fw.Write(bytes.Repeat([]byte("a"), 1<<20))
+	_ = mpw.Close()
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		contentType    string
+		body           []byte
+		expectedStatus int
+	}{
+		{
+			name:           "no body",
+			method:         http.MethodGet,
+			target:         "/anything?name=coraza",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "small JSON",
+			method:         http.MethodPost,
+			target:         "/anything",
+			contentType:    "application/json",
+			body:           []byte(`{"name":"coraza","tags":["waf","wasm"],"enabled":true}`),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "large multipart",
+			method:         http.MethodPost,
+			target:         "/upload",
+			contentType:    mpw.FormDataContentType(),
+			body:           multipartBody.Bytes(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "blocked",
+			method:         http.MethodGet,
+			target:         "/anything?q=" + strings.ReplaceAll("<script>alert(1)</script>", " ", "+"),
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest(tt.method, tt.target, bytes.NewReader(tt.body))
+				req.Header.Set("User-Agent", "coraza-benchmark")
+				req.Header.Set("Accept", "*/*")
+				if tt.contentType != "" {
+					req.Header.Set("Content-Type", tt.contentType)
+				}
+
+				rec := httptest.NewRecorder()
+				wrapped.ServeHTTP(rec, req)
+				if rec.Code != tt.expectedStatus {
+					b.Fatalf("unexpected status code, want %d, have %d", tt.expectedStatus, rec.Code)
+				}
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Command corazawasm-quickstart generates a ready to use host config, in
+// YAML, for a kind of upstream: the CRS set up along with sensible limits,
+// to be tuned from there.
+//
+// Usage:
+//
+//	corazawasm-quickstart api > config.yaml
+//	corazawasm-quickstart -paranoia 2 -enforce wordpress > config.yaml
+//
+// The presets are api (JSON APIs), wordpress and webapp (generic web
+// applications).
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+//go:embed presets
+var presetsFS embed.FS
+
+var templates = template.Must(template.ParseFS(presetsFS, "presets/*"))
+
+// params are the parameters of the preset templates.
+type params struct {
+	Preset   string
+	Mode     string
+	Paranoia int
+	// Settings are the directives set after the recommended Coraza config,
+	// and Exclusions the ones before the CRS rules.
+	Settings   []string
+	Exclusions []string
+}
+
+// presets holds the directives specific to each preset.
+var presets = map[string]params{
+	"api": {
+		Settings: []string{
+			// Request bodies are small, and response bodies not worth the
+			// memory of inspecting them.
+			"SecRequestBodyLimit 1048576",
+			"SecResponseBodyAccess Off",
+		},
+	},
+	"wordpress": {
+		Exclusions: []string{
+			// Passwords and post contents are free text.
+			`'SecRule REQUEST_FILENAME "@streq /wp-login.php" "id:1000,phase:1,pass,t:none,nolog,ctl:ruleRemoveTargetByTag=OWASP_CRS;ARGS:pwd"'`,
+			`'SecRule REQUEST_FILENAME "@streq /wp-admin/post.php" "id:1001,phase:1,pass,t:none,nolog,ctl:ruleRemoveTargetByTag=OWASP_CRS;ARGS:content"'`,
+		},
+	},
+	"webapp": {},
+}
+
+func main() {
+	paranoia := flag.Int("paranoia", 1, "CRS paranoia level, from 1 to 4")
+	enforce := flag.Bool("enforce", false, "block right away instead of starting in detect mode")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: corazawasm-quickstart [-paranoia 1-4] [-enforce] <"+strings.Join(presetNames(), "|")+">")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *paranoia < 1 || *paranoia > 4 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	mode := "detect"
+	if *enforce {
+		mode = "enforce"
+	}
+	if err := generate(os.Stdout, flag.Arg(0), mode, *paranoia); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to generate the config:", err)
+		os.Exit(1)
+	}
+}
+
+// generate writes the host config of the preset.
+func generate(w io.Writer, preset, mode string, paranoia int) error {
+	p, ok := presets[preset]
+	if !ok {
+		return fmt.Errorf("unknown preset %q, expected one of %s", preset, strings.Join(presetNames(), ", "))
+	}
+	p.Preset, p.Mode, p.Paranoia = preset, mode, paranoia
+	return templates.ExecuteTemplate(w, preset+".yaml", p)
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
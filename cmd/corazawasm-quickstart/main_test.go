@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/corazawaf/coraza-http-wasm/guest"
+	"github.com/corazawaf/coraza-http-wasm/internal/hostconfig"
+)
+
+func TestPresets(t *testing.T) {
+	for _, preset := range presetNames() {
+		t.Run(preset, func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, generate(&out, preset, "enforce", 4))
+
+			content, err := hostconfig.FromYAML(out.Bytes())
+			require.NoError(t, err)
+			require.Contains(t, string(content), `"mode":"enforce"`)
+
+			report, err := guest.Validate(content)
+			require.NoError(t, err)
+			// The presets use IP based protections, which can't work on hosts
+			// giving no source address, and leave the fallbacks to the user.
+			require.Len(t, report.Warnings, 1)
+			require.Contains(t, report.Warnings[0], "Neither clientIPHeader nor defaultClientIP is set")
+			require.Contains(t, out.String(), "blocking_paranoia_level=4")
+			require.Contains(t, out.String(), "# maintenance:")
+		})
+	}
+
+	t.Run("unknown preset", func(t *testing.T) {
+		require.ErrorContains(t, generate(&bytes.Buffer{}, "drupal", "detect", 1), `unknown preset "drupal", expected one of api, webapp, wordpress`)
+	})
+}
@@ -0,0 +1,53 @@
+// Command corazawasm-validate validates a host config offline, building the
+// WAF the same way the guest does, including the embedded CRS, so that CI
+// can reject bad configs before they get deployed.
+//
+// Usage:
+//
+//	corazawasm-validate config.json
+//	corazawasm-validate config.yaml
+//	corazawasm-validate - < config.json
+//
+// It exits with 1 when the config is invalid.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/corazawaf/coraza-http-wasm/guest"
+	"github.com/corazawaf/coraza-http-wasm/internal/hostconfig"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: corazawasm-validate <config.json|config.yaml|->")
+		os.Exit(2)
+	}
+
+	config, err := hostconfig.Read(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read the config:", err)
+		os.Exit(2)
+	}
+
+	report, err := guest.Validate(config)
+	var dirErr *guest.DirectiveError
+	if errors.As(err, &dirErr) {
+		fmt.Fprintf(os.Stderr, "Invalid config, the directive at %s failed to compile:\n\t%s\n%v\n",
+			dirErr.Location(), strings.ReplaceAll(dirErr.Directive, "\n", "\n\t"), dirErr.Err)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:", err)
+		os.Exit(1)
+	}
+
+	for _, w := range report.Warnings {
+		fmt.Println("warning:", w)
+	}
+	fmt.Printf("Valid config: %d directives, %d rules, about %.1f MiB of memory\n",
+		report.Directives, report.Rules, float64(report.HeapBytes)/(1<<20))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// exchange is a recorded request along with the response of the upstream,
+// if recorded too.
+type exchange struct {
+	method string
+	url    *url.URL
+	proto  string
+	header http.Header
+	body   []byte
+
+	// status is zero when the response is not recorded.
+	status       int
+	resHeader    http.Header
+	responseBody []byte
+}
+
+// request builds the request to replay, it can be called for each replay.
+func (x exchange) request() *http.Request {
+	// The host may rewrite the URL.
+	u := *x.url
+	req := &http.Request{
+		Method:        x.method,
+		URL:           &u,
+		RequestURI:    x.url.RequestURI(),
+		Host:          x.url.Host,
+		Header:        x.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(x.body)),
+		ContentLength: int64(len(x.body)),
+	}
+	req.Proto = x.proto
+	if major, minor, ok := http.ParseHTTPVersion(x.proto); ok {
+		req.ProtoMajor, req.ProtoMinor = major, minor
+	} else {
+		// e.g. "h2" or "HTTP/2" as some browsers record.
+		req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.1", 1, 1
+	}
+	if host := x.header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	return req
+}
+
+// readCapture reads the exchanges of a HAR file, or of a raw HTTP capture
+// made of consecutive HTTP/1.x requests.
+func readCapture(content []byte) ([]exchange, error) {
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		return readHAR(content)
+	}
+	return readRaw(content)
+}
+
+// har is the subset of the HAR format describing the exchanges, see
+// http://www.softwareishard.com/blog/har-12-spec/.
+type har struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method      string      `json:"method"`
+				URL         string      `json:"url"`
+				HTTPVersion string      `json:"httpVersion"`
+				Headers     []harHeader `json:"headers"`
+				PostData    *struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+			Response struct {
+				Status  int         `json:"status"`
+				Headers []harHeader `json:"headers"`
+				Content struct {
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harHeaders converts the headers, leaving out the HTTP/2 pseudo headers and
+// the ones describing the encoding of the body, which HAR files record
+// decoded.
+func harHeaders(headers []harHeader) http.Header {
+	h := http.Header{}
+	for _, header := range headers {
+		switch name := http.CanonicalHeaderKey(header.Name); {
+		case strings.HasPrefix(name, ":"), name == "Content-Length", name == "Content-Encoding", name == "Transfer-Encoding":
+		default:
+			h.Add(name, header.Value)
+		}
+	}
+	return h
+}
+
+func readHAR(content []byte) ([]exchange, error) {
+	var capture har
+	if err := json.Unmarshal(content, &capture); err != nil {
+		return nil, err
+	}
+
+	exchanges := make([]exchange, 0, len(capture.Log.Entries))
+	for _, entry := range capture.Log.Entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, err
+		}
+		x := exchange{
+			method:    entry.Request.Method,
+			url:       u,
+			proto:     entry.Request.HTTPVersion,
+			header:    harHeaders(entry.Request.Headers),
+			status:    entry.Response.Status,
+			resHeader: harHeaders(entry.Response.Headers),
+		}
+		if entry.Request.PostData != nil {
+			x.body = []byte(entry.Request.PostData.Text)
+		}
+
+		x.responseBody = []byte(entry.Response.Content.Text)
+		if entry.Response.Content.Encoding == "base64" {
+			if x.responseBody, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text); err != nil {
+				return nil, err
+			}
+		}
+		exchanges = append(exchanges, x)
+	}
+	return exchanges, nil
+}
+
+func readRaw(content []byte) ([]exchange, error) {
+	var exchanges []exchange
+	r := bufio.NewReader(bytes.NewReader(content))
+	for {
+		// Requests may be separated by blank lines.
+		for {
+			b, err := r.Peek(1)
+			if err != nil || (b[0] != '\r' && b[0] != '\n') {
+				break
+			}
+			_, _ = r.ReadByte()
+		}
+		if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+			return exchanges, nil
+		}
+
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		u := *req.URL
+		u.Host = req.Host
+		header := req.Header.Clone()
+		// The body is passed as is, whatever its framing was.
+		header.Del("Transfer-Encoding")
+		exchanges = append(exchanges, exchange{method: req.Method, url: &u, proto: req.Proto, header: header, body: body})
+	}
+}
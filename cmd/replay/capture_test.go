@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCapture(t *testing.T) {
+	t.Run("HAR", func(t *testing.T) {
+		exchanges, err := readCapture([]byte(`{"log": {"entries": [{
+			"request": {
+				"method": "POST",
+				"url": "https://example.com/form?a=1",
+				"httpVersion": "HTTP/2",
+				"headers": [{"name": ":authority", "value": "example.com"}, {"name": "content-type", "value": "text/plain"}, {"name": "content-length", "value": "5"}],
+				"postData": {"text": "hello"}
+			},
+			"response": {
+				"status": 201,
+				"headers": [{"name": "content-encoding", "value": "gzip"}, {"name": "x-id", "value": "1"}],
+				"content": {"text": "d29ybGQ=", "encoding": "base64"}
+			}
+		}]}}`))
+		require.NoError(t, err)
+		require.Len(t, exchanges, 1)
+
+		x := exchanges[0]
+		require.Equal(t, http.Header{"Content-Type": {"text/plain"}}, x.header)
+		require.Equal(t, 201, x.upstreamStatus())
+		require.Equal(t, http.Header{"X-Id": {"1"}}, x.resHeader)
+		require.Equal(t, "world", string(x.responseBody))
+
+		req := x.request()
+		require.Equal(t, "POST", req.Method)
+		require.Equal(t, "/form?a=1", req.RequestURI)
+		require.Equal(t, "example.com", req.Host)
+		// Versions Go doesn't parse fall back to HTTP/1.1.
+		require.Equal(t, "HTTP/1.1", req.Proto)
+		body, _ := io.ReadAll(req.Body)
+		require.Equal(t, "hello", string(body))
+
+		// Requests can be replayed more than once.
+		body, _ = io.ReadAll(x.request().Body)
+		require.Equal(t, "hello", string(body))
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		exchanges, err := readCapture([]byte("GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n\r\n" +
+			"POST /b HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n" +
+			"GET /c HTTP/1.0\r\nHost: example.org\r\n\r\n"))
+		require.NoError(t, err)
+		require.Len(t, exchanges, 3)
+
+		require.Equal(t, "/a", exchanges[0].request().RequestURI)
+		require.Equal(t, 200, exchanges[0].upstreamStatus())
+
+		req := exchanges[1].request()
+		require.Empty(t, req.Header.Get("Transfer-Encoding"))
+		body, _ := io.ReadAll(req.Body)
+		require.Equal(t, "hello", string(body))
+
+		req = exchanges[2].request()
+		require.Equal(t, "example.org", req.Host)
+		require.Equal(t, "HTTP/1.0", req.Proto)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := readCapture([]byte(`{"log": `))
+		require.Error(t, err)
+		_, err = readCapture([]byte("not a request\r\n\r\n"))
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,180 @@
+// Command replay drives recorded traffic through the built module, under the
+// wazero based reference host, and reports the requests that would be
+// blocked and the rules they match, so that ruleset changes can be evaluated
+// against real traffic offline.
+//
+// Usage:
+//
+//	replay -config config.yaml capture.har
+//	replay -config config.json -wasm build/coraza-http-wasm.wasm -all requests.txt
+//
+// Captures are HAR files, e.g. exported from a browser, or raw HTTP/1.x
+// requests one after the other. The responses recorded in HAR files are
+// served as the upstream ones, the other requests get an empty 200.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/http-wasm/http-wasm-host-go/api"
+	"github.com/http-wasm/http-wasm-host-go/handler"
+	nethttp "github.com/http-wasm/http-wasm-host-go/handler/nethttp"
+	"github.com/tetratelabs/wazero"
+
+	"github.com/corazawaf/coraza-http-wasm/internal/hostconfig"
+)
+
+func main() {
+	configPath := flag.String("config", "", "host config, in JSON or YAML")
+	wasmPath := flag.String("wasm", "build/coraza-http-wasm.wasm", "module to replay the traffic through")
+	all := flag.Bool("all", false, "also report the requests matching no rule")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: replay -config <config.json|config.yaml> [-wasm <module.wasm>] [-all] <capture>...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *configPath == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config, err := hostconfig.Read(*configPath)
+	if err != nil {
+		fail("Failed to read the config:", err)
+	}
+	guest, err := os.ReadFile(*wasmPath)
+	if err != nil {
+		fail("Failed to read the module:", err)
+	}
+
+	var exchanges []exchange
+	for _, path := range flag.Args() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fail("Failed to read the capture:", err)
+		}
+		x, err := readCapture(content)
+		if err != nil {
+			fail("Failed to parse the capture "+path+":", err)
+		}
+		exchanges = append(exchanges, x...)
+	}
+
+	ctx := context.Background()
+	logs := &logCollector{}
+	mw, err := nethttp.NewMiddleware(ctx, guest,
+		handler.GuestConfig(config),
+		handler.Logger(logs),
+		// TinyGo builds start with _start and standard Go ones with
+		// _initialize, missing start functions being skipped.
+		handler.ModuleConfig(wazero.NewModuleConfig().WithStartFunctions("_start", "_initialize").WithSysWalltime().WithSysNanotime()),
+	)
+	if err != nil {
+		fail("Failed to initialize the module:", err)
+	}
+	defer mw.Close(ctx)
+
+	var r replayer
+	for _, x := range exchanges {
+		r.replay(ctx, mw, logs, x, *all)
+	}
+	fmt.Printf("%d requests replayed: %d blocked, %d only logged, %d passed\n",
+		len(exchanges), r.blocked, r.logged, len(exchanges)-r.blocked-r.logged)
+}
+
+// replayer replays the exchanges one at a time, so that the logs of the
+// module can be told apart.
+type replayer struct {
+	blocked, logged int
+}
+
+func (r *replayer) replay(ctx context.Context, mw nethttp.Middleware, logs *logCollector, x exchange, all bool) {
+	var reached bool
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		reached = true
+		for k, v := range x.resHeader {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(x.upstreamStatus())
+		_, _ = w.Write(x.responseBody)
+	})
+
+	logs.lines = logs.lines[:0]
+	rec := httptest.NewRecorder()
+	mw.NewHandler(ctx, upstream).ServeHTTP(rec, x.request())
+
+	rules := logs.ruleIDs()
+	verdict := "passed "
+	switch {
+	case !reached || rec.Code != x.upstreamStatus():
+		verdict = "BLOCKED"
+		r.blocked++
+	case len(rules) > 0:
+		verdict = "logged "
+		r.logged++
+	case !all:
+		return
+	}
+
+	line := fmt.Sprintf("%s %d %s %s", verdict, rec.Code, x.method, x.url.RequestURI())
+	if len(rules) > 0 {
+		line += " rules " + strings.Join(rules, ",")
+	} else if verdict == "BLOCKED" && len(logs.lines) > 0 {
+		// Blocked before any rule, e.g. by a rate limit.
+		line += " (" + logs.lines[len(logs.lines)-1] + ")"
+	}
+	fmt.Println(line)
+}
+
+// upstreamStatus is the status the upstream answers the request with.
+func (x exchange) upstreamStatus() int {
+	if x.status == 0 {
+		return http.StatusOK
+	}
+	return x.status
+}
+
+// ruleIDPattern extracts the rule IDs from the logs of the matched rules.
+var ruleIDPattern = regexp.MustCompile(`\[id "(\d+)"\]`)
+
+// logCollector keeps the logs of the module for the request being replayed.
+type logCollector struct {
+	lines []string
+}
+
+func (*logCollector) IsEnabled(level api.LogLevel) bool {
+	return level >= api.LogLevelInfo
+}
+
+func (l *logCollector) Log(_ context.Context, level api.LogLevel, msg string) {
+	if l.IsEnabled(level) {
+		l.lines = append(l.lines, msg)
+	}
+}
+
+// ruleIDs returns the IDs of the matched rules, in the order they matched.
+func (l *logCollector) ruleIDs() []string {
+	var ids []string
+	for _, line := range l.lines {
+		for _, m := range ruleIDPattern.FindAllStringSubmatch(line, -1) {
+			if !slices.Contains(ids, m[1]) {
+				ids = append(ids, m[1])
+			}
+		}
+	}
+	return ids
+}
+
+func fail(msg string, err error) {
+	fmt.Fprintln(os.Stderr, msg, err)
+	os.Exit(1)
+}
@@ -0,0 +1,263 @@
+// Package config describes the host config of the module with Go types, so
+// that tools can generate configs programmatically and validate them with
+// the same code as the guest, e.g.
+//
+//	cfg := config.Config{
+//		Directives: config.Directives{"SecRuleEngine On", "Include @owasp_crs/*.conf"},
+//		RateLimits: []config.RateLimit{{By: "ip", Rate: 10, Burst: 20}},
+//	}
+//	data, err := config.Marshal(cfg)
+//
+// Fields left to their zero value are left out, the module applying its
+// defaults. See the Options section of the README for what the fields do.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/corazawaf/coraza-http-wasm/guest"
+)
+
+// Config is the host config.
+type Config struct {
+	Directives   Directives `json:"directives" yaml:"directives"`
+	VersionCheck string     `json:"versionCheck,omitempty" yaml:"versionCheck,omitempty"`
+	// IncludeCRS and ProtocolChecks default to true when nil. InflightTimeout
+	// is in seconds and defaults to 600 when nil.
+	IncludeCRS        *bool           `json:"includeCRS,omitempty" yaml:"includeCRS,omitempty"`
+	ProtocolChecks    *bool           `json:"protocolChecks,omitempty" yaml:"protocolChecks,omitempty"`
+	VerdictHeaders    bool            `json:"verdictHeaders,omitempty" yaml:"verdictHeaders,omitempty"`
+	InspectHostErrors bool            `json:"inspectHostErrors,omitempty" yaml:"inspectHostErrors,omitempty"`
+	ClientIPHeader    string          `json:"clientIPHeader,omitempty" yaml:"clientIPHeader,omitempty"`
+	DefaultClientIP   string          `json:"defaultClientIP,omitempty" yaml:"defaultClientIP,omitempty"`
+	Mode              string          `json:"mode,omitempty" yaml:"mode,omitempty"`
+	KillSwitch        *KillSwitch     `json:"killSwitch,omitempty" yaml:"killSwitch,omitempty"`
+	InflightTimeout   *float64        `json:"inflightTimeout,omitempty" yaml:"inflightTimeout,omitempty"`
+	DebugDump         *DebugDump      `json:"debugDump,omitempty" yaml:"debugDump,omitempty"`
+	Admin             *Admin          `json:"admin,omitempty" yaml:"admin,omitempty"`
+	BotDetection      *BotDetection   `json:"botDetection,omitempty" yaml:"botDetection,omitempty"`
+	SignedRequests    *SignedRequests `json:"signedRequests,omitempty" yaml:"signedRequests,omitempty"`
+	CSRF              *CSRF           `json:"csrf,omitempty" yaml:"csrf,omitempty"`
+	BruteForce        *BruteForce     `json:"bruteForce,omitempty" yaml:"bruteForce,omitempty"`
+	GeoPolicy         *GeoPolicy      `json:"geoPolicy,omitempty" yaml:"geoPolicy,omitempty"`
+	Honeypot          *Honeypot       `json:"honeypot,omitempty" yaml:"honeypot,omitempty"`
+	TLS               *TLS            `json:"tls,omitempty" yaml:"tls,omitempty"`
+	AllowedMethods    []string        `json:"allowedMethods,omitempty" yaml:"allowedMethods,omitempty"`
+	RequestLimits     *RequestLimits  `json:"requestLimits,omitempty" yaml:"requestLimits,omitempty"`
+	SlowRequests      *SlowRequests   `json:"slowRequests,omitempty" yaml:"slowRequests,omitempty"`
+	ScrubResponse     *ScrubResponse  `json:"scrubResponse,omitempty" yaml:"scrubResponse,omitempty"`
+	DataLeak          *DataLeak       `json:"dataLeak,omitempty" yaml:"dataLeak,omitempty"`
+	VirtualPatches    []VirtualPatch  `json:"virtualPatches,omitempty" yaml:"virtualPatches,omitempty"`
+	RateLimits        []RateLimit     `json:"rateLimits,omitempty" yaml:"rateLimits,omitempty"`
+	Maintenance       *Maintenance    `json:"maintenance,omitempty" yaml:"maintenance,omitempty"`
+	BypassTokens      *BypassTokens   `json:"bypassTokens,omitempty" yaml:"bypassTokens,omitempty"`
+	Canary            *Canary         `json:"canary,omitempty" yaml:"canary,omitempty"`
+	Shadow            *Shadow         `json:"shadow,omitempty" yaml:"shadow,omitempty"`
+}
+
+// Directives are SecLang directives. Besides an array, they are unmarshaled
+// from a string with one directive per line, as the guest accepts, which
+// becomes a single entry.
+type Directives []string
+
+func (d *Directives) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*d = Directives{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(d))
+}
+
+func (d *Directives) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*d = Directives{value.Value}
+		return nil
+	}
+	return value.Decode((*[]string)(d))
+}
+
+type KillSwitch struct {
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+	// Interval is in seconds.
+	Interval *float64 `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Engaged  bool     `json:"engaged,omitempty" yaml:"engaged,omitempty"`
+}
+
+type DebugDump struct {
+	// Enabled defaults to true when nil.
+	Enabled *bool  `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Header  string `json:"header,omitempty" yaml:"header,omitempty"`
+	Secret  string `json:"secret" yaml:"secret"`
+}
+
+type Admin struct {
+	Path  string `json:"path" yaml:"path"`
+	Token string `json:"token" yaml:"token"`
+}
+
+type BotDetection struct {
+	Threshold       int    `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+	Action          string `json:"action,omitempty" yaml:"action,omitempty"`
+	ChallengeSecret string `json:"challengeSecret,omitempty" yaml:"challengeSecret,omitempty"`
+	// ChallengeTTL is in seconds.
+	ChallengeTTL int `json:"challengeTTL,omitempty" yaml:"challengeTTL,omitempty"`
+}
+
+type SignedRequests struct {
+	Paths           []string `json:"paths" yaml:"paths"`
+	Secret          string   `json:"secret" yaml:"secret"`
+	TimestampHeader string   `json:"timestampHeader,omitempty" yaml:"timestampHeader,omitempty"`
+	SignatureHeader string   `json:"signatureHeader,omitempty" yaml:"signatureHeader,omitempty"`
+	// MaxSkew is in seconds.
+	MaxSkew     int `json:"maxSkew,omitempty" yaml:"maxSkew,omitempty"`
+	MaxBodySize int `json:"maxBodySize,omitempty" yaml:"maxBodySize,omitempty"`
+}
+
+type CSRF struct {
+	Paths  []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Cookie string   `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+	Header string   `json:"header,omitempty" yaml:"header,omitempty"`
+	Field  string   `json:"field,omitempty" yaml:"field,omitempty"`
+	Action string   `json:"action,omitempty" yaml:"action,omitempty"`
+}
+
+type BruteForce struct {
+	Paths           []string `json:"paths" yaml:"paths"`
+	FailureStatuses []int    `json:"failureStatuses,omitempty" yaml:"failureStatuses,omitempty"`
+	FailureHeader   string   `json:"failureHeader,omitempty" yaml:"failureHeader,omitempty"`
+	UsernameField   string   `json:"usernameField,omitempty" yaml:"usernameField,omitempty"`
+	MaxFailures     int      `json:"maxFailures,omitempty" yaml:"maxFailures,omitempty"`
+	// Window is in seconds.
+	Window int    `json:"window,omitempty" yaml:"window,omitempty"`
+	Action string `json:"action,omitempty" yaml:"action,omitempty"`
+}
+
+type GeoPolicy struct {
+	CountryHeader string `json:"countryHeader" yaml:"countryHeader"`
+	// Deny and AllowOnly are ISO 3166-1 alpha-2 country codes. AllowOnly is
+	// a pointer as an empty list, denying all the countries, has to be told
+	// apart from a missing one.
+	Deny      []string  `json:"deny,omitempty" yaml:"deny,omitempty"`
+	AllowOnly *[]string `json:"allowOnly,omitempty" yaml:"allowOnly,omitempty"`
+	Status    int       `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+type Honeypot struct {
+	Paths []string `json:"paths" yaml:"paths"`
+	// BanDuration is in seconds.
+	BanDuration int    `json:"banDuration,omitempty" yaml:"banDuration,omitempty"`
+	Status      int    `json:"status,omitempty" yaml:"status,omitempty"`
+	File        string `json:"file,omitempty" yaml:"file,omitempty"`
+}
+
+type TLS struct {
+	VersionHeader string `json:"versionHeader,omitempty" yaml:"versionHeader,omitempty"`
+	CipherHeader  string `json:"cipherHeader,omitempty" yaml:"cipherHeader,omitempty"`
+	SNIHeader     string `json:"sniHeader,omitempty" yaml:"sniHeader,omitempty"`
+	MinVersion    string `json:"minVersion,omitempty" yaml:"minVersion,omitempty"`
+	SNIMatch      bool   `json:"sniMatch,omitempty" yaml:"sniMatch,omitempty"`
+}
+
+// RequestLimits are in bytes, zero ones are not enforced.
+type RequestLimits struct {
+	MaxURILength   int `json:"maxURILength,omitempty" yaml:"maxURILength,omitempty"`
+	MaxHeaderSize  int `json:"maxHeaderSize,omitempty" yaml:"maxHeaderSize,omitempty"`
+	MaxHeadersSize int `json:"maxHeadersSize,omitempty" yaml:"maxHeadersSize,omitempty"`
+}
+
+type SlowRequests struct {
+	// MinRate is in bytes per second.
+	MinRate float64 `json:"minRate,omitempty" yaml:"minRate,omitempty"`
+	// GracePeriod is in seconds.
+	GracePeriod *float64 `json:"gracePeriod,omitempty" yaml:"gracePeriod,omitempty"`
+	Action      string   `json:"action,omitempty" yaml:"action,omitempty"`
+}
+
+type ScrubResponse struct {
+	// Headers replaces the default headers when not nil.
+	Headers    []string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Server     string   `json:"server,omitempty" yaml:"server,omitempty"`
+	ErrorPages bool     `json:"errorPages,omitempty" yaml:"errorPages,omitempty"`
+}
+
+type DataLeak struct {
+	Detectors   []string `json:"detectors" yaml:"detectors"`
+	Action      string   `json:"action,omitempty" yaml:"action,omitempty"`
+	MaxBodySize int      `json:"maxBodySize,omitempty" yaml:"maxBodySize,omitempty"`
+}
+
+type VirtualPatch struct {
+	ID      string                  `json:"id" yaml:"id"`
+	Path    string                  `json:"path" yaml:"path"`
+	Methods []string                `json:"methods,omitempty" yaml:"methods,omitempty"`
+	Params  []VirtualPatchParameter `json:"params,omitempty" yaml:"params,omitempty"`
+	Status  int                     `json:"status,omitempty" yaml:"status,omitempty"`
+	RuleID  int                     `json:"ruleId,omitempty" yaml:"ruleId,omitempty"`
+}
+
+type VirtualPatchParameter struct {
+	Name      string `json:"name" yaml:"name"`
+	Pattern   string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Allow     string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	MaxLength int    `json:"maxLength,omitempty" yaml:"maxLength,omitempty"`
+}
+
+type RateLimit struct {
+	By    string  `json:"by,omitempty" yaml:"by,omitempty"`
+	Rate  float64 `json:"rate" yaml:"rate"`
+	Burst float64 `json:"burst,omitempty" yaml:"burst,omitempty"`
+}
+
+type Maintenance struct {
+	Enabled     bool     `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Paths       []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Status      int      `json:"status,omitempty" yaml:"status,omitempty"`
+	ContentType string   `json:"contentType,omitempty" yaml:"contentType,omitempty"`
+	Body        string   `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+type BypassTokens struct {
+	Secret string `json:"secret" yaml:"secret"`
+	Header string `json:"header,omitempty" yaml:"header,omitempty"`
+	Cookie string `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+	// MaxLifetime is in seconds.
+	MaxLifetime int  `json:"maxLifetime,omitempty" yaml:"maxLifetime,omitempty"`
+	Log         bool `json:"log,omitempty" yaml:"log,omitempty"`
+}
+
+type Canary struct {
+	Directives Directives `json:"directives" yaml:"directives"`
+	Percent    float64    `json:"percent" yaml:"percent"`
+}
+
+type Shadow struct {
+	Directives Directives `json:"directives" yaml:"directives"`
+}
+
+// Marshal encodes the config into the JSON the guest expects.
+func Marshal(cfg Config) ([]byte, error) {
+	return json.Marshal(cfg)
+}
+
+// Unmarshal decodes a config in JSON. Like the guest, it ignores unknown
+// fields.
+func Unmarshal(data []byte, cfg *Config) error {
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return errors.New("invalid host config: " + err.Error())
+	}
+	return nil
+}
+
+// Validate builds the WAFs from the config the way the guest does, see
+// guest.Validate.
+func (cfg Config) Validate() (guest.ValidationReport, error) {
+	data, err := Marshal(cfg)
+	if err != nil {
+		return guest.ValidationReport{}, err
+	}
+	return guest.Validate(data)
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// fullConfig sets all the fields, to catch the ones the types would drop.
+const fullConfig = `{
+	"directives": ["SecRuleEngine On", "SecRequestBodyAccess On", "SecResponseBodyAccess On"],
+	"versionCheck": ">=0.0.0",
+	"includeCRS": false,
+	"protocolChecks": false,
+	"verdictHeaders": true,
+	"inspectHostErrors": true,
+	"clientIPHeader": "X-Forwarded-For",
+	"defaultClientIP": "127.0.0.1",
+	"mode": "detect",
+	"killSwitch": {"file": "/etc/coraza/killswitch", "interval": 0, "engaged": true},
+	"inflightTimeout": 30,
+	"debugDump": {"enabled": false, "header": "X-Debug", "secret": "0123456789abcdef"},
+	"admin": {"path": "/_coraza", "token": "token"},
+	"botDetection": {"threshold": 5, "action": "challenge", "challengeSecret": "secret", "challengeTTL": 60},
+	"signedRequests": {"paths": ["/webhooks/"], "secret": "0123456789abcdef0123456789abcdef", "timestampHeader": "X-Ts", "signatureHeader": "X-Sig", "maxSkew": 60, "maxBodySize": 1024},
+	"csrf": {"paths": ["/account/"], "cookie": "csrf", "header": "X-Csrf", "field": "csrf", "action": "log"},
+	"bruteForce": {"paths": ["/login"], "failureStatuses": [401], "failureHeader": "X-Failed", "usernameField": "user", "maxFailures": 5, "window": 60, "action": "log"},
+	"geoPolicy": {"countryHeader": "CF-IPCountry", "deny": ["KP"], "allowOnly": ["FR"], "status": 451},
+	"honeypot": {"paths": ["/.env"], "banDuration": 60, "status": 404, "file": "/var/lib/coraza/bans"},
+	"tls": {"versionHeader": "X-Tls-Version", "cipherHeader": "X-Tls-Cipher", "sniHeader": "X-Tls-Sni", "minVersion": "1.2", "sniMatch": true},
+	"allowedMethods": ["GET", "POST"],
+	"requestLimits": {"maxURILength": 8192, "maxHeaderSize": 8192, "maxHeadersSize": 65536},
+	"slowRequests": {"minRate": 512, "gracePeriod": 0, "action": "log"},
+	"scrubResponse": {"headers": ["Server"], "server": "waf", "errorPages": true},
+	"dataLeak": {"detectors": ["creditCard"], "action": "mask", "maxBodySize": 1024},
+	"virtualPatches": [{"id": "CVE-2021-44228", "path": "^/api/", "methods": ["POST"], "params": [{"name": "q", "pattern": "jndi", "allow": "^\\w+$", "maxLength": 64}], "status": 403, "ruleId": 4800000}],
+	"rateLimits": [{"by": "ip", "rate": 10, "burst": 20}],
+	"maintenance": {"enabled": true, "paths": ["/api/"], "status": 503, "contentType": "text/plain", "body": "later"},
+	"bypassTokens": {"secret": "0123456789abcdef0123456789abcdef", "header": "X-Bypass", "cookie": "bypass", "maxLifetime": 86400, "log": true},
+	"canary": {"directives": ["SecRuleEngine On"], "percent": 0},
+	"shadow": {"directives": ["SecRuleEngine DetectionOnly"]}
+}`
+
+func TestRoundTrip(t *testing.T) {
+	for name, in := range map[string]string{
+		"full": fullConfig,
+		// An empty allowOnly denies all the countries, unlike a missing one.
+		"empty allowOnly": `{"directives": ["SecRuleEngine On"], "geoPolicy": {"countryHeader": "CF-IPCountry", "allowOnly": []}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var cfg Config
+			require.NoError(t, Unmarshal([]byte(in), &cfg))
+			data, err := Marshal(cfg)
+			require.NoError(t, err)
+			require.JSONEq(t, in, string(data))
+
+			var fromYAML Config
+			out, err := yaml.Marshal(cfg)
+			require.NoError(t, err)
+			require.NoError(t, yaml.Unmarshal(out, &fromYAML))
+			require.Equal(t, cfg, fromYAML)
+		})
+	}
+}
+
+func TestDirectivesString(t *testing.T) {
+	var cfg Config
+	require.NoError(t, Unmarshal([]byte(`{"directives": "SecRuleEngine On\nSecRequestBodyAccess On"}`), &cfg))
+	require.Equal(t, Directives{"SecRuleEngine On\nSecRequestBodyAccess On"}, cfg.Directives)
+
+	require.NoError(t, yaml.Unmarshal([]byte("directives: |\n  SecRuleEngine On\n"), &cfg))
+	require.Equal(t, Directives{"SecRuleEngine On\n"}, cfg.Directives)
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		var cfg Config
+		require.NoError(t, Unmarshal([]byte(fullConfig), &cfg))
+		// Test builds have no version to check.
+		cfg.VersionCheck = ""
+		_, err := cfg.Validate()
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		cfg := Config{
+			Directives: Directives{"SecRuleEngine On"},
+			RateLimits: []RateLimit{{By: "user", Rate: 1}},
+		}
+		_, err := cfg.Validate()
+		require.EqualError(t, err, "invalid host config, ip, ip+path or fingerprint expected for field rateLimits.by")
+	})
+}
+
+// TestGuestSchema checks that the guest parses the fields of the types and
+// nothing else, as the config schema is written both here and in the guest.
+// The guest side is read from its sources: the keys of parseConfig, then
+// the keys looked up with Get by the function parsing each object, or the
+// JSON tags of the types the objects decoded with encoding/json go into, and
+// the paths looked up with gjson at runtime.
+func TestGuestSchema(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, "../guest", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	require.NoError(t, err)
+	funcs := map[string]*ast.FuncDecl{}
+	structs := map[string]*ast.StructType{}
+	for _, file := range pkgs["guest"].Files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				funcs[decl.Name.Name] = decl
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if spec, ok := spec.(*ast.TypeSpec); ok {
+						if st, ok := spec.Type.(*ast.StructType); ok {
+							structs[spec.Name.Name] = st
+						}
+					}
+				}
+			}
+		}
+	}
+
+	guest := schema{}
+	ast.Inspect(funcs["parseConfig"], func(n ast.Node) bool {
+		clause, ok := n.(*ast.CaseClause)
+		if !ok || len(clause.List) != 1 {
+			return true
+		}
+		key := stringLit(clause.List[0])
+		guest[key] = nil
+		ast.Inspect(&ast.BlockStmt{List: clause.Body}, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if fn, ok := call.Fun.(*ast.Ident); ok && strings.HasPrefix(fn.Name, "parse") && funcs[fn.Name] != nil {
+					guest[key] = parsedKeys(funcs[fn.Name], structs)
+				}
+			}
+			return true
+		})
+		return true
+	})
+
+	// Fields read at runtime, e.g. killSwitch.engaged.
+	for _, file := range pkgs["guest"].Files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 2 {
+				return true
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && isIdent(sel.X, "gjson") && strings.HasPrefix(sel.Sel.Name, "Get") {
+				object, key, _ := strings.Cut(stringLit(call.Args[1]), ".")
+				if guest[object] == nil {
+					guest[object] = schema{}
+				}
+				guest[object][key] = nil
+			}
+			return true
+		})
+	}
+
+	require.Equal(t, typeSchema(reflect.TypeOf(Config{})), guest)
+}
+
+// schema maps the keys of an object to the schema of their value, nil for
+// the values that are not objects.
+type schema map[string]schema
+
+// typeSchema returns the schema of the JSON encoding of t.
+func typeSchema(t reflect.Type) schema {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	s := schema{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		s[name] = typeSchema(t.Field(i).Type)
+	}
+	return s
+}
+
+// parsedKeys returns the schema of the object parsed by fn, nil if none.
+func parsedKeys(fn *ast.FuncDecl, structs map[string]*ast.StructType) schema {
+	s := schema{}
+	ast.Inspect(fn, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok || len(n.Args) == 0 {
+				break
+			}
+			if sel.Sel.Name == "Get" {
+				if key := stringLit(n.Args[0]); key != "" {
+					s[key] = nil
+				}
+			}
+			if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "json" && sel.Sel.Name == "Unmarshal" {
+				if v, ok := n.Args[1].(*ast.UnaryExpr); ok {
+					for k, v := range structSchema(v.X.(*ast.Ident).Obj.Decl.(*ast.ValueSpec).Type, structs) {
+						s[k] = v
+					}
+				}
+			}
+		case *ast.CompositeLit:
+			// Keys iterated over, e.g. the request limits.
+			if m, ok := n.Type.(*ast.MapType); ok && isIdent(m.Key, "string") {
+				for _, elt := range n.Elts {
+					s[stringLit(elt.(*ast.KeyValueExpr).Key)] = nil
+				}
+			}
+		}
+		return true
+	})
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// structSchema returns the schema of the JSON encoding of the guest type t.
+func structSchema(t ast.Expr, structs map[string]*ast.StructType) schema {
+	for {
+		switch e := t.(type) {
+		case *ast.ArrayType:
+			t = e.Elt
+			continue
+		case *ast.StarExpr:
+			t = e.X
+			continue
+		}
+		break
+	}
+	ident, ok := t.(*ast.Ident)
+	if !ok || structs[ident.Name] == nil {
+		return nil
+	}
+	s := schema{}
+	for _, field := range structs[ident.Name].Fields.List {
+		tag, _ := strconv.Unquote(field.Tag.Value)
+		name, _, _ := strings.Cut(reflect.StructTag(tag).Get("json"), ",")
+		s[name] = structSchema(field.Type, structs)
+	}
+	return s
+}
+
+func stringLit(e ast.Expr) string {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+	s, _ := strconv.Unquote(lit.Value)
+	return s
+}
+
+func isIdent(e ast.Expr, name string) bool {
+	ident, ok := e.(*ast.Ident)
+	return ok && ident.Name == name
+}
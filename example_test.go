@@ -14,19 +14,19 @@ import (
 )
 
 //go:embed build/coraza-http-wasm.wasm
-var guest string
+var guestWasm string
 
 func exampleHandler(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	_, _ = w.Write([]byte("Hello world, transaction not disrupted."))
 }
 
-func ExampleMain() {
+func Example() {
 	ctx := context.Background()
 
 	h, err := wasm.NewMiddleware(
 		ctx,
-		[]byte(guest),
+		[]byte(guestWasm),
 		handler.GuestConfig([]byte(`
 		{
 			"directives": [
@@ -67,13 +67,13 @@ func ExampleMain() {
 	// Output: 403
 }
 
-func ExampleFS() {
+func Example_fs() {
 	moduleConfig := wazero.
 		NewModuleConfig().
 		// Mount the directory as read-only at the root of the guest filesystem.
 		WithFSConfig(wazero.NewFSConfig().WithReadOnlyDirMount("./testdata", "/"))
 
-	mw, err := nethttp.NewMiddleware(context.Background(), []byte(guest),
+	mw, err := nethttp.NewMiddleware(context.Background(), []byte(guestWasm),
 		handler.ModuleConfig(moduleConfig),
 		handler.GuestConfig([]byte("{\"directives\": [ \"Include ./directives.conf\", \"Include @crs-setup.conf.example\" ]}")),
 	)
@@ -0,0 +1,81 @@
+//go:build e2e && go1.24
+
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/http-wasm/http-wasm-host-go/handler"
+	nethttp "github.com/http-wasm/http-wasm-host-go/handler/nethttp"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+)
+
+// The standard Go build needs Go 1.24, the toolchain running the tests is
+// the one building it.
+func init() {
+	// nethttp-go runs the standard Go build under the reference host, which
+	// has to run its _initialize export.
+	e2eHosts["nethttp-go"] = nethttpHost(standardGoGuest,
+		handler.ModuleConfig(wazero.NewModuleConfig().WithStartFunctions("_initialize")))
+}
+
+var (
+	standardGoOnce sync.Once
+	standardGoWasm []byte
+	standardGoErr  error
+)
+
+// standardGoGuest builds the module with the standard Go toolchain, as
+// "mage buildGo" does, once for all the tests.
+func standardGoGuest(t *testing.T) []byte {
+	t.Helper()
+	standardGoOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "coraza-http-wasm-go")
+		if err != nil {
+			standardGoErr = err
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		out := filepath.Join(dir, "coraza-http-wasm-go.wasm")
+		cmd := exec.Command("go", "build", "-o", out, "-buildmode=c-shared", "-tags=no_fs_access", ".")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			standardGoErr = fmt.Errorf("%v: %s", err, output)
+			return
+		}
+		standardGoWasm, standardGoErr = os.ReadFile(out)
+	})
+	require.NoError(t, standardGoErr)
+	return standardGoWasm
+}
+
+// TestE2EStandardGoBuildStart checks the standard Go build fails rather than
+// misbehaves under a host not running its _initialize export, the Go
+// runtime being then left uninitialized.
+func TestE2EStandardGoBuildStart(t *testing.T) {
+	mw, err := nethttp.NewMiddleware(testCtx, standardGoGuest(t),
+		handler.Logger(testLogger{t}),
+		handler.GuestConfig(hostsConfig("SecRuleEngine On")),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { mw.Close(testCtx) })
+
+	ts := httptest.NewServer(mw.NewHandler(testCtx, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("the request should not reach the upstream")
+	})))
+	t.Cleanup(ts.Close)
+
+	res, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
@@ -0,0 +1,367 @@
+package guest
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/debuglog"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// overrides holds the changes made through the admin API. They are applied
+// as directives on top of the configured ones, hence kept across reloads.
+type overrides struct {
+	// disabledRules holds the IDs of the rules removed, sorted.
+	disabledRules []int
+	// debugLogLevel replaces the configured SecDebugLogLevel while active,
+	// see revertDebugLogLevel.
+	debugLogLevel *debugLogLevelOverride
+	// mode replaces the configured mode when set.
+	mode string
+	// virtualPatches holds the patches added at runtime, applied after the
+	// configured ones.
+	virtualPatches []virtualPatch
+	// maintenance replaces the configured maintenance switch when set.
+	maintenance    bool
+	setMaintenance bool
+}
+
+// directives returns the directives applying the overrides.
+func (o overrides) directives() string {
+	var b strings.Builder
+	if len(o.disabledRules) > 0 {
+		b.WriteString("SecRuleRemoveById")
+		for _, id := range o.disabledRules {
+			b.WriteString(" " + strconv.Itoa(id))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// adminMu serializes the changes made through the admin API, so that
+// concurrent ones are not lost when swapping the engine.
+var adminMu sync.Mutex
+
+// updateEngine rebuilds the engine from the host config with the overrides
+// changed by update, and makes it the active one. The features can't be
+// negotiated again once initialized, hence the ones granted are kept.
+func updateEngine(update func(ov *overrides)) error {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+
+	prev := activeEngine.Load()
+	ov := prev.overrides
+	ov.disabledRules = slices.Clone(ov.disabledRules)
+	ov.virtualPatches = slices.Clone(ov.virtualPatches)
+	update(&ov)
+
+	e, err := initializeWAF(prev.host, ov)
+	if err != nil {
+		return err
+	}
+
+	e.features = prev.features
+	if slices.Equal(e.cfg.rateLimits, prev.cfg.rateLimits) {
+		e.limiter = prev.limiter
+	}
+	if e.headerOnly() && !prev.headerOnly() {
+		e.host.Log(api.LogLevelWarn, "Running in header-only mode, the reloaded ruleset needs features not granted at startup")
+	}
+	activeEngine.Store(e)
+	return nil
+}
+
+// adminStatus is the body of the admin status endpoint.
+type adminStatus struct {
+	Version              string     `json:"version"`
+	Commit               string     `json:"commit"`
+	Mode                 string     `json:"mode"`
+	KillSwitch           bool       `json:"killSwitchEngaged"`
+	Maintenance          bool       `json:"maintenance"`
+	HeaderOnly           bool       `json:"headerOnly"`
+	Features             string     `json:"features"`
+	RequestBody          bool       `json:"requestBodyInspection"`
+	ResponsePhases       bool       `json:"responseInspection"`
+	DisabledRules        []int      `json:"disabledRules"`
+	DebugLogLevel        *int       `json:"debugLogLevel,omitempty"`
+	DebugLogLevelUntil   *time.Time `json:"debugLogLevelUntil,omitempty"`
+	InFlightTransactions int        `json:"inFlightTransactions"`
+}
+
+// serveAdmin serves the admin API under the configured path, returning false
+// when the request is not for it. Admin requests are not inspected by the WAF
+// and have to carry the configured bearer token:
+//
+//	GET  <path>/status                 engine status, as JSON
+//	GET  <path>/metrics                counters, in the Prometheus text format
+//	POST <path>/reload                 rebuilds the WAF from the host config
+//	POST <path>/rules/<id>/disable     removes the rule
+//	POST <path>/rules/<id>/enable      restores the rule
+//	POST <path>/loglevel?level=<0-9>   sets the debug log level, for the
+//	     [&duration=<seconds>]         given duration if any
+//	POST <path>/mode?value=<mode>      switches the mode, see modeEnforce
+//	POST <path>/maintenance?value=<on|off>
+//	                                   switches maintenance, see serveMaintenance
+//	GET  <path>/patches                virtual patches, as JSON
+//	POST <path>/patches                adds or replaces the virtual patch in the body
+//	DELETE <path>/patches/<id>         removes the virtual patch
+//
+// Changes are applied by rebuilding the WAF, see updateEngine.
+func (e *engine) serveAdmin(req api.Request, res api.Response) bool {
+	u, err := url.ParseRequestURI(req.GetURI())
+	if err != nil {
+		return false
+	}
+
+	route, ok := strings.CutPrefix(u.Path, e.cfg.admin.path)
+	if !ok || (route != "" && route[0] != '/') {
+		return false
+	}
+
+	auth, _ := req.Headers().Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(e.cfg.admin.token)) != 1 {
+		res.Headers().Set("WWW-Authenticate", "Bearer")
+		writeAdminResponse(res, http.StatusUnauthorized, "text/plain", "unauthorized")
+		return true
+	}
+
+	method := req.GetMethod()
+	switch {
+	case route == "/status":
+		if method != http.MethodGet {
+			break
+		}
+		status := adminStatus{
+			Version:              Version,
+			Commit:               Commit,
+			Mode:                 e.mode(),
+			KillSwitch:           e.killSwitchEngaged(),
+			Maintenance:          e.maintenance(),
+			HeaderOnly:           e.headerOnly(),
+			Features:             e.features.String(),
+			RequestBody:          e.inspectRequestBody(),
+			ResponsePhases:       e.ruleset.responsePhases && e.features.IsEnabled(api.FeatureBufferResponse),
+			DisabledRules:        e.overrides.disabledRules,
+			InFlightTransactions: txs.len(),
+		}
+		if status.DisabledRules == nil {
+			status.DisabledRules = []int{}
+		}
+		if o := e.overrides.debugLogLevel; o.active() {
+			lvl := int(o.level)
+			status.DebugLogLevel = &lvl
+			if !o.until.IsZero() {
+				status.DebugLogLevelUntil = &o.until
+			}
+		}
+		body, _ := json.Marshal(status)
+		writeAdminResponse(res, http.StatusOK, "application/json", string(body))
+		return true
+	case route == "/metrics":
+		if method != http.MethodGet {
+			break
+		}
+		var b strings.Builder
+		writeMetrics(&b)
+		writeAdminResponse(res, http.StatusOK, "text/plain; version=0.0.4", b.String())
+		return true
+	case route == "/reload":
+		if method != http.MethodPost {
+			break
+		}
+		serveAdminUpdate(res, "reloaded", func(*overrides) {})
+		return true
+	case strings.HasPrefix(route, "/rules/"):
+		if method != http.MethodPost {
+			break
+		}
+		idStr, action, _ := strings.Cut(strings.TrimPrefix(route, "/rules/"), "/")
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id <= 0 || (action != "disable" && action != "enable") {
+			writeAdminResponse(res, http.StatusNotFound, "text/plain", "not found")
+			return true
+		}
+		serveAdminUpdate(res, "rule "+idStr+" "+action+"d", func(ov *overrides) {
+			i, found := slices.BinarySearch(ov.disabledRules, id)
+			switch {
+			case action == "disable" && !found:
+				ov.disabledRules = slices.Insert(ov.disabledRules, i, id)
+			case action == "enable" && found:
+				ov.disabledRules = slices.Delete(ov.disabledRules, i, i+1)
+			}
+		})
+		return true
+	case route == "/loglevel":
+		if method != http.MethodPost {
+			break
+		}
+		lvl, err := strconv.Atoi(u.Query().Get("level"))
+		if err != nil || lvl < int(debuglog.LevelNoLog) || lvl > int(debuglog.LevelTrace) {
+			writeAdminResponse(res, http.StatusBadRequest, "text/plain", "level between 0 and 9 expected")
+			return true
+		}
+		var until time.Time
+		done := "debug log level set to " + strconv.Itoa(lvl)
+		if d := u.Query().Get("duration"); d != "" {
+			secs, err := strconv.Atoi(d)
+			if err != nil || secs <= 0 {
+				writeAdminResponse(res, http.StatusBadRequest, "text/plain", "positive duration in seconds expected")
+				return true
+			}
+			until = timeSource.Now().Add(time.Duration(secs) * time.Second)
+			done += " for " + d + "s"
+		}
+		serveAdminUpdate(res, done, func(ov *overrides) {
+			ov.debugLogLevel = &debugLogLevelOverride{level: debuglog.Level(lvl), until: until}
+		})
+		return true
+	case route == "/mode":
+		if method != http.MethodPost {
+			break
+		}
+		mode := u.Query().Get("value")
+		if !validMode(mode) {
+			writeAdminResponse(res, http.StatusBadRequest, "text/plain", "enforce, detect or bypass expected")
+			return true
+		}
+		serveAdminUpdate(res, "mode set to "+mode, func(ov *overrides) {
+			ov.mode = mode
+		})
+		return true
+	case route == "/patches":
+		switch method {
+		case http.MethodGet:
+			body, _ := json.Marshal(adminPatches{
+				Configured: nonNil(e.cfg.virtualPatches),
+				Runtime:    nonNil(e.overrides.virtualPatches),
+			})
+			writeAdminResponse(res, http.StatusOK, "application/json", string(body))
+			return true
+		case http.MethodPost:
+			e.serveAddPatch(req, res)
+			return true
+		}
+	case strings.HasPrefix(route, "/patches/"):
+		if method != http.MethodDelete {
+			break
+		}
+		id := strings.TrimPrefix(route, "/patches/")
+		i := slices.IndexFunc(e.overrides.virtualPatches, func(p virtualPatch) bool { return p.ID == id })
+		if i < 0 {
+			writeAdminResponse(res, http.StatusNotFound, "text/plain", "no patch "+id+" added at runtime")
+			return true
+		}
+		serveAdminUpdate(res, "virtual patch "+id+" removed", func(ov *overrides) {
+			ov.virtualPatches = slices.DeleteFunc(ov.virtualPatches, func(p virtualPatch) bool { return p.ID == id })
+		})
+		return true
+	case route == "/maintenance":
+		if method != http.MethodPost {
+			break
+		}
+		value := u.Query().Get("value")
+		if value != "on" && value != "off" {
+			writeAdminResponse(res, http.StatusBadRequest, "text/plain", "on or off expected")
+			return true
+		}
+		serveAdminUpdate(res, "maintenance "+value, func(ov *overrides) {
+			ov.maintenance = value == "on"
+			ov.setMaintenance = true
+		})
+		return true
+	default:
+		writeAdminResponse(res, http.StatusNotFound, "text/plain", "not found")
+		return true
+	}
+
+	writeAdminResponse(res, http.StatusMethodNotAllowed, "text/plain", "method not allowed")
+	return true
+}
+
+// maxAdminBodySize bounds the body of the admin requests.
+const maxAdminBodySize = 64 << 10
+
+// adminPatches is the body of the admin virtual patches endpoint.
+type adminPatches struct {
+	Configured []virtualPatch `json:"configured"`
+	Runtime    []virtualPatch `json:"runtime"`
+}
+
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// serveAddPatch adds the virtual patch in the request body, replacing the one
+// added at runtime with the same ID if any.
+func (e *engine) serveAddPatch(req api.Request, res api.Response) {
+	var (
+		body  strings.Builder
+		chunk [1024]byte
+	)
+	for body.Len() <= maxAdminBodySize {
+		size, eof := req.Body().Read(chunk[:])
+		body.Write(chunk[:size])
+		if eof || size == 0 {
+			break
+		}
+	}
+	if body.Len() > maxAdminBodySize {
+		writeAdminResponse(res, http.StatusRequestEntityTooLarge, "text/plain", "patch too large")
+		return
+	}
+
+	var p virtualPatch
+	if err := json.Unmarshal([]byte(body.String()), &p); err != nil {
+		writeAdminResponse(res, http.StatusBadRequest, "text/plain", "invalid patch: "+err.Error())
+		return
+	}
+	if err := p.validate(); err != nil {
+		writeAdminResponse(res, http.StatusBadRequest, "text/plain", err.Error())
+		return
+	}
+	if slices.ContainsFunc(e.cfg.virtualPatches, func(c virtualPatch) bool { return c.ID == p.ID }) {
+		writeAdminResponse(res, http.StatusConflict, "text/plain", "patch "+p.ID+" is configured")
+		return
+	}
+	others := slices.Concat(e.cfg.virtualPatches, slices.DeleteFunc(slices.Clone(e.overrides.virtualPatches), func(c virtualPatch) bool { return c.ID == p.ID }))
+	if err := checkVirtualPatchRuleIDs(append(others, p)); err != nil {
+		writeAdminResponse(res, http.StatusConflict, "text/plain", err.Error())
+		return
+	}
+
+	serveAdminUpdate(res, "virtual patch "+p.ID+" applied", func(ov *overrides) {
+		if i := slices.IndexFunc(ov.virtualPatches, func(c virtualPatch) bool { return c.ID == p.ID }); i >= 0 {
+			ov.virtualPatches[i] = p
+		} else {
+			ov.virtualPatches = append(ov.virtualPatches, p)
+		}
+	})
+}
+
+// serveAdminUpdate applies an admin change and reports the outcome.
+func serveAdminUpdate(res api.Response, done string, update func(ov *overrides)) {
+	if err := updateEngine(update); err != nil {
+		writeAdminResponse(res, http.StatusInternalServerError, "text/plain", err.Error())
+		return
+	}
+	activeEngine.Load().host.Log(api.LogLevelInfo, "Admin API: "+done)
+	writeAdminResponse(res, http.StatusOK, "text/plain", done)
+}
+
+func writeAdminResponse(res api.Response, statusCode uint32, contentType, body string) {
+	res.Headers().Set("Content-Type", contentType)
+	res.SetStatusCode(statusCode)
+	res.Body().WriteString(body)
+}
@@ -0,0 +1,156 @@
+package guest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	const cfg = `
+	{
+		"admin": {"path": "/_coraza", "token": "secret"},
+		"directives": [
+			"SecRuleEngine On",
+			"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\""
+		]
+	}`
+
+	admin := func(method, uri string) *mockResponse {
+		req := newMockRequest(method, uri, "")
+		req.headers.Set("Authorization", "Bearer secret")
+		res := newMockResponse(200, "")
+		next, _ := HandleRequest(req, res)
+		require.False(t, next, "admin requests are not forwarded")
+		return res
+	}
+
+	blocked := func() bool {
+		res := newMockResponse(200, "")
+		serve(newMockRequest("GET", "/?id=0", ""), res)
+		return res.statusCode == 403
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		useEngine(t, cfg)
+
+		for _, auth := range []string{"", "Bearer wrong", "secret"} {
+			req := newMockRequest("GET", "/_coraza/status", "")
+			if auth != "" {
+				req.headers.Set("Authorization", auth)
+			}
+			res := newMockResponse(200, "")
+			next, _ := HandleRequest(req, res)
+			require.False(t, next)
+			require.Equal(t, uint32(401), res.statusCode)
+		}
+	})
+
+	t.Run("other paths are not served", func(t *testing.T) {
+		useEngine(t, cfg)
+
+		for _, uri := range []string{"/", "/_corazax/status", "/status?p=/_coraza"} {
+			next, _ := HandleRequest(newMockRequest("GET", uri, ""), newMockResponse(200, ""))
+			require.True(t, next, uri)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		useEngine(t, cfg)
+
+		res := admin("GET", "/_coraza/status")
+		require.Equal(t, uint32(200), res.statusCode)
+		var status adminStatus
+		require.NoError(t, json.Unmarshal(res.body.written, &status))
+		require.Equal(t, Version, status.Version)
+		require.False(t, status.HeaderOnly)
+		require.Empty(t, status.DisabledRules)
+		require.Nil(t, status.DebugLogLevel)
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		useEngine(t, cfg)
+		require.True(t, blocked())
+
+		res := admin("GET", "/_coraza/metrics")
+		require.Equal(t, uint32(200), res.statusCode)
+		require.Contains(t, string(res.body.written), "# TYPE coraza_requests_total counter\ncoraza_requests_total ")
+		require.Contains(t, string(res.body.written), `coraza_interruptions_total{phase="request"} `)
+		require.Contains(t, string(res.body.written), "coraza_inflight_transactions 0\n")
+	})
+
+	t.Run("rule toggle", func(t *testing.T) {
+		useEngine(t, cfg)
+
+		require.Equal(t, uint32(200), admin("POST", "/_coraza/rules/1/disable").statusCode)
+		require.False(t, blocked())
+		require.Equal(t, []int{1}, activeEngine.Load().overrides.disabledRules)
+
+		// Disabled rules are kept across reloads.
+		require.Equal(t, uint32(200), admin("POST", "/_coraza/reload").statusCode)
+		require.False(t, blocked())
+
+		require.Equal(t, uint32(200), admin("POST", "/_coraza/rules/1/enable").statusCode)
+		require.True(t, blocked())
+		require.Empty(t, activeEngine.Load().overrides.disabledRules)
+	})
+
+	t.Run("log level", func(t *testing.T) {
+		useEngine(t, cfg)
+
+		require.Equal(t, uint32(400), admin("POST", "/_coraza/loglevel?level=10").statusCode)
+		require.Equal(t, uint32(200), admin("POST", "/_coraza/loglevel?level=9").statusCode)
+
+		var status adminStatus
+		require.NoError(t, json.Unmarshal(admin("GET", "/_coraza/status").body.written, &status))
+		require.Equal(t, 9, *status.DebugLogLevel)
+		require.Nil(t, status.DebugLogLevelUntil)
+	})
+
+	t.Run("log level window", func(t *testing.T) {
+		useEngine(t, cfg)
+
+		require.Equal(t, uint32(400), admin("POST", "/_coraza/loglevel?level=9&duration=0").statusCode)
+		require.Equal(t, uint32(200), admin("POST", "/_coraza/loglevel?level=9&duration=60").statusCode)
+
+		var status adminStatus
+		require.NoError(t, json.Unmarshal(admin("GET", "/_coraza/status").body.written, &status))
+		require.Equal(t, 9, *status.DebugLogLevel)
+		require.NotNil(t, status.DebugLogLevelUntil)
+
+		e := activeEngine.Load()
+		debugEnabled := func() bool {
+			tx := e.waf.NewTransaction()
+			defer tx.Close()
+			return tx.DebugLogger().Trace().IsEnabled()
+		}
+		require.True(t, debugEnabled())
+		require.False(t, e.revertDebugLogLevel(time.Now()))
+		require.True(t, e.revertDebugLogLevel(time.Now().Add(time.Minute)))
+		require.False(t, e.revertDebugLogLevel(time.Now().Add(time.Minute)), "already reverted")
+		require.Same(t, e, activeEngine.Load(), "the WAF is not rebuilt")
+		require.False(t, debugEnabled())
+
+		status = adminStatus{}
+		require.NoError(t, json.Unmarshal(admin("GET", "/_coraza/status").body.written, &status))
+		require.Nil(t, status.DebugLogLevel)
+		require.True(t, blocked())
+
+		// The reverted level is kept across reloads.
+		require.Equal(t, uint32(200), admin("POST", "/_coraza/reload").statusCode)
+		e = activeEngine.Load()
+		require.False(t, debugEnabled())
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		useEngine(t, cfg)
+
+		require.Equal(t, uint32(405), admin("GET", "/_coraza/reload").statusCode)
+		require.Equal(t, uint32(405), admin("POST", "/_coraza/status").statusCode)
+		require.Equal(t, uint32(404), admin("GET", "/_coraza/unknown").statusCode)
+		require.Equal(t, uint32(404), admin("POST", "/_coraza/rules/abc/disable").statusCode)
+		require.Equal(t, uint32(404), admin("POST", "/_coraza/rules/1/toggle").statusCode)
+	})
+}
@@ -0,0 +1,108 @@
+package guest
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// bodySegmentSize is the size of the segments bodies are read in from the
+// host before being written into the transaction.
+const bodySegmentSize = 16 * 1024
+
+var bodySegments = sync.Pool{
+	New: func() interface{} {
+		segment := make([]byte, bodySegmentSize)
+		return &segment
+	},
+}
+
+// responseHasBody tells whether the response may carry a body: the responses
+// to HEAD requests and with a 1xx, 204 or 304 status never do, see RFC 9110
+// section 6.4.1. Hosts emit malformed responses when a body or a
+// Content-Length is set on those.
+func responseHasBody(method string, statusCode uint32) bool {
+	return method != http.MethodHead && statusCode >= 200 && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+// bodyTruncater is implemented by the bodies which can be emptied. Writing no
+// bytes overwrites a body with nothing as per the ABI, but the TinyGo guest
+// library skips empty writes, leaving the original body.
+type bodyTruncater interface {
+	Truncate()
+}
+
+// replaceResponseBody replaces the body of the upstream response. The framing
+// and encoding headers and the trailers of the upstream describe its body,
+// they are replaced with a Content-Length matching the new one so that hosts
+// neither chunk nor truncate it. The body must be replaced at most once per
+// response, as writes past the first one append to it.
+func (e *engine) replaceResponseBody(resp api.Response, body []byte) {
+	resp.Headers().Remove("Transfer-Encoding")
+	resp.Headers().Remove("Content-Encoding")
+	// A response framed by its Content-Length can't carry trailers.
+	resp.Headers().Remove("Trailer")
+	if e.features.IsEnabled(api.FeatureTrailers) {
+		for _, name := range resp.Trailers().Names() {
+			resp.Trailers().Remove(name)
+		}
+	}
+	resp.Headers().Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Body().Write(body)
+}
+
+// truncateResponseBody empties the body of the upstream response, once its
+// status is set. When the body can't be emptied, it is replaced with the
+// status text so that the upstream one still never reaches the client.
+func (e *engine) truncateResponseBody(resp api.Response) {
+	if body, ok := resp.Body().(bodyTruncater); ok {
+		e.replaceResponseBody(resp, nil)
+		body.Truncate()
+		return
+	}
+
+	status := int(resp.GetStatusCode())
+	resp.Headers().Set("Content-Type", "text/plain; charset=utf-8")
+	e.replaceResponseBody(resp, []byte(strconv.Itoa(status)+" "+http.StatusText(status)))
+}
+
+// bodyWriter writes a body segment into the transaction e.g.
+// types.Transaction.WriteRequestBody.
+type bodyWriter func([]byte) (*types.Interruption, int, error)
+
+// bodyReader reads a body in segments e.g. api.Body.
+type bodyReader interface {
+	Read([]byte) (size uint32, eof bool)
+}
+
+// copyBody feeds the body into the transaction in fixed size segments, so
+// that on top of what the transaction buffers (bounded by the body limits) the
+// guest only holds a single segment no matter the body size. It stops reading
+// as soon as the transaction gets interrupted or stops accepting data e.g. the
+// body limit was reached with SecRequestBodyLimitAction ProcessPartial.
+func copyBody(write bodyWriter, body bodyReader) (*types.Interruption, error) {
+	segment := bodySegments.Get().(*[]byte)
+	defer bodySegments.Put(segment)
+
+	for {
+		size, eof := body.Read(*segment)
+		if size > 0 {
+			it, n, err := write((*segment)[:size])
+			if err != nil || it != nil {
+				return it, err
+			}
+
+			if n < int(size) {
+				return nil, nil
+			}
+		}
+
+		// Zero length is possible on EOF.
+		if eof || size == 0 {
+			return nil, nil
+		}
+	}
+}
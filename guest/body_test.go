@@ -0,0 +1,151 @@
+package guest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/corazawaf/coraza/v3"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+	"github.com/stretchr/testify/require"
+)
+
+// mockBody implements api.Body on top of an in-memory buffer.
+type mockBody struct {
+	api.Body
+	r       *bytes.Reader
+	reads   int
+	written []byte
+}
+
+func (b *mockBody) Read(p []byte) (uint32, bool) {
+	b.reads++
+	n, _ := b.r.Read(p)
+	return uint32(n), b.r.Len() == 0
+}
+
+func (b *mockBody) Write(p []byte) {
+	b.written = append(b.written, p...)
+}
+
+func (b *mockBody) WriteString(s string) {
+	b.written = append(b.written, s...)
+}
+
+// Truncate empties the body as the standard Go build does, see
+// untruncatableResponse for the TinyGo one.
+func (b *mockBody) Truncate() {
+	b.written = []byte{}
+}
+
+// untruncatableResponse is a response whose body can't be emptied, as with
+// the TinyGo build.
+type untruncatableResponse struct {
+	*mockResponse
+}
+
+func (r untruncatableResponse) Body() api.Body {
+	return struct{ api.Body }{r.body}
+}
+
+func TestCopyBody(t *testing.T) {
+	t.Run("whole body", func(t *testing.T) {
+		waf, err := coraza.NewWAF(coraza.NewWAFConfig().WithDirectives("SecRuleEngine On\nSecRequestBodyAccess On"))
+		require.NoError(t, err)
+		tx := waf.NewTransaction()
+		defer tx.Close()
+
+		payload := bytes.Repeat([]byte("a"), 3*bodySegmentSize+10)
+		body := &mockBody{r: bytes.NewReader(payload)}
+		it, err := copyBody(tx.WriteRequestBody, body)
+		require.NoError(t, err)
+		require.Nil(t, it)
+		require.Equal(t, 4, body.reads)
+
+		r, err := tx.RequestBodyReader()
+		require.NoError(t, err)
+		buf := new(bytes.Buffer)
+		_, err = buf.ReadFrom(r)
+		require.NoError(t, err)
+		require.Equal(t, payload, buf.Bytes())
+	})
+
+	t.Run("limit reached with reject", func(t *testing.T) {
+		waf, err := coraza.NewWAF(coraza.NewWAFConfig().WithDirectives(
+			"SecRuleEngine On\nSecRequestBodyAccess On\nSecRequestBodyLimit 100\nSecRequestBodyLimitAction Reject"))
+		require.NoError(t, err)
+		tx := waf.NewTransaction()
+		defer tx.Close()
+
+		body := &mockBody{r: bytes.NewReader(bytes.Repeat([]byte("a"), 10*bodySegmentSize))}
+		it, err := copyBody(tx.WriteRequestBody, body)
+		require.NoError(t, err)
+		require.NotNil(t, it)
+		require.Equal(t, 413, it.Status)
+		require.Equal(t, 1, body.reads)
+	})
+
+	t.Run("limit reached with partial processing", func(t *testing.T) {
+		waf, err := coraza.NewWAF(coraza.NewWAFConfig().WithDirectives(
+			"SecRuleEngine On\nSecRequestBodyAccess On\nSecRequestBodyLimit 100\nSecRequestBodyLimitAction ProcessPartial"))
+		require.NoError(t, err)
+		tx := waf.NewTransaction()
+		defer tx.Close()
+
+		body := &mockBody{r: bytes.NewReader(bytes.Repeat([]byte("a"), 10*bodySegmentSize))}
+		it, err := copyBody(tx.WriteRequestBody, body)
+		require.NoError(t, err)
+		require.Nil(t, it)
+		require.Equal(t, 1, body.reads, "body should not be read past the limit")
+	})
+
+	t.Run("body access disabled", func(t *testing.T) {
+		waf, err := coraza.NewWAF(coraza.NewWAFConfig().WithDirectives("SecRuleEngine On"))
+		require.NoError(t, err)
+		tx := waf.NewTransaction()
+		defer tx.Close()
+
+		body := &mockBody{r: bytes.NewReader(bytes.Repeat([]byte("a"), 10*bodySegmentSize))}
+		it, err := copyBody(tx.WriteResponseBody, body)
+		require.NoError(t, err)
+		require.Nil(t, it)
+		require.Equal(t, 1, body.reads)
+	})
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	// No rule inspects the body, which is still read when it may exceed the
+	// limit so that coraza rejects it.
+	useEngine(t, `{"directives": [
+		"SecRuleEngine On",
+		"SecRequestBodyAccess On",
+		"SecRequestBodyLimit 10",
+		"SecRequestBodyLimitAction Reject",
+		"SecRule REQUEST_HEADERS:X-Attack \"@rx .\" \"id:1,phase:1,deny,status:403\""
+	]}`)
+
+	tests := map[string]struct {
+		body          string
+		contentLength string
+		// expectedStatusCode is the status of the response once handled.
+		expectedStatusCode uint32
+		expectedBodyRead   bool
+	}{
+		"within limit":          {body: "small", contentLength: "5", expectedStatusCode: 200},
+		"over limit":            {body: strings.Repeat("a", 20), contentLength: "20", expectedStatusCode: 413, expectedBodyRead: true},
+		"unknown length":        {body: strings.Repeat("a", 20), expectedStatusCode: 413, expectedBodyRead: true},
+		"unknown length within": {body: "small", expectedStatusCode: 200, expectedBodyRead: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := newMockRequest("POST", "/", test.body)
+			if test.contentLength != "" {
+				req.headers.Set("Content-Length", test.contentLength)
+			}
+			res := newMockResponse(200, "")
+			serve(req, res)
+			require.Equal(t, test.expectedStatusCode, res.statusCode)
+			require.Equal(t, test.expectedBodyRead, req.body.reads > 0)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package guest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// Bot detection actions, taken when the bot score reaches the threshold.
+const (
+	// botActionLog only sets TX:bot_score for the rules to act upon.
+	botActionLog = "log"
+	// botActionBlock answers with a 403.
+	botActionBlock = "block"
+	// botActionChallenge answers with a page solving a proof of work in
+	// JavaScript and setting the solution as a cookie, clients running it
+	// are let through until it expires.
+	botActionChallenge = "challenge"
+)
+
+const (
+	// botScoreVariable is the TX variable holding the bot score.
+	botScoreVariable = "bot_score"
+	// botChallengeCookie holds the proof of a solved challenge.
+	botChallengeCookie = "__waf_challenge"
+	// defaultBotThreshold and defaultBotChallengeTTL apply unless
+	// configured otherwise.
+	defaultBotThreshold    = 5
+	defaultBotChallengeTTL = time.Hour
+	// botChallengeDifficulty is the number of leading zero bits the hash of
+	// a solution needs, about 65k hashes to find, a fraction of a second
+	// in a browser.
+	botChallengeDifficulty = 16
+)
+
+// botDetectionConfig configures the bot detection heuristics, see scoreBot.
+type botDetectionConfig struct {
+	enabled   bool
+	threshold int
+	action    string
+	// challengeSecret signs the challenge cookies, it has to be shared by
+	// the module instances serving the same clients. A random one is used
+	// when not configured, see botChallengeSecret.
+	challengeSecret []byte
+	challengeTTL    time.Duration
+}
+
+// automationAgents are the User-Agent substrings of common automation tools
+// and headless browsers, lowercased.
+var automationAgents = []string{
+	"curl/", "wget/", "python-requests", "python-urllib", "aiohttp", "go-http-client",
+	"okhttp", "java/", "libwww-perl", "scrapy", "httpclient", "headlesschrome", "phantomjs",
+}
+
+// scoreBot scores how likely the request comes from a bot, from header
+// presence anomalies and User-Agent consistency, the scores adding up:
+//   - a missing User-Agent scores 5, reaching the default threshold.
+//   - a User-Agent of an automation tool or headless browser scores 5.
+//   - a User-Agent claiming to be a browser without the headers browsers
+//     always send scores 2 for Accept and Accept-Language each, and 1 for
+//     Accept-Encoding.
+func scoreBot(headers api.Header) int {
+	ua, _ := headers.Get("User-Agent")
+	if ua == "" {
+		return defaultBotThreshold
+	}
+
+	score := 0
+	lowerUA := strings.ToLower(ua)
+	for _, agent := range automationAgents {
+		if strings.Contains(lowerUA, agent) {
+			score += defaultBotThreshold
+			break
+		}
+	}
+
+	if strings.HasPrefix(ua, "Mozilla/") {
+		if _, ok := headers.Get("Accept"); !ok {
+			score += 2
+		}
+		if _, ok := headers.Get("Accept-Language"); !ok {
+			score += 2
+		}
+		if _, ok := headers.Get("Accept-Encoding"); !ok {
+			score++
+		}
+	}
+	return score
+}
+
+// setBotScore exposes the bot score to the rules as TX:bot_score.
+func setBotScore(tx types.Transaction, score int) {
+	if state, ok := tx.(plugintypes.TransactionState); ok {
+		state.Variables().TX().Set(botScoreVariable, []string{strconv.Itoa(score)})
+	}
+}
+
+// serveBotAction takes the configured action for a request scoring above the
+// threshold, returning false when the request goes on.
+func (e *engine) serveBotAction(req api.Request, res api.Response, client string, now time.Time) bool {
+	switch e.cfg.botDetection.action {
+	case botActionBlock:
+		res.SetStatusCode(http.StatusForbidden)
+		return true
+	case botActionChallenge:
+		if e.validBotChallenge(req, client, now) {
+			return false
+		}
+		e.serveBotChallenge(res, client, now)
+		return true
+	default:
+		return false
+	}
+}
+
+// botChallengeToken returns the challenge for the client, "<expiry>.<signature>"
+// where signature is the hex encoded HMAC-SHA256 of "<client>|<expiry>". The
+// cookie holds "<challenge>.<nonce>", where the SHA-256 of the cookie value
+// starts with botChallengeDifficulty zero bits, see solvedBotChallenge.
+func (e *engine) botChallengeToken(client string, expiry int64) string {
+	exp := strconv.FormatInt(expiry, 10)
+	secret := e.cfg.botDetection.challengeSecret
+	if secret == nil {
+		secret = botChallengeSecret()
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(client + "|" + exp))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (e *engine) validBotChallenge(req api.Request, client string, now time.Time) bool {
+	solution := requestCookie(req.Headers(), botChallengeCookie)
+	challenge, nonce, ok := cutLast(solution, '.')
+	if !ok {
+		return false
+	}
+	if _, err := strconv.ParseUint(nonce, 10, 64); err != nil {
+		return false
+	}
+	exp, _, ok := strings.Cut(challenge, ".")
+	if !ok {
+		return false
+	}
+	expiry, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() >= expiry {
+		return false
+	}
+	return hmac.Equal([]byte(challenge), []byte(e.botChallengeToken(client, expiry))) && solvedBotChallenge(solution)
+}
+
+// solvedBotChallenge tells whether the SHA-256 of the solution starts with
+// botChallengeDifficulty zero bits.
+func solvedBotChallenge(solution string) bool {
+	sum := sha256.Sum256([]byte(solution))
+	return bits.LeadingZeros32(binary.BigEndian.Uint32(sum[:4])) >= botChallengeDifficulty
+}
+
+// botChallengeScript solves the challenge c by looking for the nonce n, h
+// being a compact SHA-256 of ASCII strings returning the hash as eight
+// 32-bit words.
+const botChallengeScript = `function h(s){var K=[],H=[],m=[],l=s.length*8,i,j,n=2,a,w,x,y,e,b,t,u;` +
+	`function f(v,p){return Math.pow(v,p)*4294967296|0}` +
+	`for(i=0;i<64;n++){for(j=2;j*j<=n&&n%j;j++);if(j*j>n){if(i<8)H[i]=f(n,1/2);K[i++]=f(n,1/3)}}` +
+	`for(i=0;i<s.length;i++)m[i>>2]|=s.charCodeAt(i)<<24-i%4*8;` +
+	`m[l>>5]|=128<<24-l%32;m[(l+64>>9<<4)+15]=l;` +
+	`for(i=0;i<m.length;i+=16){a=H.slice();w=[];for(j=0;j<64;j++){` +
+	`if(j<16)w[j]=m[i+j]|0;else{x=w[j-15];y=w[j-2];w[j]=((x>>>7|x<<25)^(x>>>18|x<<14)^x>>>3)+((y>>>17|y<<15)^(y>>>19|y<<13)^y>>>10)+w[j-7]+w[j-16]|0}` +
+	`e=a[4];b=a[0];t=a[7]+((e>>>6|e<<26)^(e>>>11|e<<21)^(e>>>25|e<<7))+(e&a[5]^~e&a[6])+K[j]+w[j]|0;` +
+	`u=((b>>>2|b<<30)^(b>>>13|b<<19)^(b>>>22|b<<10))+(b&a[1]^b&a[2]^a[1]&a[2])|0;` +
+	`a=[t+u|0].concat(a);a[4]=a[4]+t|0;a.pop()}` +
+	`for(j=0;j<8;j++)H[j]=H[j]+a[j]|0}return H}` +
+	`var n=0;while(h(c+"."+n)[0]>>>32-d)n++;`
+
+func (e *engine) serveBotChallenge(res api.Response, client string, now time.Time) {
+	ttl := e.cfg.botDetection.challengeTTL
+	challenge := e.botChallengeToken(client, now.Add(ttl).Unix())
+	attributes := "; Max-Age=" + strconv.Itoa(int(ttl.Seconds())) + "; Path=/; SameSite=Lax"
+
+	res.Headers().Set("Content-Type", "text/html; charset=utf-8")
+	res.Headers().Set("Cache-Control", "no-store")
+	res.SetStatusCode(http.StatusOK)
+	res.Body().WriteString(`<!DOCTYPE html><html><head><title>Checking your browser</title></head><body>` +
+		`<noscript>Please enable JavaScript to continue.</noscript>` +
+		`<script>(function(){var c=` + strconv.Quote(challenge) + `,d=` + strconv.Itoa(botChallengeDifficulty) + `;` + botChallengeScript +
+		`document.cookie=` + strconv.Quote(botChallengeCookie+"=") + `+c+"."+n+` + strconv.Quote(attributes) + `;location.reload()})()</script></body></html>`)
+}
+
+// botChallengeSecret returns the secret used when none is configured, random
+// but kept across engine reloads so that solved challenges stay valid.
+var botChallengeSecret = sync.OnceValue(func() []byte {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return secret
+})
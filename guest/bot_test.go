@@ -0,0 +1,144 @@
+package guest
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScoreBot(t *testing.T) {
+	tests := map[string]struct {
+		headers       mockHeader
+		expectedScore int
+	}{
+		"browser": {
+			headers: mockHeader{
+				"User-Agent":      {"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"},
+				"Accept":          {"text/html"},
+				"Accept-Language": {"en-US"},
+				"Accept-Encoding": {"gzip"},
+			},
+		},
+		"no user agent":   {headers: mockHeader{}, expectedScore: 5},
+		"automation tool": {headers: mockHeader{"User-Agent": {"python-requests/2.32"}}, expectedScore: 5},
+		"headless browser": {
+			headers: mockHeader{
+				"User-Agent":      {"Mozilla/5.0 HeadlessChrome/126.0"},
+				"Accept":          {"text/html"},
+				"Accept-Language": {"en-US"},
+				"Accept-Encoding": {"gzip"},
+			},
+			expectedScore: 5,
+		},
+		"headless browser without usual headers": {
+			headers:       mockHeader{"User-Agent": {"Mozilla/5.0 HeadlessChrome/126.0"}},
+			expectedScore: 10,
+		},
+		"browser without usual headers": {
+			headers:       mockHeader{"User-Agent": {"Mozilla/5.0 Chrome/126.0"}},
+			expectedScore: 5,
+		},
+		"other client": {headers: mockHeader{"User-Agent": {"MyApp/1.0"}}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.expectedScore, scoreBot(test.headers))
+		})
+	}
+}
+
+func TestBotDetection(t *testing.T) {
+	request := func(userAgent string) *mockRequest {
+		req := newMockRequest("GET", "/", "")
+		req.headers.Set("User-Agent", userAgent)
+		return req
+	}
+
+	t.Run("score variable", func(t *testing.T) {
+		useEngine(t, `{
+			"botDetection": {},
+			"directives": [
+				"SecRuleEngine On",
+				"SecRule TX:bot_score \"@ge 3\" \"id:1,phase:1,deny,status:403\""
+			]
+		}`)
+
+		bots := metrics.bots.Load()
+		res := newMockResponse(200, "")
+		serve(request("curl/8.0"), res)
+		require.Equal(t, uint32(403), res.statusCode, "the rules see the score")
+		require.Equal(t, uint64(1), metrics.bots.Load()-bots, "automation tools reach the default threshold")
+
+		res = newMockResponse(200, "")
+		serve(request("MyApp/1.0"), res)
+		require.Equal(t, uint32(200), res.statusCode)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		useEngine(t, `{"botDetection": {"threshold": 3, "action": "block"}, "directives": ["SecRuleEngine On"]}`)
+
+		res := newMockResponse(200, "")
+		next, _ := HandleRequest(request("curl/8.0"), res)
+		require.False(t, next)
+		require.Equal(t, uint32(403), res.statusCode)
+	})
+
+	t.Run("challenge", func(t *testing.T) {
+		useEngine(t, `{"botDetection": {"threshold": 3, "action": "challenge"}, "directives": ["SecRuleEngine On"]}`)
+
+		res := newMockResponse(200, "")
+		next, _ := HandleRequest(request("curl/8.0"), res)
+		require.False(t, next)
+		require.Contains(t, string(res.body.written), "document.cookie=")
+
+		challenge := regexp.MustCompile(`var c="([0-9]+\.[0-9a-f]+)"`).FindStringSubmatch(string(res.body.written))
+		require.Len(t, challenge, 2)
+		require.NotContains(t, string(res.body.written), "__waf_challenge="+challenge[1], "the page must not hand out a valid cookie")
+
+		nonce := solveBotChallenge(challenge[1])
+		wrong := 0
+		for solvedBotChallenge(challenge[1] + "." + strconv.Itoa(wrong)) {
+			wrong++
+		}
+		req := request("curl/8.0")
+		req.headers.Set("Cookie", "__waf_challenge="+challenge[1]+"."+strconv.Itoa(wrong))
+		next, _ = HandleRequest(req, newMockResponse(200, ""))
+		require.False(t, next, "unsolved challenge")
+
+		req = request("curl/8.0")
+		req.headers.Set("Cookie", "__waf_challenge="+challenge[1])
+		next, _ = HandleRequest(req, newMockResponse(200, ""))
+		require.False(t, next, "challenge without a nonce")
+
+		cookie := "__waf_challenge=" + challenge[1] + "." + strconv.Itoa(nonce)
+		req = request("curl/8.0")
+		req.headers.Set("Cookie", cookie)
+		next, _ = HandleRequest(req, newMockResponse(200, ""))
+		require.True(t, next, "solved challenge")
+
+		req = request("curl/8.0")
+		req.sourceAddr = "10.0.0.1:1234"
+		req.headers.Set("Cookie", cookie)
+		next, _ = HandleRequest(req, newMockResponse(200, ""))
+		require.False(t, next, "challenges are bound to the client IP")
+	})
+
+	t.Run("detect mode", func(t *testing.T) {
+		useEngine(t, `{"mode": "detect", "botDetection": {"threshold": 3, "action": "block"}, "directives": ["SecRuleEngine On"]}`)
+
+		next, _ := HandleRequest(request("curl/8.0"), newMockResponse(200, ""))
+		require.True(t, next)
+	})
+}
+
+// solveBotChallenge does the work of the challenge page script.
+func solveBotChallenge(challenge string) int {
+	for n := 0; ; n++ {
+		if solvedBotChallenge(challenge + "." + strconv.Itoa(n)) {
+			return n
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package guest
+
+import (
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// Brute force actions, taken on login requests once too many failures were
+// seen for the client IP or the username.
+const (
+	// bruteForceActionBlock answers with a 429.
+	bruteForceActionBlock = "block"
+	// bruteForceActionLog lets the request through, setting TX:brute_force
+	// for the rules of the later phases and the audit logs.
+	bruteForceActionLog = "log"
+)
+
+// bruteForceVariable is the TX variable set when the failure threshold is
+// exceeded.
+const bruteForceVariable = "brute_force"
+
+// maxAuthFailureKeys bounds the memory held by the failure tracker.
+const maxAuthFailureKeys = 1 << 16
+
+// bruteForceConfig configures the brute force and credential stuffing
+// detection: responses to login requests with a failure status code or
+// header count as failures for the client IP and the username, and login
+// requests are acted upon once either has too many failures in the window.
+type bruteForceConfig struct {
+	enabled bool
+	// paths are the path prefixes of the login requests.
+	paths []string
+	// failureStatuses and failureHeader are the failed login indicators, the
+	// latter matching when the upstream sets it to any value.
+	failureStatuses []uint32
+	failureHeader   string
+	// usernameField is the form field holding the username, it requires
+	// SecRequestBodyAccess On. Only client IPs are tracked when empty.
+	usernameField string
+	maxFailures   int
+	window        time.Duration
+	action        string
+}
+
+// authFailures tracks the failures by key across engine reloads, with a
+// sliding window approximated from the counts of the current and previous
+// fixed windows.
+var authFailures = struct {
+	sync.Mutex
+	counters map[string]*failureCounter
+}{counters: map[string]*failureCounter{}}
+
+type failureCounter struct {
+	start          time.Time
+	current, prior int
+}
+
+// count returns the failures in the sliding window ending now, rolling the
+// fixed windows over as needed.
+func (c *failureCounter) count(now time.Time, window time.Duration) float64 {
+	if elapsed := now.Sub(c.start); elapsed >= window {
+		if elapsed < 2*window {
+			c.prior = c.current
+		} else {
+			c.prior = 0
+		}
+		c.current = 0
+		c.start = c.start.Add(elapsed / window * window)
+	}
+
+	weight := 1 - float64(now.Sub(c.start))/float64(window)
+	return float64(c.prior)*weight + float64(c.current)
+}
+
+// authFailureKeys returns the keys the failures of the request are tracked
+// by, the client IP if known and the hash of the username if any.
+func (e *engine) authFailureKeys(tx types.Transaction, client string) []string {
+	var keys []string
+	if client != "" {
+		keys = append(keys, "ip:"+client)
+	}
+	if e.cfg.bruteForce.usernameField == "" {
+		return keys
+	}
+
+	if state, ok := tx.(plugintypes.TransactionState); ok {
+		if values := state.Variables().ArgsPost().Get(e.cfg.bruteForce.usernameField); len(values) > 0 && values[0] != "" {
+			h := fnv.New64a()
+			h.Write([]byte(values[0]))
+			keys = append(keys, "user:"+strconv.FormatUint(h.Sum64(), 16))
+		}
+	}
+	return keys
+}
+
+// checkBruteForce tells whether the login request may go on, taking the
+// configured action otherwise. It is called once the request body is
+// processed, as the username may be sent in a form field.
+func (e *engine) checkBruteForce(tx types.Transaction, req api.Request, res api.Response, client string, now time.Time) bool {
+	cfg := e.cfg.bruteForce
+	if req.GetMethod() != http.MethodPost || !matchPaths(req.GetURI(), cfg.paths) {
+		return true
+	}
+
+	exceeded := false
+	authFailures.Lock()
+	for _, key := range e.authFailureKeys(tx, client) {
+		if c, ok := authFailures.counters[key]; ok && c.count(now, cfg.window) >= float64(cfg.maxFailures) {
+			exceeded = true
+			break
+		}
+	}
+	authFailures.Unlock()
+	if !exceeded {
+		return true
+	}
+
+	metrics.bruteForce.Add(1)
+	if state, ok := tx.(plugintypes.TransactionState); ok {
+		state.Variables().TX().Set(bruteForceVariable, []string{"1"})
+	}
+	if cfg.action != bruteForceActionBlock || e.mode() == modeDetect {
+		tx.DebugLogger().Info().Msg("Too many failed logins for the client or username")
+		return true
+	}
+
+	res.Headers().Set("Retry-After", strconv.Itoa(int(cfg.window.Seconds())))
+	res.SetStatusCode(http.StatusTooManyRequests)
+	return false
+}
+
+// recordAuthFailure counts the response as a failure for the keys of the
+// request when it is a failed login.
+func (e *engine) recordAuthFailure(tx types.Transaction, req api.Request, resp api.Response, now time.Time) {
+	cfg := e.cfg.bruteForce
+	if req.GetMethod() != http.MethodPost || !matchPaths(req.GetURI(), cfg.paths) {
+		return
+	}
+
+	failed := slices.Contains(cfg.failureStatuses, resp.GetStatusCode())
+	if !failed && cfg.failureHeader != "" {
+		_, failed = resp.Headers().Get(cfg.failureHeader)
+	}
+	if !failed {
+		return
+	}
+
+	client, _ := e.clientAddr(req)
+	keys := e.authFailureKeys(tx, client)
+
+	authFailures.Lock()
+	defer authFailures.Unlock()
+	if len(authFailures.counters) >= maxAuthFailureKeys {
+		for key, c := range authFailures.counters {
+			if c.count(now, cfg.window) == 0 {
+				delete(authFailures.counters, key)
+			}
+		}
+		if len(authFailures.counters) >= maxAuthFailureKeys {
+			clear(authFailures.counters)
+		}
+	}
+	for _, key := range keys {
+		c, ok := authFailures.counters[key]
+		if !ok {
+			c = &failureCounter{start: now}
+			authFailures.counters[key] = c
+		}
+		c.count(now, cfg.window)
+		c.current++
+	}
+}
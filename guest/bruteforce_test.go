@@ -0,0 +1,81 @@
+package guest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureCounter(t *testing.T) {
+	start := time.Now()
+	c := &failureCounter{start: start, current: 4}
+
+	require.Equal(t, 4.0, c.count(start.Add(30*time.Second), time.Minute))
+	require.Equal(t, 2.0, c.count(start.Add(90*time.Second), time.Minute), "half of the previous window")
+	require.Equal(t, 0.0, c.count(start.Add(5*time.Minute), time.Minute))
+}
+
+func TestBruteForce(t *testing.T) {
+	login := func(client, username string, upstreamStatus uint32) uint32 {
+		req := newMockRequest("POST", "/login", "username="+username)
+		req.sourceAddr = client + ":1234"
+		req.headers.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := newMockResponse(upstreamStatus, "")
+		serve(req, res)
+		return res.statusCode
+	}
+
+	t.Run("by client IP", func(t *testing.T) {
+		clear(authFailures.counters)
+		useEngine(t, `{"bruteForce": {"paths": ["/login"], "maxFailures": 2}, "directives": ["SecRuleEngine On"]}`)
+
+		require.Equal(t, uint32(401), login("10.0.0.1", "alice", 401))
+		require.Equal(t, uint32(200), login("10.0.0.1", "alice", 200), "successes are not counted")
+		require.Equal(t, uint32(401), login("10.0.0.1", "bob", 401))
+		require.Equal(t, uint32(429), login("10.0.0.1", "carol", 200))
+		require.Equal(t, uint32(200), login("10.0.0.2", "alice", 200), "usernames are not tracked")
+	})
+
+	t.Run("unknown client IP", func(t *testing.T) {
+		clear(authFailures.counters)
+		useEngine(t, `{"bruteForce": {"paths": ["/login"], "maxFailures": 1}, "directives": ["SecRuleEngine On"]}`)
+
+		// Without a usable source address, all the clients would share a
+		// counter.
+		require.Equal(t, uint32(401), login("", "alice", 401))
+		require.Equal(t, uint32(401), login("", "alice", 401))
+		require.Equal(t, uint32(200), login("", "bob", 200))
+		require.Empty(t, authFailures.counters)
+	})
+
+	t.Run("by username", func(t *testing.T) {
+		clear(authFailures.counters)
+		useEngine(t, `{
+			"bruteForce": {"paths": ["/login"], "maxFailures": 2, "usernameField": "username", "failureStatuses": [400]},
+			"directives": ["SecRuleEngine On", "SecRequestBodyAccess On"]
+		}`)
+
+		require.Equal(t, uint32(400), login("10.0.0.1", "alice", 400))
+		require.Equal(t, uint32(400), login("10.0.0.2", "alice", 400))
+		require.Equal(t, uint32(429), login("10.0.0.3", "alice", 200), "credential stuffing over several IPs")
+		require.Equal(t, uint32(200), login("10.0.0.3", "bob", 200))
+	})
+
+	t.Run("failure header and log action", func(t *testing.T) {
+		clear(authFailures.counters)
+		useEngine(t, `{
+			"bruteForce": {"paths": ["/login"], "maxFailures": 1, "failureHeader": "X-Login-Failed", "action": "log"},
+			"directives": ["SecRuleEngine On"]
+		}`)
+
+		req := newMockRequest("POST", "/login", "")
+		res := newMockResponse(200, "")
+		res.headers.Set("X-Login-Failed", "1")
+		serve(req, res)
+
+		bruteForce := metrics.bruteForce.Load()
+		require.Equal(t, uint32(200), login("127.0.0.1", "alice", 200))
+		require.Equal(t, uint64(1), metrics.bruteForce.Load()-bruteForce)
+	})
+}
@@ -0,0 +1,153 @@
+package guest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// defaultBypassHeader is the header carrying the bypass token unless
+// configured otherwise.
+const defaultBypassHeader = "X-Waf-Bypass-Token"
+
+// minBypassSecretLength is the length of the shortest secret accepted, the
+// one of the HMAC-SHA256 output.
+const minBypassSecretLength = 32
+
+// defaultBypassMaxLifetime is how far ahead the expiry of a token can be
+// unless configured otherwise, so that a leaked token can't be used forever.
+const defaultBypassMaxLifetime = 30 * 24 * time.Hour
+
+// bypassConfig configures the bypass tokens, which let known-good clients
+// (e.g. internal scanners, health probes, pentest tooling) skip enforcement
+// without maintaining IP allowlists in SecLang. A token reads
+//
+//	<name>.<expiry>.<signature>
+//
+// where expiry is a Unix time in seconds and signature is the hex encoded
+// HMAC-SHA256 of "<name>.<expiry>" keyed with secret.
+type bypassConfig struct {
+	secret string
+	// header and cookie are where the token is looked for.
+	header string
+	cookie string
+	// maxLifetime is how far ahead the expiry of a token can be.
+	maxLifetime time.Duration
+	// log keeps inspecting the requests carrying a token, in detection only
+	// mode, so that they are logged.
+	log bool
+}
+
+// takeBypassToken returns the bypass token carried by the request, if any,
+// and removes it from the headers and cookies so that it never reaches the
+// upstream.
+func (e *engine) takeBypassToken(req api.Request) string {
+	cfg := e.cfg.bypassTokens
+	var token string
+	if cfg.header != "" {
+		var ok bool
+		if token, ok = req.Headers().Get(cfg.header); ok {
+			req.Headers().Remove(cfg.header)
+		}
+	}
+	if cfg.cookie != "" {
+		if value, ok := removeCookie(req.Headers(), cfg.cookie); ok && token == "" {
+			token = value
+		}
+	}
+	return token
+}
+
+// validBypassToken tells whether token is a valid bypass token, unexpired
+// and expiring within the maximum lifetime.
+func (e *engine) validBypassToken(req api.Request, token string, now time.Time) bool {
+	cfg := e.cfg.bypassTokens
+	payload, signature, ok := cutLast(token, '.')
+	if !ok {
+		return false
+	}
+	name, expiry, ok := cutLast(payload, '.')
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() >= exp || exp > now.Add(cfg.maxLifetime).Unix() {
+		return false
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(cfg.secret))
+	mac.Write([]byte(payload))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return false
+	}
+
+	if e.host.LogEnabled(api.LogLevelDebug) {
+		e.host.Log(api.LogLevelDebug, "Bypass token of "+strconv.Quote(name)+" accepted for "+req.GetURI())
+	}
+	return true
+}
+
+func cutLast(s string, sep byte) (before, after string, found bool) {
+	if i := strings.LastIndexByte(s, sep); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
+// requestCookie returns the value of the named cookie, or an empty string.
+func requestCookie(headers api.Header, name string) string {
+	for _, h := range headers.GetAll("Cookie") {
+		for _, c := range strings.Split(h, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(c), "="); ok && k == name {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// removeCookie removes the named cookie from the request, returning its
+// first value and whether it was found.
+func removeCookie(headers api.Header, name string) (string, bool) {
+	var (
+		value string
+		found bool
+		kept  []string
+	)
+	for _, h := range headers.GetAll("Cookie") {
+		var rest []string
+		for _, c := range strings.Split(h, ";") {
+			c = strings.TrimSpace(c)
+			if k, v, ok := strings.Cut(c, "="); ok && k == name {
+				if !found {
+					value, found = v, true
+				}
+				continue
+			}
+			if c != "" {
+				rest = append(rest, c)
+			}
+		}
+		if len(rest) > 0 {
+			kept = append(kept, strings.Join(rest, "; "))
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	headers.Remove("Cookie")
+	for _, h := range kept {
+		headers.Add("Cookie", h)
+	}
+	return value, true
+}
@@ -0,0 +1,119 @@
+package guest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const bypassSecret = "0123456789abcdef0123456789abcdef"
+
+// bypassToken returns the token of name signed with secret.
+func bypassToken(secret, name string, expiry time.Time) string {
+	payload := name + "." + strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestBypassTokens(t *testing.T) {
+	const directives = `
+		"directives": [
+			"SecRuleEngine On",
+			"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\""
+		]`
+
+	valid := bypassToken(bypassSecret, "scanner", time.Now().Add(time.Hour))
+
+	tests := map[string]struct {
+		header, cookie  string
+		expectedBlocked bool
+	}{
+		"no token":        {expectedBlocked: true},
+		"valid header":    {header: valid},
+		"valid cookie":    {cookie: "a=b; waf_bypass=" + valid},
+		"expired":         {header: bypassToken(bypassSecret, "scanner", time.Now().Add(-time.Second)), expectedBlocked: true},
+		"wrong secret":    {header: bypassToken("wrong", "scanner", time.Now().Add(time.Hour)), expectedBlocked: true},
+		"too far ahead":   {header: bypassToken(bypassSecret, "scanner", time.Now().Add(31*24*time.Hour)), expectedBlocked: true},
+		"malformed":       {header: "scanner", expectedBlocked: true},
+		"invalid expiry":  {header: "scanner.x." + hex.EncodeToString([]byte("x")), expectedBlocked: true},
+		"invalid hex":     {header: "scanner.1.zz", expectedBlocked: true},
+		"other cookie":    {cookie: "bypass=" + valid, expectedBlocked: true},
+		"tampered expiry": {header: "scanner.9999999999." + valid[len(valid)-64:], expectedBlocked: true},
+	}
+
+	for _, log := range []bool{false, true} {
+		t.Run("log "+strconv.FormatBool(log), func(t *testing.T) {
+			useEngine(t, `{"bypassTokens": {"secret": "`+bypassSecret+`", "header": "X-Bypass", "cookie": "waf_bypass", "log": `+strconv.FormatBool(log)+`}, `+directives+`}`)
+
+			for name, test := range tests {
+				t.Run(name, func(t *testing.T) {
+					bypassed := metrics.bypassedRequests.Load()
+
+					req := newMockRequest("GET", "/?id=0", "")
+					if test.header != "" {
+						req.headers.Set("X-Bypass", test.header)
+					}
+					if test.cookie != "" {
+						req.headers.Set("Cookie", test.cookie)
+					}
+					res := newMockResponse(200, "")
+					serve(req, res)
+
+					require.Equal(t, test.expectedBlocked, res.statusCode == 403)
+					require.Empty(t, req.headers.GetAll("X-Bypass"), "the token doesn't reach the upstream")
+					require.NotContains(t, strings.Join(req.headers.GetAll("Cookie"), ";"), "waf_bypass=")
+					expectedBypassed := uint64(1)
+					if test.expectedBlocked {
+						expectedBypassed = 0
+					}
+					require.Equal(t, expectedBypassed, metrics.bypassedRequests.Load()-bypassed)
+				})
+			}
+		})
+	}
+
+	t.Run("default header", func(t *testing.T) {
+		useEngine(t, `{"bypassTokens": {"secret": "`+bypassSecret+`"}, `+directives+`}`)
+
+		req := newMockRequest("GET", "/?id=0", "")
+		req.headers.Set(defaultBypassHeader, valid)
+		res := newMockResponse(200, "")
+		serve(req, res)
+		require.Equal(t, uint32(200), res.statusCode)
+	})
+	t.Run("max lifetime", func(t *testing.T) {
+		useEngine(t, `{"bypassTokens": {"secret": "`+bypassSecret+`", "maxLifetime": 60}, `+directives+`}`)
+
+		for ttl, expectedStatus := range map[time.Duration]uint32{time.Minute / 2: 200, time.Hour: 403} {
+			req := newMockRequest("GET", "/?id=0", "")
+			req.headers.Set(defaultBypassHeader, bypassToken(bypassSecret, "scanner", time.Now().Add(ttl)))
+			res := newMockResponse(200, "")
+			serve(req, res)
+			require.Equal(t, expectedStatus, res.statusCode, ttl)
+		}
+	})
+
+	t.Run("stripped", func(t *testing.T) {
+		for name, mode := range map[string]string{"enforce": modeEnforce, "bypass mode": modeBypass} {
+			t.Run(name, func(t *testing.T) {
+				useEngine(t, `{"mode": "`+mode+`", "bypassTokens": {"secret": "`+bypassSecret+`", "header": "X-Bypass", "cookie": "waf_bypass"}, `+directives+`}`)
+
+				req := newMockRequest("GET", "/", "")
+				req.headers.Set("X-Bypass", "invalid")
+				req.headers.Add("Cookie", "a=b; waf_bypass="+valid)
+				req.headers.Add("Cookie", "waf_bypass="+valid)
+				serve(req, newMockResponse(200, ""))
+
+				require.Empty(t, req.headers.GetAll("X-Bypass"))
+				require.Equal(t, []string{"a=b"}, req.headers.GetAll("Cookie"))
+			})
+		}
+	})
+}
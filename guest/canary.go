@@ -0,0 +1,25 @@
+package guest
+
+import "hash/fnv"
+
+// canaryConfig configures the canary, which enforces a candidate ruleset on
+// a slice of the traffic before it gets rolled out to all of it.
+type canaryConfig struct {
+	directives string
+	// percent is the share of the clients going through the canary.
+	percent float64
+}
+
+// inCanary tells whether the client belongs to the canary cohort. Clients are
+// assigned by a hash of their IP so that a given client consistently sees the
+// same ruleset.
+func (e *engine) inCanary(client string) bool {
+	if e.canary == nil {
+		return false
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(client))
+	// Buckets of a hundredth of a percent allow fractional percentages.
+	return float64(h.Sum32()%10000) < e.cfg.canary.percent*100
+}
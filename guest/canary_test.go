@@ -0,0 +1,74 @@
+package guest
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanary(t *testing.T) {
+	useCanary := func(t *testing.T, percent string) {
+		useEngine(t, `
+		{
+			"directives": [
+				"SecRuleEngine On",
+				"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\""
+			],
+			"canary": {
+				"directives": [
+					"SecRuleEngine On",
+					"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\"",
+					"SecRule ARGS:id \"@eq 1\" \"id:2,phase:1,deny,status:403\""
+				],
+				"percent": `+percent+`
+			}
+		}
+		`)
+	}
+
+	blocked := func(client string) bool {
+		req := newMockRequest("GET", "/?id=1", "")
+		req.sourceAddr = client + ":12345"
+		res := newMockResponse(200, "")
+		serve(req, res)
+		return res.statusCode == 403
+	}
+
+	t.Run("none", func(t *testing.T) {
+		useCanary(t, "0")
+		require.False(t, blocked("10.0.0.1"))
+	})
+
+	t.Run("all", func(t *testing.T) {
+		useCanary(t, "100")
+
+		requests, interruptions := metrics.canaryRequests.Load(), metrics.canaryRequestInterruptions.Load()
+		require.True(t, blocked("10.0.0.1"))
+		require.Equal(t, uint64(1), metrics.canaryRequests.Load()-requests)
+		require.Equal(t, uint64(1), metrics.canaryRequestInterruptions.Load()-interruptions)
+	})
+
+	t.Run("slice", func(t *testing.T) {
+		useCanary(t, "50")
+
+		inCanary := 0
+		for i := 0; i < 200; i++ {
+			client := "10.0.0." + strconv.Itoa(i)
+			b := blocked(client)
+			require.Equal(t, b, blocked(client), "a client should stay in its cohort")
+			if b {
+				inCanary++
+			}
+		}
+		require.InDelta(t, 100, inCanary, 30)
+	})
+
+	t.Run("invalid canary directives", func(t *testing.T) {
+		host := mockAPIHost{t: t, features: allFeatures, getConfig: func() []byte {
+			return []byte(`{"directives": ["SecRuleEngine On"], "canary": {"directives": ["SecRule"], "percent": 5}}`)
+		}}
+		_, err := initializeWAF(host, overrides{})
+		require.ErrorContains(t, err, "invalid canary directives")
+	})
+}
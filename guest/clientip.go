@@ -0,0 +1,69 @@
+package guest
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// sourceAddrWarned makes sure an unusable source address is only reported
+// once rather than on every request.
+var sourceAddrWarned atomic.Bool
+
+// clientAddr returns the client IP and port of the request. Some hosts give
+// an empty or non-IP source address, which would leave IP based rules
+// silently unmatched, in which case the client IP is taken from the
+// clientIPHeader header or else defaultClientIP, with no port.
+func (e *engine) clientAddr(req api.Request) (string, int) {
+	srcAddr := req.GetSourceAddr()
+	if ip, port, ok := parseAddr(srcAddr); ok {
+		return ip, port
+	}
+
+	var ip string
+	if e.cfg.clientIPHeader != "" {
+		if v, ok := req.Headers().Get(e.cfg.clientIPHeader); ok {
+			// Proxies append to the header, the first entry is the client.
+			v, _, _ = strings.Cut(v, ",")
+			ip, _, _ = parseAddr(strings.TrimSpace(v))
+		}
+	}
+	if ip == "" {
+		ip = e.cfg.defaultClientIP
+	}
+
+	if sourceAddrWarned.CompareAndSwap(false, true) {
+		msg := "Host gives no usable source address (" + strconv.Quote(srcAddr) + "), "
+		switch {
+		case e.cfg.clientIPHeader != "":
+			msg += "the client IP is taken from the " + e.cfg.clientIPHeader + " header"
+		case ip != "":
+			msg += "the client IP is set to " + ip
+		default:
+			msg += "IP based rules won't match and the honeypot bans, IP rate limits and brute force IP counters are skipped, consider setting clientIPHeader or defaultClientIP"
+		}
+		e.host.Log(api.LogLevelWarn, msg)
+	}
+
+	return ip, 0
+}
+
+// parseAddr splits an address into IP and port, the port being optional as
+// some hosts don't include it, e.g. "[2001:db8::1]:8080" or "10.0.0.1".
+func parseAddr(addr string) (ip string, port int, ok bool) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	} else if n, err := strconv.ParseUint(p, 10, 16); err == nil {
+		// Out of range ports are dropped like missing ones.
+		port = int(n)
+	}
+
+	if net.ParseIP(host) == nil {
+		return "", 0, false
+	}
+	return host, port, true
+}
@@ -0,0 +1,123 @@
+package guest
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddr(t *testing.T) {
+	tests := map[string]struct {
+		addr         string
+		expectedIP   string
+		expectedPort int
+		expectedOK   bool
+	}{
+		"IPv4 with port":    {addr: "10.0.0.1:8080", expectedIP: "10.0.0.1", expectedPort: 8080, expectedOK: true},
+		"IPv4 without port": {addr: "10.0.0.1", expectedIP: "10.0.0.1", expectedOK: true},
+		"IPv6 with port":    {addr: "[2001:db8::1]:8080", expectedIP: "2001:db8::1", expectedPort: 8080, expectedOK: true},
+		"IPv6 without port": {addr: "2001:db8::1", expectedIP: "2001:db8::1", expectedOK: true},
+		"bracketed IPv6":    {addr: "[2001:db8::1]", expectedIP: "2001:db8::1", expectedOK: true},
+		"empty":             {addr: ""},
+		"hostname":          {addr: "localhost:8080"},
+		"unix socket":       {addr: "@"},
+		"negative port":     {addr: "10.0.0.1:-1", expectedIP: "10.0.0.1", expectedOK: true},
+		"port out of range": {addr: "10.0.0.1:65536", expectedIP: "10.0.0.1", expectedOK: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ip, port, ok := parseAddr(test.addr)
+			require.Equal(t, test.expectedOK, ok)
+			require.Equal(t, test.expectedIP, ip)
+			require.Equal(t, test.expectedPort, port)
+		})
+	}
+}
+
+func FuzzParseAddr(f *testing.F) {
+	for _, addr := range []string{"10.0.0.1:8080", "10.0.0.1", "[2001:db8::1]:8080", "[2001:db8::1]", "2001:db8::1", "localhost:8080", "@", "[::ffff:10.0.0.1]:0", "10.0.0.1:-1"} {
+		f.Add(addr)
+	}
+	f.Fuzz(func(t *testing.T, addr string) {
+		ip, port, ok := parseAddr(addr)
+		if !ok {
+			require.Empty(t, ip)
+			require.Zero(t, port)
+			return
+		}
+		require.NotNil(t, net.ParseIP(ip), "the IP should be valid")
+		require.True(t, port >= 0 && port <= 65535, "the port should be in range")
+	})
+}
+
+func TestClientAddr(t *testing.T) {
+	tests := map[string]struct {
+		cfg            config
+		sourceAddr     string
+		header         string
+		expectedIP     string
+		expectedPort   int
+		expectedLogged string
+	}{
+		"usable source address": {
+			cfg:          config{clientIPHeader: "X-Forwarded-For"},
+			sourceAddr:   "10.0.0.1:8080",
+			header:       "192.0.2.1",
+			expectedIP:   "10.0.0.1",
+			expectedPort: 8080,
+		},
+		"header fallback": {
+			cfg:            config{clientIPHeader: "X-Forwarded-For", defaultClientIP: "0.0.0.0"},
+			header:         "192.0.2.1, 10.0.0.1",
+			expectedIP:     "192.0.2.1",
+			expectedLogged: "taken from the X-Forwarded-For header",
+		},
+		"invalid header": {
+			cfg:            config{clientIPHeader: "X-Forwarded-For", defaultClientIP: "0.0.0.0"},
+			header:         "unknown",
+			expectedIP:     "0.0.0.0",
+			expectedLogged: "taken from the X-Forwarded-For header",
+		},
+		"static fallback": {
+			cfg:            config{defaultClientIP: "0.0.0.0"},
+			sourceAddr:     "pipe",
+			expectedIP:     "0.0.0.0",
+			expectedLogged: "client IP is set to 0.0.0.0",
+		},
+		"no fallback": {
+			expectedLogged: "IP based rules won't match",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sourceAddrWarned.Store(false)
+			t.Cleanup(func() { sourceAddrWarned.Store(false) })
+
+			var logs bytes.Buffer
+			e := &engine{host: recordingHost{logs: &logs}, cfg: test.cfg}
+			req := newMockRequest("GET", "/", "")
+			req.sourceAddr = test.sourceAddr
+			if test.header != "" {
+				req.headers.Set("X-Forwarded-For", test.header)
+			}
+
+			ip, port := e.clientAddr(req)
+			require.Equal(t, test.expectedIP, ip)
+			require.Equal(t, test.expectedPort, port)
+			if test.expectedLogged == "" {
+				require.Empty(t, logs.String())
+			} else {
+				require.Contains(t, logs.String(), test.expectedLogged)
+			}
+
+			// The degradation is only reported once.
+			logs.Reset()
+			e.clientAddr(req)
+			require.Empty(t, logs.String())
+		})
+	}
+}
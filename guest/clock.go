@@ -0,0 +1,42 @@
+package guest
+
+import (
+	"time"
+
+	"github.com/corazawaf/coraza/v3"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+// clock is the time source of the handlers: time limits, bans, rate limits
+// and timings all read the time from it.
+type clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// txIDGenerator generates the IDs of the transactions.
+type txIDGenerator interface {
+	NextID() string
+}
+
+// Like txs, the time source and the transaction IDs outlive the engines so
+// that they are kept when the admin API rebuilds one. Tests replace them with
+// deterministic ones.
+var (
+	timeSource clock = systemClock{}
+	// txIDs is nil to let Coraza generate random IDs.
+	txIDs txIDGenerator
+)
+
+// newTransaction starts a transaction of waf, with an ID from txIDs if set.
+func newTransaction(waf coraza.WAF) types.Transaction {
+	if txIDs == nil {
+		return waf.NewTransaction()
+	}
+	return waf.NewTransactionWithID(txIDs.NextID())
+}
@@ -0,0 +1,92 @@
+package guest
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock only moving forward when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// sequentialIDs generates the transaction IDs tx-1, tx-2 and so on.
+type sequentialIDs struct {
+	n atomic.Uint64
+}
+
+func (s *sequentialIDs) NextID() string {
+	return "tx-" + strconv.FormatUint(s.n.Add(1), 10)
+}
+
+// useDeterministicHandlers makes the handlers reproducible for the duration of
+// the test: the time only moves with the returned clock, transaction IDs are
+// sequential and request contexts are handed out from an empty store.
+func useDeterministicHandlers(t *testing.T) *fakeClock {
+	t.Helper()
+
+	clk := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	previousClock, previousIDs, previousTxs := timeSource, txIDs, txs
+	timeSource, txIDs, txs = clk, &sequentialIDs{}, newTxStore()
+	t.Cleanup(func() { timeSource, txIDs, txs = previousClock, previousIDs, previousTxs })
+	return clk
+}
+
+func TestDeterministicHandlers(t *testing.T) {
+	t.Run("transaction ids and request contexts", func(t *testing.T) {
+		for run := 0; run < 2; run++ {
+			useDeterministicHandlers(t)
+			useEngine(t, `{"directives": ["SecRuleEngine On", "SecRule RESPONSE_STATUS \"@streq 500\" \"id:1,phase:3,deny\""]}`)
+
+			var ids []string
+			var reqCtxs []uint32
+			for i := 0; i < 3; i++ {
+				req, res := newMockRequest("GET", "/", ""), newMockResponse(200, "")
+				next, reqCtx := HandleRequest(req, res)
+				require.True(t, next)
+				in, ok := txs.take(reqCtx)
+				require.True(t, ok)
+				ids, reqCtxs = append(ids, in.tx.ID()), append(reqCtxs, reqCtx)
+				in.tx.Close()
+			}
+			require.Equal(t, []string{"tx-1", "tx-2", "tx-3"}, ids)
+			// The slot is reused, with the next generation.
+			require.Equal(t, []uint32{1 << txSlotBits, 2 << txSlotBits, 3 << txSlotBits}, reqCtxs)
+		}
+	})
+
+	t.Run("ban expiry", func(t *testing.T) {
+		t.Cleanup(func() { clear(bans.until) })
+		clk := useDeterministicHandlers(t)
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "honeypot": {"paths": ["/.env"], "banDuration": 60}}`)
+
+		get := func(uri string) uint32 {
+			req, res := newMockRequest("GET", uri, ""), newMockResponse(200, "")
+			serve(req, res)
+			return res.statusCode
+		}
+		require.Equal(t, uint32(403), get("/.env"))
+		clk.Advance(59 * time.Second)
+		require.Equal(t, uint32(403), get("/"), "the client should still be banned")
+		clk.Advance(time.Second)
+		require.Equal(t, uint32(200), get("/"), "the ban should have expired")
+	})
+}
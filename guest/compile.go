@@ -0,0 +1,90 @@
+package guest
+
+import (
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3"
+)
+
+// DirectiveError reports the directive of the host config that failed to
+// compile.
+type DirectiveError struct {
+	// Index is the index of the entry of the directives array holding the
+	// directive, -1 when the directives are given as a string.
+	Index int
+	// Line is the line the directive ends at, within the entry or the
+	// string, starting at 1.
+	Line int
+	// Directive is the directive, continuation lines included.
+	Directive string
+	// Err is the seclang error.
+	Err error
+}
+
+func (e *DirectiveError) Error() string {
+	return "invalid directive at " + e.Location() + ", " + strconv.Quote(e.Directive) + ": " + e.Err.Error()
+}
+
+// Location locates the directive in the host config, e.g. "directives[2],
+// line 1".
+func (e *DirectiveError) Location() string {
+	at := "line " + strconv.Itoa(e.Line)
+	if e.Index >= 0 {
+		at = "directives[" + strconv.Itoa(e.Index) + "], " + at
+	}
+	return at
+}
+
+func (e *DirectiveError) Unwrap() error {
+	return e.Err
+}
+
+// locateDirectiveError looks the directive of the config that fails to
+// compile up, returning err unchanged when the config directives compile on
+// their own, i.e. when the failure comes from what is added to them.
+//
+// Coraza stops at the first directive failing to compile, hence it is the
+// last line of the shortest failing prefix of the directives, which is
+// searched by compiling about log2(lines) prefixes. It only runs once the
+// WAF failed to build.
+func locateDirectiveError(root fs.FS, cfg config, err error) error {
+	lines := strings.Split(cfg.directives, "\n")
+	errs := map[int]error{}
+	i := sort.Search(len(lines), func(i int) bool {
+		_, err := coraza.NewWAF(coraza.NewWAFConfig().WithRootFS(root).WithDirectives(strings.Join(lines[:i+1], "\n")))
+		if err != nil && i < len(lines)-1 && strings.HasSuffix(err.Error(), "backticks left open") {
+			// The prefix ends in the middle of a multi-line directive.
+			return false
+		}
+		errs[i] = err
+		return err != nil
+	})
+	if i == len(lines) {
+		return err
+	}
+
+	start := i
+	for start > 0 && strings.HasSuffix(strings.TrimSpace(lines[start-1]), "\\") {
+		start--
+	}
+	dirErr := &DirectiveError{
+		Index:     -1,
+		Line:      i + 1,
+		Directive: strings.Join(lines[start:i+1], "\n"),
+		Err:       errs[i],
+	}
+
+	// Maps the line of the merged directives to the entry holding it.
+	for index, entry := range cfg.directiveEntries {
+		n := strings.Count(entry, "\n") + 1
+		if i < n {
+			dirErr.Index, dirErr.Line = index, i+1
+			break
+		}
+		i -= n
+	}
+	return dirErr
+}
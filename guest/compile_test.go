@@ -0,0 +1,59 @@
+package guest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectiveError(t *testing.T) {
+	initialize := func(config string) error {
+		_, err := initializeWAF(mockAPIHost{t: t, getConfig: func() []byte {
+			return []byte(config)
+		}}, overrides{})
+		return err
+	}
+
+	t.Run("array", func(t *testing.T) {
+		err := initialize(`{"includeCRS": false, "directives": [
+			"SecRuleEngine On",
+			"SecRule ARGS \"@rx foo\" \"id:1,deny\"",
+			"SecRule ARGS \"@rx (\" \"id:2,deny\""
+		]}`)
+		var dirErr *DirectiveError
+		require.True(t, errors.As(err, &dirErr), err)
+		require.Equal(t, 2, dirErr.Index)
+		require.Equal(t, 1, dirErr.Line)
+		require.Equal(t, `SecRule ARGS "@rx (" "id:2,deny"`, dirErr.Directive)
+		require.ErrorContains(t, dirErr.Err, "failed to compile the directive")
+		require.ErrorContains(t, err, `invalid directive at directives[2], line 1, "SecRule ARGS \"@rx (\" \"id:2,deny\""`)
+	})
+
+	t.Run("multi-line entry", func(t *testing.T) {
+		err := initialize(`{"includeCRS": false, "directives": [
+			"SecRuleEngine On",
+			"SecAction \"id:1,pass,nolog\"\nSecRule ARGS \"@rx foo\" \\\n  \"id:2,unknownaction\""
+		]}`)
+		var dirErr *DirectiveError
+		require.True(t, errors.As(err, &dirErr), err)
+		require.Equal(t, 1, dirErr.Index)
+		require.Equal(t, 3, dirErr.Line)
+		require.Equal(t, "SecRule ARGS \"@rx foo\" \\\n  \"id:2,unknownaction\"", dirErr.Directive)
+	})
+
+	t.Run("string", func(t *testing.T) {
+		err := initialize(`{"includeCRS": false, "directives": "SecRuleEngine On\n\nSecUnknownDirective On"}`)
+		var dirErr *DirectiveError
+		require.True(t, errors.As(err, &dirErr), err)
+		require.Equal(t, -1, dirErr.Index)
+		require.Equal(t, 3, dirErr.Line)
+		require.ErrorContains(t, err, `invalid directive at line 3, "SecUnknownDirective On": `)
+	})
+
+	t.Run("outside the config directives", func(t *testing.T) {
+		err := errors.New("invalid virtual patch")
+		cfg := config{directives: "SecRuleEngine On", directiveEntries: []string{"SecRuleEngine On"}}
+		require.Equal(t, err, locateDirectiveError(rootFS(cfg), cfg, err))
+	})
+}
@@ -0,0 +1,961 @@
+package guest
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+	"github.com/tidwall/gjson"
+)
+
+type config struct {
+	includeCRS bool
+	// directives holds the directives from the host config merged into a
+	// single string, ready to be passed to the WAF.
+	directives string
+	// directiveEntries are the entries of the directives array, nil when the
+	// directives are given as a string, see DirectiveError.
+	directiveEntries []string
+	// versionCheck is the constraint the running version has to satisfy,
+	// see Version.
+	versionCheck versionConstraint
+	// protocolChecks enables the request smuggling checks, see
+	// requestSmugglingReason.
+	protocolChecks bool
+	// verdictHeaders enables the headers carrying the WAF verdict to the
+	// upstream and to the host, see setVerdictHeaders.
+	verdictHeaders bool
+	// inspectHostErrors runs the response headers rules on the error
+	// responses of the host, see inspectHostError.
+	inspectHostErrors bool
+	// clientIPHeader and defaultClientIP are the fallbacks for the client
+	// IP when the host gives no usable source address, see clientAddr.
+	clientIPHeader  string
+	defaultClientIP string
+	// debugDump enables dumping the transactions of the requests carrying
+	// a secret, see debugDump.
+	debugDump debugDumpConfig
+	// admin enables the admin API when its path is set, see serveAdmin.
+	admin adminConfig
+	// mode switches the whole engine, see modeEnforce.
+	mode string
+	// killSwitch enables polling the kill switch, see pollKillSwitch.
+	killSwitch killSwitchConfig
+	// inflightTimeout is how long a transaction waits for its response,
+	// zero disabling the timeout, see reapInflight.
+	inflightTimeout time.Duration
+	// botDetection scores the requests, see scoreBot.
+	botDetection botDetectionConfig
+	// signedRequests configures the verification of signed requests, see
+	// verifySignedRequest.
+	signedRequests signedRequestsConfig
+	// csrf configures the CSRF protection, see checkCSRF.
+	csrf csrfConfig
+	// bruteForce configures the brute force detection, see checkBruteForce.
+	bruteForce bruteForceConfig
+	// geoPolicy allows or denies countries, see deniedCountry.
+	geoPolicy geoPolicyConfig
+	// honeypot bans the clients requesting decoys, see checkHoneypot.
+	honeypot honeypotConfig
+	// tlsPolicy enforces TLS requirements, see tlsViolation.
+	tlsPolicy tlsPolicyConfig
+	// allowedMethods restricts the request methods when set, other methods
+	// are answered with a 405.
+	allowedMethods []string
+	// requestLimits caps the request line and headers, see
+	// exceededRequestLimit.
+	requestLimits requestLimitsConfig
+	// slowRequests configures the detection of slow request bodies, see
+	// slowBody.
+	slowRequests slowRequestConfig
+	// scrub configures the response scrubbing, see scrubResponse.
+	scrub scrubConfig
+	// dataLeak configures the detection of data leaks in the response
+	// bodies, see detectDataLeaks.
+	dataLeak dataLeakConfig
+	// virtualPatches are compiled into rules, see virtualPatchDirectives.
+	virtualPatches []virtualPatch
+	// rateLimits are enforced in order, see rateLimit.
+	rateLimits []rateLimitConfig
+	// maintenance configures the maintenance response, see serveMaintenance.
+	maintenance maintenanceConfig
+	// bypassTokens lets trusted clients skip enforcement, see
+	// validBypassToken.
+	bypassTokens bypassConfig
+	// canary enforces another ruleset on a slice of the traffic, see
+	// inCanary.
+	canary canaryConfig
+	// shadowDirectives holds the directives of the shadow WAF, see
+	// shadowRequest.
+	shadowDirectives string
+}
+
+type adminConfig struct {
+	// path is the path prefix the admin API is served on.
+	path string
+	// token is the bearer token the admin requests have to carry.
+	token string
+}
+
+func getConfigFromHost(host api.Host) (config, error) {
+	return parseConfig(host.GetConfig())
+}
+
+// parseConfig unmarshals the host config with a single pass over its fields.
+// The config package describes the same fields with Go types, both have to
+// be kept in sync.
+func parseConfig(data []byte) (config, error) {
+	cfg := config{includeCRS: true, protocolChecks: true, maintenance: defaultMaintenanceConfig(), inflightTimeout: defaultInflightTimeout}
+
+	if len(data) == 0 {
+		return cfg, nil
+	}
+
+	cfgAsJSON := gjson.ParseBytes(data)
+	if !gjson.ValidBytes(data) || !cfgAsJSON.IsObject() {
+		return config{}, errors.New("invalid host config")
+	}
+
+	var (
+		err           error
+		hasDirectives bool
+	)
+	cfgAsJSON.ForEach(func(key, value gjson.Result) bool {
+		switch key.Str {
+		case "includeCRS":
+			cfg.includeCRS = value.Bool()
+		case "versionCheck":
+			cfg.versionCheck, err = parseVersionCheck(value)
+		case "protocolChecks":
+			cfg.protocolChecks = value.Bool()
+		case "verdictHeaders":
+			cfg.verdictHeaders = value.Bool()
+		case "inspectHostErrors":
+			cfg.inspectHostErrors = value.Bool()
+		case "clientIPHeader":
+			cfg.clientIPHeader = value.String()
+		case "defaultClientIP":
+			cfg.defaultClientIP = value.String()
+			if net.ParseIP(cfg.defaultClientIP) == nil {
+				err = errors.New("invalid host config, IP expected for field defaultClientIP")
+			}
+		case "mode":
+			cfg.mode = value.String()
+			if !validMode(cfg.mode) {
+				err = errors.New("invalid host config, enforce, detect or bypass expected for field mode")
+			}
+		case "killSwitch":
+			cfg.killSwitch, err = parseKillSwitchConfig(value)
+		case "inflightTimeout":
+			value = numeric(value)
+			if value.Type != gjson.Number || value.Num < 0 {
+				err = errors.New("invalid host config, seconds expected for field inflightTimeout")
+			}
+			cfg.inflightTimeout = time.Duration(value.Num * float64(time.Second))
+		case "debugDump":
+			cfg.debugDump, err = parseDebugDumpConfig(value)
+		case "admin":
+			cfg.admin, err = parseAdminConfig(value)
+		case "botDetection":
+			cfg.botDetection, err = parseBotDetectionConfig(value)
+		case "signedRequests":
+			cfg.signedRequests, err = parseSignedRequestsConfig(value)
+		case "csrf":
+			cfg.csrf, err = parseCSRFConfig(value)
+		case "bruteForce":
+			cfg.bruteForce, err = parseBruteForceConfig(value)
+		case "geoPolicy":
+			cfg.geoPolicy, err = parseGeoPolicyConfig(value)
+		case "honeypot":
+			cfg.honeypot, err = parseHoneypotConfig(value)
+		case "tls":
+			cfg.tlsPolicy, err = parseTLSPolicyConfig(value)
+		case "allowedMethods":
+			cfg.allowedMethods, err = parseAllowedMethods(value)
+		case "requestLimits":
+			cfg.requestLimits, err = parseRequestLimitsConfig(value)
+		case "slowRequests":
+			cfg.slowRequests, err = parseSlowRequestConfig(value)
+		case "scrubResponse":
+			cfg.scrub, err = parseScrubConfig(value)
+		case "dataLeak":
+			cfg.dataLeak, err = parseDataLeakConfig(value)
+		case "virtualPatches":
+			cfg.virtualPatches, err = parseVirtualPatches(value)
+		case "rateLimits":
+			cfg.rateLimits, err = parseRateLimits(value)
+		case "maintenance":
+			cfg.maintenance, err = parseMaintenanceConfig(value)
+		case "bypassTokens":
+			cfg.bypassTokens, err = parseBypassConfig(value)
+		case "canary":
+			cfg.canary, err = parseCanaryConfig(value)
+		case "shadow":
+			cfg.shadowDirectives, err = parseShadowConfig(value)
+		case "directives":
+			hasDirectives = true
+			cfg.directives, cfg.directiveEntries, err = parseDirectives(value)
+		}
+
+		return err == nil
+	})
+
+	if err != nil {
+		return config{}, err
+	}
+
+	if !hasDirectives {
+		return config{}, errors.New("invalid host config, array expected for field directives")
+	}
+
+	return cfg, nil
+}
+
+// numeric returns value as a number when it is a numeric string, as the
+// options set through Traefik labels always are, and value as is otherwise.
+func numeric(value gjson.Result) gjson.Result {
+	if value.Type != gjson.String {
+		return value
+	}
+	raw := strings.TrimSpace(value.Str)
+	n, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return value
+	}
+	return gjson.Result{Type: gjson.Number, Raw: raw, Num: n}
+}
+
+// parseDirectives merges the directives into a single string, also returning
+// the entries when given as an array. Besides an array, a string with one
+// directive per line is accepted, as some hosts (e.g. Traefik) make it easier
+// to pass multi-line strings than arrays.
+func parseDirectives(value gjson.Result) (string, []string, error) {
+	if value.Type == gjson.String {
+		if strings.TrimSpace(value.Str) == "" {
+			return "", nil, errors.New("empty directives")
+		}
+		return value.Str, nil, nil
+	}
+
+	if !value.IsArray() {
+		return "", nil, errors.New("invalid host config, array expected for field directives")
+	}
+
+	var entries []string
+	value.ForEach(func(_, directive gjson.Result) bool {
+		entries = append(entries, directive.Str)
+		return true
+	})
+
+	directives := strings.Join(entries, "\n")
+	if len(directives) == 0 {
+		return "", nil, errors.New("empty directives")
+	}
+
+	return directives, entries, nil
+}
+
+// parseAdminConfig validates the admin API config, a token being required as
+// the admin API can change the WAF behavior.
+func parseAdminConfig(value gjson.Result) (adminConfig, error) {
+	if !value.IsObject() {
+		return adminConfig{}, errors.New("invalid host config, object expected for field admin")
+	}
+
+	cfg := adminConfig{
+		path:  strings.TrimSuffix(value.Get("path").String(), "/"),
+		token: value.Get("token").String(),
+	}
+	if !strings.HasPrefix(cfg.path, "/") {
+		return adminConfig{}, errors.New("invalid host config, absolute path expected for field admin.path")
+	}
+	if cfg.token == "" {
+		return adminConfig{}, errors.New("invalid host config, token expected for field admin.token")
+	}
+
+	return cfg, nil
+}
+
+func parseKillSwitchConfig(value gjson.Result) (killSwitchConfig, error) {
+	if !value.IsObject() {
+		return killSwitchConfig{}, errors.New("invalid host config, object expected for field killSwitch")
+	}
+
+	cfg := killSwitchConfig{
+		file:     value.Get("file").String(),
+		interval: defaultKillSwitchInterval,
+		enabled:  true,
+	}
+	if interval := numeric(value.Get("interval")); interval.Exists() {
+		if interval.Type != gjson.Number || interval.Num < 0 {
+			return killSwitchConfig{}, errors.New("invalid host config, seconds expected for field killSwitch.interval")
+		}
+		cfg.interval = time.Duration(interval.Num * float64(time.Second))
+	}
+
+	return cfg, nil
+}
+
+// parseShadowConfig returns the directives of the shadow WAF, in the same
+// formats as the directives field.
+func parseShadowConfig(value gjson.Result) (string, error) {
+	if !value.IsObject() {
+		return "", errors.New("invalid host config, object expected for field shadow")
+	}
+
+	directives, _, err := parseDirectives(value.Get("directives"))
+	if err != nil {
+		return "", errors.New("invalid host config, directives expected for field shadow.directives")
+	}
+
+	return directives, nil
+}
+
+func parseCanaryConfig(value gjson.Result) (canaryConfig, error) {
+	if !value.IsObject() {
+		return canaryConfig{}, errors.New("invalid host config, object expected for field canary")
+	}
+
+	directives, _, err := parseDirectives(value.Get("directives"))
+	if err != nil {
+		return canaryConfig{}, errors.New("invalid host config, directives expected for field canary.directives")
+	}
+
+	percent := numeric(value.Get("percent"))
+	if percent.Type != gjson.Number || percent.Num < 0 || percent.Num > 100 {
+		return canaryConfig{}, errors.New("invalid host config, percentage expected for field canary.percent")
+	}
+
+	return canaryConfig{directives: directives, percent: percent.Num}, nil
+}
+
+func parseBypassConfig(value gjson.Result) (bypassConfig, error) {
+	if !value.IsObject() {
+		return bypassConfig{}, errors.New("invalid host config, object expected for field bypassTokens")
+	}
+
+	cfg := bypassConfig{
+		secret:      value.Get("secret").String(),
+		header:      value.Get("header").String(),
+		cookie:      value.Get("cookie").String(),
+		maxLifetime: defaultBypassMaxLifetime,
+		log:         value.Get("log").Bool(),
+	}
+	if cfg.secret == "" {
+		return bypassConfig{}, errors.New("invalid host config, secret expected for field bypassTokens.secret")
+	}
+	if len(cfg.secret) < minBypassSecretLength {
+		return bypassConfig{}, errors.New("invalid host config, secret of at least 32 bytes expected for field bypassTokens.secret")
+	}
+	if maxLifetime := numeric(value.Get("maxLifetime")); maxLifetime.Exists() {
+		if maxLifetime.Type != gjson.Number || maxLifetime.Num <= 0 {
+			return bypassConfig{}, errors.New("invalid host config, seconds expected for field bypassTokens.maxLifetime")
+		}
+		cfg.maxLifetime = time.Duration(maxLifetime.Num * float64(time.Second))
+	}
+	if cfg.header == "" && cfg.cookie == "" {
+		cfg.header = defaultBypassHeader
+	}
+
+	return cfg, nil
+}
+
+func parseMaintenanceConfig(value gjson.Result) (maintenanceConfig, error) {
+	if !value.IsObject() {
+		return maintenanceConfig{}, errors.New("invalid host config, object expected for field maintenance")
+	}
+
+	cfg := defaultMaintenanceConfig()
+	cfg.enabled = value.Get("enabled").Bool()
+	if status := numeric(value.Get("status")); status.Exists() {
+		if status.Type != gjson.Number || status.Num < 100 || status.Num > 599 {
+			return maintenanceConfig{}, errors.New("invalid host config, status code expected for field maintenance.status")
+		}
+		cfg.statusCode = uint32(status.Num)
+	}
+	if contentType := value.Get("contentType"); contentType.Exists() {
+		cfg.contentType = contentType.String()
+	}
+	if body := value.Get("body"); body.Exists() {
+		cfg.body = body.String()
+	}
+
+	var err error
+	value.Get("paths").ForEach(func(_, path gjson.Result) bool {
+		if !strings.HasPrefix(path.Str, "/") {
+			err = errors.New("invalid host config, absolute paths expected for field maintenance.paths")
+			return false
+		}
+		cfg.paths = append(cfg.paths, path.Str)
+		return true
+	})
+	if err != nil {
+		return maintenanceConfig{}, err
+	}
+
+	return cfg, nil
+}
+
+func parseRateLimits(value gjson.Result) ([]rateLimitConfig, error) {
+	if !value.IsArray() {
+		return nil, errors.New("invalid host config, array expected for field rateLimits")
+	}
+
+	var (
+		limits []rateLimitConfig
+		err    error
+	)
+	value.ForEach(func(_, limit gjson.Result) bool {
+		cfg := rateLimitConfig{by: limit.Get("by").String()}
+		switch cfg.by {
+		case "":
+			cfg.by = rateLimitByIP
+		case rateLimitByIP, rateLimitByIPAndPath, rateLimitByFingerprint:
+		default:
+			err = errors.New("invalid host config, ip, ip+path or fingerprint expected for field rateLimits.by")
+			return false
+		}
+
+		rate := numeric(limit.Get("rate"))
+		if rate.Type != gjson.Number || rate.Num <= 0 {
+			err = errors.New("invalid host config, positive number expected for field rateLimits.rate")
+			return false
+		}
+		cfg.rate = rate.Num
+
+		cfg.burst = math.Max(1, math.Ceil(cfg.rate))
+		if burst := numeric(limit.Get("burst")); burst.Exists() {
+			if burst.Type != gjson.Number || burst.Num < 1 {
+				err = errors.New("invalid host config, number of requests expected for field rateLimits.burst")
+				return false
+			}
+			cfg.burst = burst.Num
+		}
+
+		limits = append(limits, cfg)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return limits, nil
+}
+
+func parseBotDetectionConfig(value gjson.Result) (botDetectionConfig, error) {
+	if !value.IsObject() {
+		return botDetectionConfig{}, errors.New("invalid host config, object expected for field botDetection")
+	}
+
+	cfg := botDetectionConfig{
+		enabled:      true,
+		threshold:    defaultBotThreshold,
+		action:       botActionLog,
+		challengeTTL: defaultBotChallengeTTL,
+	}
+	if threshold := numeric(value.Get("threshold")); threshold.Exists() {
+		if threshold.Type != gjson.Number || threshold.Num < 1 {
+			return botDetectionConfig{}, errors.New("invalid host config, positive score expected for field botDetection.threshold")
+		}
+		cfg.threshold = int(threshold.Num)
+	}
+	if action := value.Get("action"); action.Exists() {
+		cfg.action = action.String()
+		if cfg.action != botActionLog && cfg.action != botActionBlock && cfg.action != botActionChallenge {
+			return botDetectionConfig{}, errors.New("invalid host config, log, block or challenge expected for field botDetection.action")
+		}
+	}
+	if secret := value.Get("challengeSecret").String(); secret != "" {
+		cfg.challengeSecret = []byte(secret)
+	}
+	if ttl := numeric(value.Get("challengeTTL")); ttl.Exists() {
+		if ttl.Type != gjson.Number || ttl.Num < 1 {
+			return botDetectionConfig{}, errors.New("invalid host config, seconds expected for field botDetection.challengeTTL")
+		}
+		cfg.challengeTTL = time.Duration(ttl.Num) * time.Second
+	}
+
+	return cfg, nil
+}
+
+func parseDebugDumpConfig(value gjson.Result) (debugDumpConfig, error) {
+	if !value.IsObject() {
+		return debugDumpConfig{}, errors.New("invalid host config, object expected for field debugDump")
+	}
+
+	enabled := value.Get("enabled")
+	cfg := debugDumpConfig{
+		enabled: !enabled.Exists() || enabled.Bool(),
+		header:  "X-Coraza-Debug",
+		secret:  value.Get("secret").String(),
+	}
+	if len(cfg.secret) < 16 {
+		return debugDumpConfig{}, errors.New("invalid host config, secret of at least 16 bytes expected for field debugDump.secret")
+	}
+	if header := value.Get("header").String(); header != "" {
+		cfg.header = header
+	}
+	return cfg, nil
+}
+
+func parseSignedRequestsConfig(value gjson.Result) (signedRequestsConfig, error) {
+	if !value.IsObject() {
+		return signedRequestsConfig{}, errors.New("invalid host config, object expected for field signedRequests")
+	}
+
+	cfg := signedRequestsConfig{
+		enabled:         true,
+		secret:          []byte(value.Get("secret").String()),
+		timestampHeader: "X-Signature-Timestamp",
+		signatureHeader: "X-Signature",
+		maxSkew:         5 * time.Minute,
+		maxBodySize:     1 << 20,
+	}
+	if len(cfg.secret) < 32 {
+		return signedRequestsConfig{}, errors.New("invalid host config, secret of at least 32 bytes expected for field signedRequests.secret")
+	}
+	if header := value.Get("timestampHeader").String(); header != "" {
+		cfg.timestampHeader = header
+	}
+	if header := value.Get("signatureHeader").String(); header != "" {
+		cfg.signatureHeader = header
+	}
+
+	var err error
+	value.Get("paths").ForEach(func(_, path gjson.Result) bool {
+		if !strings.HasPrefix(path.Str, "/") {
+			err = errors.New("invalid host config, absolute paths expected for field signedRequests.paths")
+			return false
+		}
+		cfg.paths = append(cfg.paths, path.Str)
+		return true
+	})
+	if err != nil {
+		return signedRequestsConfig{}, err
+	}
+	if len(cfg.paths) == 0 {
+		return signedRequestsConfig{}, errors.New("invalid host config, paths expected for field signedRequests.paths")
+	}
+
+	if maxSkew := numeric(value.Get("maxSkew")); maxSkew.Exists() {
+		if maxSkew.Type != gjson.Number || maxSkew.Num < 1 {
+			return signedRequestsConfig{}, errors.New("invalid host config, seconds expected for field signedRequests.maxSkew")
+		}
+		cfg.maxSkew = time.Duration(maxSkew.Num) * time.Second
+	}
+	if size := numeric(value.Get("maxBodySize")); size.Exists() {
+		if size.Type != gjson.Number || size.Int() <= 0 {
+			return signedRequestsConfig{}, errors.New("invalid host config, positive number expected for field signedRequests.maxBodySize")
+		}
+		cfg.maxBodySize = int(size.Int())
+	}
+
+	return cfg, nil
+}
+
+func parseCSRFConfig(value gjson.Result) (csrfConfig, error) {
+	if !value.IsObject() {
+		return csrfConfig{}, errors.New("invalid host config, object expected for field csrf")
+	}
+
+	cfg := csrfConfig{
+		enabled: true,
+		cookie:  "csrf_token",
+		header:  "X-CSRF-Token",
+		field:   value.Get("field").String(),
+		action:  csrfActionBlock,
+	}
+	if cookie := value.Get("cookie").String(); cookie != "" {
+		cfg.cookie = cookie
+	}
+	if header := value.Get("header").String(); header != "" {
+		cfg.header = header
+	}
+	if action := value.Get("action"); action.Exists() {
+		cfg.action = action.String()
+		if cfg.action != csrfActionBlock && cfg.action != csrfActionLog {
+			return csrfConfig{}, errors.New("invalid host config, block or log expected for field csrf.action")
+		}
+	}
+
+	var err error
+	value.Get("paths").ForEach(func(_, path gjson.Result) bool {
+		if !strings.HasPrefix(path.Str, "/") {
+			err = errors.New("invalid host config, absolute paths expected for field csrf.paths")
+			return false
+		}
+		cfg.paths = append(cfg.paths, path.Str)
+		return true
+	})
+	if err != nil {
+		return csrfConfig{}, err
+	}
+
+	return cfg, nil
+}
+
+func parseBruteForceConfig(value gjson.Result) (bruteForceConfig, error) {
+	if !value.IsObject() {
+		return bruteForceConfig{}, errors.New("invalid host config, object expected for field bruteForce")
+	}
+
+	cfg := bruteForceConfig{
+		enabled:         true,
+		failureStatuses: []uint32{http.StatusUnauthorized, http.StatusForbidden},
+		failureHeader:   value.Get("failureHeader").String(),
+		usernameField:   value.Get("usernameField").String(),
+		maxFailures:     10,
+		window:          5 * time.Minute,
+		action:          bruteForceActionBlock,
+	}
+
+	var err error
+	value.Get("paths").ForEach(func(_, path gjson.Result) bool {
+		if !strings.HasPrefix(path.Str, "/") {
+			err = errors.New("invalid host config, absolute paths expected for field bruteForce.paths")
+			return false
+		}
+		cfg.paths = append(cfg.paths, path.Str)
+		return true
+	})
+	if err != nil {
+		return bruteForceConfig{}, err
+	}
+	if len(cfg.paths) == 0 {
+		return bruteForceConfig{}, errors.New("invalid host config, login paths expected for field bruteForce.paths")
+	}
+
+	if statuses := value.Get("failureStatuses"); statuses.Exists() {
+		cfg.failureStatuses = nil
+		statuses.ForEach(func(_, status gjson.Result) bool {
+			status = numeric(status)
+			if status.Type != gjson.Number || status.Num < 100 || status.Num > 599 {
+				err = errors.New("invalid host config, status codes expected for field bruteForce.failureStatuses")
+				return false
+			}
+			cfg.failureStatuses = append(cfg.failureStatuses, uint32(status.Num))
+			return true
+		})
+		if err != nil {
+			return bruteForceConfig{}, err
+		}
+	}
+
+	if maxFailures := numeric(value.Get("maxFailures")); maxFailures.Exists() {
+		if maxFailures.Type != gjson.Number || maxFailures.Num < 1 {
+			return bruteForceConfig{}, errors.New("invalid host config, positive number expected for field bruteForce.maxFailures")
+		}
+		cfg.maxFailures = int(maxFailures.Num)
+	}
+	if window := numeric(value.Get("window")); window.Exists() {
+		if window.Type != gjson.Number || window.Num < 1 {
+			return bruteForceConfig{}, errors.New("invalid host config, seconds expected for field bruteForce.window")
+		}
+		cfg.window = time.Duration(window.Num) * time.Second
+	}
+	if action := value.Get("action"); action.Exists() {
+		cfg.action = action.String()
+		if cfg.action != bruteForceActionBlock && cfg.action != bruteForceActionLog {
+			return bruteForceConfig{}, errors.New("invalid host config, block or log expected for field bruteForce.action")
+		}
+	}
+
+	return cfg, nil
+}
+
+func parseGeoPolicyConfig(value gjson.Result) (geoPolicyConfig, error) {
+	if !value.IsObject() {
+		return geoPolicyConfig{}, errors.New("invalid host config, object expected for field geoPolicy")
+	}
+
+	cfg := geoPolicyConfig{
+		enabled:       true,
+		countryHeader: value.Get("countryHeader").String(),
+		statusCode:    http.StatusForbidden,
+	}
+	if cfg.countryHeader == "" {
+		return geoPolicyConfig{}, errors.New("invalid host config, header expected for field geoPolicy.countryHeader")
+	}
+
+	for field, countries := range map[string]*[]string{"deny": &cfg.deny, "allowOnly": &cfg.allowOnly} {
+		list := value.Get(field)
+		if !list.Exists() {
+			continue
+		}
+		*countries = []string{}
+		var err error
+		list.ForEach(func(_, country gjson.Result) bool {
+			if len(country.Str) != 2 {
+				err = errors.New("invalid host config, country codes expected for field geoPolicy." + field)
+				return false
+			}
+			*countries = append(*countries, strings.ToUpper(country.Str))
+			return true
+		})
+		if err != nil {
+			return geoPolicyConfig{}, err
+		}
+	}
+	if cfg.deny == nil && cfg.allowOnly == nil {
+		return geoPolicyConfig{}, errors.New("invalid host config, deny or allowOnly expected for field geoPolicy")
+	}
+
+	if status := numeric(value.Get("status")); status.Exists() {
+		if status.Type != gjson.Number || status.Num < 100 || status.Num > 599 {
+			return geoPolicyConfig{}, errors.New("invalid host config, status code expected for field geoPolicy.status")
+		}
+		cfg.statusCode = uint32(status.Num)
+	}
+
+	return cfg, nil
+}
+
+func parseHoneypotConfig(value gjson.Result) (honeypotConfig, error) {
+	if !value.IsObject() {
+		return honeypotConfig{}, errors.New("invalid host config, object expected for field honeypot")
+	}
+
+	cfg := honeypotConfig{
+		enabled:     true,
+		banDuration: time.Hour,
+		statusCode:  http.StatusForbidden,
+		file:        value.Get("file").String(),
+	}
+
+	var err error
+	value.Get("paths").ForEach(func(_, path gjson.Result) bool {
+		if !strings.HasPrefix(path.Str, "/") {
+			err = errors.New("invalid host config, absolute paths expected for field honeypot.paths")
+			return false
+		}
+		cfg.paths = append(cfg.paths, path.Str)
+		return true
+	})
+	if err != nil {
+		return honeypotConfig{}, err
+	}
+	if len(cfg.paths) == 0 {
+		return honeypotConfig{}, errors.New("invalid host config, decoy paths expected for field honeypot.paths")
+	}
+
+	if banDuration := numeric(value.Get("banDuration")); banDuration.Exists() {
+		if banDuration.Type != gjson.Number || banDuration.Num < 1 {
+			return honeypotConfig{}, errors.New("invalid host config, seconds expected for field honeypot.banDuration")
+		}
+		cfg.banDuration = time.Duration(banDuration.Num) * time.Second
+	}
+	if status := numeric(value.Get("status")); status.Exists() {
+		if status.Type != gjson.Number || status.Num < 100 || status.Num > 599 {
+			return honeypotConfig{}, errors.New("invalid host config, status code expected for field honeypot.status")
+		}
+		cfg.statusCode = uint32(status.Num)
+	}
+
+	return cfg, nil
+}
+
+func parseTLSPolicyConfig(value gjson.Result) (tlsPolicyConfig, error) {
+	if !value.IsObject() {
+		return tlsPolicyConfig{}, errors.New("invalid host config, object expected for field tls")
+	}
+
+	cfg := tlsPolicyConfig{
+		enabled:       true,
+		versionHeader: value.Get("versionHeader").String(),
+		cipherHeader:  value.Get("cipherHeader").String(),
+		sniHeader:     value.Get("sniHeader").String(),
+		sniMatch:      value.Get("sniMatch").Bool(),
+	}
+	if minVersion := value.Get("minVersion"); minVersion.Exists() {
+		var ok bool
+		if cfg.minVersion, ok = parseTLSVersion(minVersion.String()); !ok {
+			return tlsPolicyConfig{}, errors.New("invalid host config, TLS version expected for field tls.minVersion")
+		}
+		if cfg.versionHeader == "" {
+			return tlsPolicyConfig{}, errors.New("invalid host config, header expected for field tls.versionHeader")
+		}
+	}
+	if cfg.sniMatch && cfg.sniHeader == "" {
+		return tlsPolicyConfig{}, errors.New("invalid host config, header expected for field tls.sniHeader")
+	}
+
+	return cfg, nil
+}
+
+func parseAllowedMethods(value gjson.Result) ([]string, error) {
+	if !value.IsArray() {
+		return nil, errors.New("invalid host config, array expected for field allowedMethods")
+	}
+
+	methods := []string{}
+	var err error
+	value.ForEach(func(_, method gjson.Result) bool {
+		// Methods are case-sensitive, a lowercase one is most likely a typo.
+		if method.Type != gjson.String || method.Str == "" || strings.ToUpper(method.Str) != method.Str {
+			err = errors.New("invalid host config, uppercase methods expected for field allowedMethods")
+			return false
+		}
+		methods = append(methods, method.Str)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("invalid host config, methods expected for field allowedMethods")
+	}
+	return methods, nil
+}
+
+func parseRequestLimitsConfig(value gjson.Result) (requestLimitsConfig, error) {
+	if !value.IsObject() {
+		return requestLimitsConfig{}, errors.New("invalid host config, object expected for field requestLimits")
+	}
+
+	var cfg requestLimitsConfig
+	for field, limit := range map[string]*int{
+		"maxURILength":   &cfg.maxURILength,
+		"maxHeaderSize":  &cfg.maxHeaderSize,
+		"maxHeadersSize": &cfg.maxHeadersSize,
+	} {
+		if v := numeric(value.Get(field)); v.Exists() {
+			if v.Type != gjson.Number || v.Num < 0 || v.Num != float64(int(v.Num)) {
+				return requestLimitsConfig{}, errors.New("invalid host config, number of bytes expected for field requestLimits." + field)
+			}
+			*limit = int(v.Num)
+		}
+	}
+
+	return cfg, nil
+}
+
+func parseSlowRequestConfig(value gjson.Result) (slowRequestConfig, error) {
+	if !value.IsObject() {
+		return slowRequestConfig{}, errors.New("invalid host config, object expected for field slowRequests")
+	}
+
+	cfg := slowRequestConfig{
+		enabled:     true,
+		minRate:     1024,
+		gracePeriod: 5 * time.Second,
+		action:      slowRequestActionBlock,
+	}
+	if minRate := numeric(value.Get("minRate")); minRate.Exists() {
+		if minRate.Type != gjson.Number || minRate.Num <= 0 {
+			return slowRequestConfig{}, errors.New("invalid host config, bytes per second expected for field slowRequests.minRate")
+		}
+		cfg.minRate = minRate.Num
+	}
+	if gracePeriod := numeric(value.Get("gracePeriod")); gracePeriod.Exists() {
+		if gracePeriod.Type != gjson.Number || gracePeriod.Num < 0 {
+			return slowRequestConfig{}, errors.New("invalid host config, seconds expected for field slowRequests.gracePeriod")
+		}
+		cfg.gracePeriod = time.Duration(gracePeriod.Num * float64(time.Second))
+	}
+	if action := value.Get("action"); action.Exists() {
+		cfg.action = action.String()
+		if cfg.action != slowRequestActionBlock && cfg.action != slowRequestActionLog {
+			return slowRequestConfig{}, errors.New("invalid host config, block or log expected for field slowRequests.action")
+		}
+	}
+
+	return cfg, nil
+}
+
+func parseScrubConfig(value gjson.Result) (scrubConfig, error) {
+	if !value.IsObject() {
+		return scrubConfig{}, errors.New("invalid host config, object expected for field scrubResponse")
+	}
+
+	cfg := scrubConfig{
+		enabled:    true,
+		headers:    defaultScrubbedHeaders,
+		server:     value.Get("server").String(),
+		errorPages: value.Get("errorPages").Bool(),
+	}
+	if headers := value.Get("headers"); headers.Exists() {
+		if !headers.IsArray() {
+			return scrubConfig{}, errors.New("invalid host config, array expected for field scrubResponse.headers")
+		}
+		cfg.headers = nil
+		headers.ForEach(func(_, h gjson.Result) bool {
+			cfg.headers = append(cfg.headers, h.String())
+			return true
+		})
+	}
+
+	return cfg, nil
+}
+
+func parseDataLeakConfig(value gjson.Result) (dataLeakConfig, error) {
+	if !value.IsObject() {
+		return dataLeakConfig{}, errors.New("invalid host config, object expected for field dataLeak")
+	}
+
+	cfg := dataLeakConfig{
+		action:      dataLeakActionBlock,
+		maxBodySize: defaultDataLeakMaxBodySize,
+	}
+
+	var err error
+	value.Get("detectors").ForEach(func(_, name gjson.Result) bool {
+		d, ok := dataLeakDetectors[name.String()]
+		if !ok {
+			err = errors.New("invalid host config, creditCard, ssn or secrets expected for field dataLeak.detectors")
+			return false
+		}
+		cfg.detectors = append(cfg.detectors, d)
+		return true
+	})
+	if err != nil {
+		return dataLeakConfig{}, err
+	}
+	if len(cfg.detectors) == 0 {
+		return dataLeakConfig{}, errors.New("invalid host config, detectors expected for field dataLeak.detectors")
+	}
+
+	if action := value.Get("action"); action.Exists() {
+		cfg.action = action.String()
+		if cfg.action != dataLeakActionBlock && cfg.action != dataLeakActionMask {
+			return dataLeakConfig{}, errors.New("invalid host config, block or mask expected for field dataLeak.action")
+		}
+	}
+
+	if size := numeric(value.Get("maxBodySize")); size.Exists() {
+		if size.Type != gjson.Number || size.Int() <= 0 {
+			return dataLeakConfig{}, errors.New("invalid host config, positive number expected for field dataLeak.maxBodySize")
+		}
+		cfg.maxBodySize = int(size.Int())
+	}
+
+	return cfg, nil
+}
+
+func parseVirtualPatches(value gjson.Result) ([]virtualPatch, error) {
+	var patches []virtualPatch
+	if !value.IsArray() || json.Unmarshal([]byte(value.Raw), &patches) != nil {
+		return nil, errors.New("invalid host config, array of patches expected for field virtualPatches")
+	}
+
+	ids := map[string]struct{}{}
+	for _, p := range patches {
+		if err := p.validate(); err != nil {
+			return nil, errors.New("invalid host config, " + err.Error())
+		}
+		if _, ok := ids[p.ID]; ok {
+			return nil, errors.New("invalid host config, duplicate patch " + p.ID)
+		}
+		ids[p.ID] = struct{}{}
+	}
+	if err := checkVirtualPatchRuleIDs(patches); err != nil {
+		return nil, errors.New("invalid host config, " + err.Error())
+	}
+
+	return patches, nil
+}
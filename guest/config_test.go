@@ -0,0 +1,502 @@
+package guest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDirectivesFromHost(t *testing.T) {
+	t.Run("empty config", func(t *testing.T) {
+		cfg, err := getConfigFromHost(mockAPIHost{t: t, getConfig: func() []byte {
+			return nil
+		}})
+		require.True(t, cfg.includeCRS)
+		require.Empty(t, cfg.directives)
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte("abcd")
+		}})
+		require.ErrorContains(t, err, "invalid host config")
+	})
+
+	t.Run("truncated JSON", func(t *testing.T) {
+		_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte("{\"directives\": [\"SecRuleEngine On\"")
+		}})
+		require.ErrorContains(t, err, "invalid host config")
+	})
+
+	t.Run("missing directives", func(t *testing.T) {
+		_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte("{\"includeCRS\": false}")
+		}})
+		require.ErrorContains(t, err, "array expected for field directives")
+	})
+
+	t.Run("invalid directives value", func(t *testing.T) {
+		_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte("{\"directives\": true}")
+		}})
+		require.ErrorContains(t, err, "invalid host config")
+	})
+
+	t.Run("invalid default client IP", func(t *testing.T) {
+		_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"directives": ["SecRuleEngine On"], "defaultClientIP": "unknown"}`)
+		}})
+		require.ErrorContains(t, err, "IP expected for field defaultClientIP")
+	})
+
+	t.Run("invalid admin", func(t *testing.T) {
+		for admin, expectedErr := range map[string]string{
+			`true`:                            "object expected for field admin",
+			`{"token": "secret"}`:             "absolute path expected for field admin.path",
+			`{"path": "admin", "token": "x"}`: "absolute path expected for field admin.path",
+			`{"path": "/admin"}`:              "token expected for field admin.token",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "admin": ` + admin + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, admin)
+		}
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"directives": ["SecRuleEngine On"], "mode": "off"}`)
+		}})
+		require.ErrorContains(t, err, "enforce, detect or bypass expected for field mode")
+	})
+
+	t.Run("invalid kill switch interval", func(t *testing.T) {
+		_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"directives": ["SecRuleEngine On"], "killSwitch": {"interval": "5s"}}`)
+		}})
+		require.ErrorContains(t, err, "seconds expected for field killSwitch.interval")
+	})
+
+	t.Run("invalid bot detection", func(t *testing.T) {
+		for bot, expectedErr := range map[string]string{
+			`true`:                   "object expected for field botDetection",
+			`{"threshold": 0}`:       "positive score expected for field botDetection.threshold",
+			`{"action": "captcha"}`:  "log, block or challenge expected for field botDetection.action",
+			`{"challengeTTL": "1h"}`: "seconds expected for field botDetection.challengeTTL",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "botDetection": ` + bot + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, bot)
+		}
+	})
+
+	t.Run("invalid signed requests", func(t *testing.T) {
+		secret := `"secret": "` + strings.Repeat("s", 32) + `"`
+		for signedRequests, expectedErr := range map[string]string{
+			`true`: "object expected for field signedRequests",
+			`{"paths": ["/webhooks/"], "secret": "short"}`: "secret of at least 32 bytes expected for field signedRequests.secret",
+			`{` + secret + `}`:                                               "paths expected for field signedRequests.paths",
+			`{` + secret + `, "paths": ["webhooks"]}`:                        "absolute paths expected for field signedRequests.paths",
+			`{` + secret + `, "paths": ["/webhooks/"], "maxSkew": 0}`:        "seconds expected for field signedRequests.maxSkew",
+			`{` + secret + `, "paths": ["/webhooks/"], "maxBodySize": "1M"}`: "positive number expected for field signedRequests.maxBodySize",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "signedRequests": ` + signedRequests + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, signedRequests)
+		}
+	})
+
+	t.Run("invalid csrf", func(t *testing.T) {
+		for csrf, expectedErr := range map[string]string{
+			`true`:                   "object expected for field csrf",
+			`{"action": "score"}`:    "block or log expected for field csrf.action",
+			`{"paths": ["account"]}`: "absolute paths expected for field csrf.paths",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "csrf": ` + csrf + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, csrf)
+		}
+	})
+
+	t.Run("invalid brute force", func(t *testing.T) {
+		for bruteForce, expectedErr := range map[string]string{
+			`true`:                 "object expected for field bruteForce",
+			`{}`:                   "login paths expected for field bruteForce.paths",
+			`{"paths": ["login"]}`: "absolute paths expected for field bruteForce.paths",
+			`{"paths": ["/login"], "failureStatuses": [1]}`: "status codes expected for field bruteForce.failureStatuses",
+			`{"paths": ["/login"], "maxFailures": 0}`:       "positive number expected for field bruteForce.maxFailures",
+			`{"paths": ["/login"], "window": "5m"}`:         "seconds expected for field bruteForce.window",
+			`{"paths": ["/login"], "action": "score"}`:      "block or log expected for field bruteForce.action",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "bruteForce": ` + bruteForce + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, bruteForce)
+		}
+	})
+
+	t.Run("invalid debug dump", func(t *testing.T) {
+		for debugDump, expectedErr := range map[string]string{
+			`"0123456789abcdef"`:    "object expected for field debugDump",
+			`{"header": "X-Debug"}`: "secret of at least 16 bytes expected for field debugDump.secret",
+			`{"secret": "short"}`:   "secret of at least 16 bytes expected for field debugDump.secret",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "debugDump": ` + debugDump + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, debugDump)
+		}
+	})
+
+	t.Run("invalid version check", func(t *testing.T) {
+		for _, versionCheck := range []string{`1`, `""`, `">=1.x"`, `">=1.2.0,"`, `"~1.2"`} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "versionCheck": ` + versionCheck + `}`)
+			}})
+			require.ErrorContains(t, err, "version constraint expected for field versionCheck", versionCheck)
+		}
+	})
+
+	t.Run("invalid geo policy", func(t *testing.T) {
+		for geoPolicy, expectedErr := range map[string]string{
+			`["RU"]`:                            "object expected for field geoPolicy",
+			`{"deny": ["RU"]}`:                  "header expected for field geoPolicy.countryHeader",
+			`{"countryHeader": "CF-IPCountry"}`: "deny or allowOnly expected for field geoPolicy",
+			`{"countryHeader": "CF-IPCountry", "deny": ["Russia"]}`:      "country codes expected for field geoPolicy.deny",
+			`{"countryHeader": "CF-IPCountry", "allowOnly": [1]}`:        "country codes expected for field geoPolicy.allowOnly",
+			`{"countryHeader": "CF-IPCountry", "deny": [], "status": 0}`: "status code expected for field geoPolicy.status",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "geoPolicy": ` + geoPolicy + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, geoPolicy)
+		}
+	})
+
+	t.Run("invalid honeypot", func(t *testing.T) {
+		for honeypot, expectedErr := range map[string]string{
+			`["/wp-login.php"]`:    "object expected for field honeypot",
+			`{}`:                   "decoy paths expected for field honeypot.paths",
+			`{"paths": ["admin"]}`: "absolute paths expected for field honeypot.paths",
+			`{"paths": ["/.env"], "banDuration": "1h"}`: "seconds expected for field honeypot.banDuration",
+			`{"paths": ["/.env"], "status": 99}`:        "status code expected for field honeypot.status",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "honeypot": ` + honeypot + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, honeypot)
+		}
+	})
+
+	t.Run("invalid tls", func(t *testing.T) {
+		for tls, expectedErr := range map[string]string{
+			`"1.2"`: "object expected for field tls",
+			`{"versionHeader": "X-Tls-Version", "minVersion": "2.0"}`: "TLS version expected for field tls.minVersion",
+			`{"minVersion": "1.2"}`:                                   "header expected for field tls.versionHeader",
+			`{"sniMatch": true}`:                                      "header expected for field tls.sniHeader",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "tls": ` + tls + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, tls)
+		}
+	})
+
+	t.Run("invalid allowed methods", func(t *testing.T) {
+		for allowedMethods, expectedErr := range map[string]string{
+			`"GET"`:           "array expected for field allowedMethods",
+			`[]`:              "methods expected for field allowedMethods",
+			`["GET", "post"]`: "uppercase methods expected for field allowedMethods",
+			`["GET", 1]`:      "uppercase methods expected for field allowedMethods",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "allowedMethods": ` + allowedMethods + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, allowedMethods)
+		}
+	})
+
+	t.Run("invalid request limits", func(t *testing.T) {
+		for requestLimits, expectedErr := range map[string]string{
+			`8192`:                       "object expected for field requestLimits",
+			`{"maxURILength": -1}`:       "number of bytes expected for field requestLimits.maxURILength",
+			`{"maxHeaderSize": "8k"}`:    "number of bytes expected for field requestLimits.maxHeaderSize",
+			`{"maxHeadersSize": 1024.5}`: "number of bytes expected for field requestLimits.maxHeadersSize",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "requestLimits": ` + requestLimits + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, requestLimits)
+		}
+	})
+
+	t.Run("invalid slow requests", func(t *testing.T) {
+		for slowRequests, expectedErr := range map[string]string{
+			`true`:                    "object expected for field slowRequests",
+			`{"minRate": 0}`:          "bytes per second expected for field slowRequests.minRate",
+			`{"gracePeriod": "5s"}`:   "seconds expected for field slowRequests.gracePeriod",
+			`{"action": "challenge"}`: "block or log expected for field slowRequests.action",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "slowRequests": ` + slowRequests + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, slowRequests)
+		}
+	})
+
+	t.Run("invalid scrub response", func(t *testing.T) {
+		for scrub, expectedErr := range map[string]string{
+			`true`:                  "object expected for field scrubResponse",
+			`{"headers": "Server"}`: "array expected for field scrubResponse.headers",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "scrubResponse": ` + scrub + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, scrub)
+		}
+	})
+
+	t.Run("invalid data leak", func(t *testing.T) {
+		for dataLeak, expectedErr := range map[string]string{
+			`true`:                     "object expected for field dataLeak",
+			`{}`:                       "detectors expected for field dataLeak.detectors",
+			`{"detectors": ["email"]}`: "creditCard, ssn or secrets expected for field dataLeak.detectors",
+			`{"detectors": ["ssn"], "action": "log"}`:  "block or mask expected for field dataLeak.action",
+			`{"detectors": ["ssn"], "maxBodySize": 0}`: "positive number expected for field dataLeak.maxBodySize",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "dataLeak": ` + dataLeak + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, dataLeak)
+		}
+	})
+
+	t.Run("invalid virtual patches", func(t *testing.T) {
+		for patches, expectedErr := range map[string]string{
+			`{}`:                "array of patches expected for field virtualPatches",
+			`[{"id": 1}]`:       "array of patches expected for field virtualPatches",
+			`[{"id": "CVE-1"}]`: "path pattern expected for patch CVE-1",
+			`[{"id": "CVE-1", "path": "^/"}, {"id": "CVE-1", "path": "^/a"}]`: "duplicate patch CVE-1",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "virtualPatches": ` + patches + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, patches)
+		}
+	})
+
+	t.Run("invalid rate limits", func(t *testing.T) {
+		for limits, expectedErr := range map[string]string{
+			`{"rate": 1}`:                   "array expected for field rateLimits",
+			`[{"by": "cookie", "rate": 1}]`: "ip, ip+path or fingerprint expected for field rateLimits.by",
+			`[{"rate": 0}]`:                 "positive number expected for field rateLimits.rate",
+			`[{"rate": 1, "burst": 0.5}]`:   "number of requests expected for field rateLimits.burst",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "rateLimits": ` + limits + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, limits)
+		}
+	})
+
+	t.Run("invalid maintenance", func(t *testing.T) {
+		for maintenance, expectedErr := range map[string]string{
+			`true`:                "object expected for field maintenance",
+			`{"status": 42}`:      "status code expected for field maintenance.status",
+			`{"paths": ["api/"]}`: "absolute paths expected for field maintenance.paths",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "maintenance": ` + maintenance + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, maintenance)
+		}
+	})
+
+	t.Run("invalid bypass tokens", func(t *testing.T) {
+		for bypass, expectedErr := range map[string]string{
+			`"s3cr3t"`:               "object expected for field bypassTokens",
+			`{"header": "X-Bypass"}`: "secret expected for field bypassTokens.secret",
+			`{"secret": "s3cr3t"}`:   "secret of at least 32 bytes expected for field bypassTokens.secret",
+			`{"secret": "` + bypassSecret + `", "maxLifetime": 0}`:    "seconds expected for field bypassTokens.maxLifetime",
+			`{"secret": "` + bypassSecret + `", "maxLifetime": "1d"}`: "seconds expected for field bypassTokens.maxLifetime",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "bypassTokens": ` + bypass + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, bypass)
+		}
+	})
+
+	t.Run("invalid canary", func(t *testing.T) {
+		for canary, expectedErr := range map[string]string{
+			`5`:                                    "object expected for field canary",
+			`{"percent": 5}`:                       "directives expected for field canary.directives",
+			`{"directives": ["SecRuleEngine On"]}`: "percentage expected for field canary.percent",
+			`{"directives": ["SecRuleEngine On"], "percent": "a"}`: "percentage expected for field canary.percent",
+			`{"directives": ["SecRuleEngine On"], "percent": 101}`: "percentage expected for field canary.percent",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "canary": ` + canary + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, canary)
+		}
+	})
+
+	t.Run("invalid shadow", func(t *testing.T) {
+		for shadow, expectedErr := range map[string]string{
+			`["SecRuleEngine On"]`: "object expected for field shadow",
+			`{}`:                   "directives expected for field shadow.directives",
+			`{"directives": []}`:   "directives expected for field shadow.directives",
+		} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"directives": ["SecRuleEngine On"], "shadow": ` + shadow + `}`)
+			}})
+			require.ErrorContains(t, err, expectedErr, shadow)
+		}
+	})
+
+	t.Run("empty directives", func(t *testing.T) {
+		_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte("{\"directives\": []}")
+		}})
+		require.ErrorContains(t, err, "empty directives")
+	})
+
+	t.Run("valid directives", func(t *testing.T) {
+		cfg, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`
+			{
+				"directives": [
+					"SecRuleEngine: On",
+					"SecDebugLog /etc/var/logs/coraza.conf"
+				]
+			}
+			`)
+		}})
+		require.True(t, cfg.includeCRS)
+		require.NoError(t, err)
+		require.Equal(t, "SecRuleEngine: On\nSecDebugLog /etc/var/logs/coraza.conf", cfg.directives)
+	})
+
+	t.Run("directives as string", func(t *testing.T) {
+		cfg, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"directives": "SecRuleEngine On\nSecRequestBodyAccess On\n"}`)
+		}})
+		require.NoError(t, err)
+		require.Equal(t, "SecRuleEngine On\nSecRequestBodyAccess On\n", cfg.directives)
+	})
+
+	t.Run("empty directives string", func(t *testing.T) {
+		_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"directives": " "}`)
+		}})
+		require.ErrorContains(t, err, "empty directives")
+	})
+
+	t.Run("boolean as string", func(t *testing.T) {
+		// e.g. Traefik docker labels are always strings.
+		cfg, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"includeCRS": "false", "verdictHeaders": "true", "directives": ["SecRuleEngine On"]}`)
+		}})
+		require.NoError(t, err)
+		require.False(t, cfg.includeCRS)
+		require.True(t, cfg.verdictHeaders)
+	})
+
+	t.Run("number as string", func(t *testing.T) {
+		cfg, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{
+				"inflightTimeout": "30",
+				"rateLimits": [{"rate": "10", "burst": " 20 "}],
+				"honeypot": {"paths": ["/.env"], "banDuration": "60", "status": "404"},
+				"bruteForce": {"paths": ["/login"], "failureStatuses": ["401"]},
+				"canary": {"directives": ["SecRuleEngine On"], "percent": "2.5"},
+				"dataLeak": {"detectors": ["creditCard"], "maxBodySize": "1024"},
+				"directives": ["SecRuleEngine On"]
+			}`)
+		}})
+		require.NoError(t, err)
+		require.Equal(t, 30*time.Second, cfg.inflightTimeout)
+		require.Equal(t, 10.0, cfg.rateLimits[0].rate)
+		require.Equal(t, 20.0, cfg.rateLimits[0].burst)
+		require.Equal(t, time.Minute, cfg.honeypot.banDuration)
+		require.Equal(t, uint32(404), cfg.honeypot.statusCode)
+		require.Equal(t, []uint32{401}, cfg.bruteForce.failureStatuses)
+		require.Equal(t, 2.5, cfg.canary.percent)
+		require.Equal(t, 1024, cfg.dataLeak.maxBodySize)
+
+		for _, invalid := range []string{`"ten"`, `"NaN"`, `"Inf"`, `""`} {
+			_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+				return []byte(`{"rateLimits": [{"rate": ` + invalid + `}], "directives": ["SecRuleEngine On"]}`)
+			}})
+			require.Error(t, err, invalid)
+		}
+	})
+
+	t.Run("inspect host errors", func(t *testing.T) {
+		cfg, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"inspectHostErrors": true, "directives": ["SecRuleEngine On"]}`)
+		}})
+		require.NoError(t, err)
+		require.True(t, cfg.inspectHostErrors)
+	})
+
+	t.Run("inflight timeout", func(t *testing.T) {
+		cfg, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"directives": ["SecRuleEngine On"]}`)
+		}})
+		require.NoError(t, err)
+		require.Equal(t, defaultInflightTimeout, cfg.inflightTimeout)
+
+		cfg, err = getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"inflightTimeout": 0.5, "directives": ["SecRuleEngine On"]}`)
+		}})
+		require.NoError(t, err)
+		require.Equal(t, 500*time.Millisecond, cfg.inflightTimeout)
+
+		_, err = getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"inflightTimeout": "10m", "directives": ["SecRuleEngine On"]}`)
+		}})
+		require.ErrorContains(t, err, "seconds expected for field inflightTimeout")
+	})
+
+	t.Run("excluding CRS", func(t *testing.T) {
+		cfg, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"includeCRS": false, "directives": ["SecRuleEngine On"]}`)
+		}})
+		require.NoError(t, err)
+		require.False(t, cfg.includeCRS)
+		require.Equal(t, "SecRuleEngine On", cfg.directives)
+	})
+}
+
+func FuzzGetConfigFromHost(f *testing.F) {
+	for _, cfg := range []string{
+		``,
+		`{"directives": ["SecRuleEngine On"]}`,
+		`{"directives": "SecRuleEngine On\nSecRequestBodyAccess On", "includeCRS": false}`,
+		`{"directives": [], "mode": "detect"}`,
+		`{"directives": ["SecRuleEngine On"], "rateLimits": [{"by": "ip+path", "rate": 0.5, "burst": 2}]}`,
+		`{"directives": ["SecRuleEngine On"], "geoPolicy": {"countryHeader": "CF-IPCountry", "deny": ["KP"]}}`,
+		`{"directives": ["SecRuleEngine On"], "requestLimits": {"maxURILength": 1e300}}`,
+		`{"directives": ["SecRuleEngine On"], "virtualPatches": [{"id": "CVE-1", "path": "^/", "params": [{"name": "q", "pattern": "("}]}]}`,
+		`{"directives": ["SecRuleEngine On"], "canary": {"directives": ["SecRuleEngine On"], "percent": -1}}`,
+		`{"directives": ["SecRuleEngine On"], "versionCheck": ">=1.2, <2"}`,
+	} {
+		f.Add([]byte(cfg))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte { return data }})
+		if err != nil || len(data) == 0 {
+			return
+		}
+		require.NotEmpty(t, cfg.directives, "a valid config should have directives")
+	})
+}
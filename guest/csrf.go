@@ -0,0 +1,108 @@
+package guest
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// CSRF actions, taken on state-changing requests without a valid token.
+const (
+	// csrfActionBlock answers with a 403.
+	csrfActionBlock = "block"
+	// csrfActionLog lets the request through, setting TX:csrf_failed for the
+	// rules of the later phases and the audit logs.
+	csrfActionLog = "log"
+)
+
+// csrfFailedVariable is the TX variable set when the CSRF check fails.
+const csrfFailedVariable = "csrf_failed"
+
+// csrfConfig configures the double-submit CSRF protection: a random token is
+// issued as a cookie, and state-changing requests have to send it back in a
+// header or form field, which a cross-site request can't do.
+type csrfConfig struct {
+	enabled bool
+	// paths restricts the check to the requests under these path prefixes,
+	// all requests are checked when empty.
+	paths  []string
+	cookie string
+	header string
+	// field is the form field the token can be sent in instead of the
+	// header, it requires SecRequestBodyAccess On.
+	field  string
+	action string
+}
+
+// csrfSafeMethods don't change state, hence are never checked.
+var csrfSafeMethods = map[string]struct{}{
+	http.MethodGet:     {},
+	http.MethodHead:    {},
+	http.MethodOptions: {},
+	http.MethodTrace:   {},
+}
+
+// checkCSRF tells whether the request passes the CSRF check. It is called
+// once the request body is processed, as the token may be sent in a form
+// field.
+func (e *engine) checkCSRF(tx types.Transaction, req api.Request) bool {
+	cfg := e.cfg.csrf
+	if _, ok := csrfSafeMethods[req.GetMethod()]; ok {
+		return true
+	}
+	if !matchPaths(req.GetURI(), cfg.paths) {
+		return true
+	}
+
+	cookie := requestCookie(req.Headers(), cfg.cookie)
+	if cookie == "" {
+		return false
+	}
+
+	token, _ := req.Headers().Get(cfg.header)
+	if token == "" && cfg.field != "" {
+		if state, ok := tx.(plugintypes.TransactionState); ok {
+			if values := state.Variables().ArgsPost().Get(cfg.field); len(values) > 0 {
+				token = values[0]
+			}
+		}
+	}
+
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(token)) == 1
+}
+
+// handleCSRFFailure takes the configured action for a request failing the
+// CSRF check, returning whether the request was answered.
+func (e *engine) handleCSRFFailure(tx types.Transaction, res api.Response) bool {
+	metrics.csrfFailures.Add(1)
+	if state, ok := tx.(plugintypes.TransactionState); ok {
+		state.Variables().TX().Set(csrfFailedVariable, []string{"1"})
+	}
+
+	if e.cfg.csrf.action != csrfActionBlock || e.mode() == modeDetect {
+		tx.DebugLogger().Info().Msg("Request failed the CSRF check")
+		return false
+	}
+	res.SetStatusCode(http.StatusForbidden)
+	return true
+}
+
+// issueCSRFToken sets the CSRF cookie on the response when the client has
+// none yet. The cookie is readable from JavaScript, so that pages can send it
+// back in the header.
+func (e *engine) issueCSRFToken(req api.Request, resp api.Response) {
+	if requestCookie(req.Headers(), e.cfg.csrf.cookie) != "" {
+		return
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return
+	}
+	resp.Headers().Add("Set-Cookie", e.cfg.csrf.cookie+"="+hex.EncodeToString(token)+"; Path=/; SameSite=Lax")
+}
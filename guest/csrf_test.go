@@ -0,0 +1,85 @@
+package guest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRF(t *testing.T) {
+	const cfg = `{
+		"csrf": {"paths": ["/account/"], "field": "csrf_token"},
+		"directives": ["SecRuleEngine On", "SecRequestBodyAccess On"]
+	}`
+
+	tests := map[string]struct {
+		method, uri, body string
+		cookie, header    string
+		expectedStatus    uint32
+	}{
+		"safe method":         {method: "GET", uri: "/account/", expectedStatus: 200},
+		"other path":          {method: "POST", uri: "/login", expectedStatus: 200},
+		"missing cookie":      {method: "POST", uri: "/account/", header: "abc", expectedStatus: 403},
+		"missing token":       {method: "POST", uri: "/account/", cookie: "abc", expectedStatus: 403},
+		"header mismatch":     {method: "POST", uri: "/account/", cookie: "abc", header: "abd", expectedStatus: 403},
+		"header match":        {method: "DELETE", uri: "/account/?id=1", cookie: "abc", header: "abc", expectedStatus: 200},
+		"form field match":    {method: "POST", uri: "/account/", body: "csrf_token=abc", cookie: "abc", expectedStatus: 200},
+		"form field mismatch": {method: "POST", uri: "/account/", body: "csrf_token=abd", cookie: "abc", expectedStatus: 403},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			useEngine(t, cfg)
+
+			req := newMockRequest(test.method, test.uri, test.body)
+			if test.body != "" {
+				req.headers.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if test.cookie != "" {
+				req.headers.Set("Cookie", "csrf_token="+test.cookie)
+			}
+			if test.header != "" {
+				req.headers.Set("X-CSRF-Token", test.header)
+			}
+			res := newMockResponse(200, "")
+			serve(req, res)
+			require.Equal(t, test.expectedStatus, res.statusCode)
+		})
+	}
+
+	t.Run("token issuance", func(t *testing.T) {
+		useEngine(t, cfg)
+
+		res := newMockResponse(200, "")
+		serve(newMockRequest("GET", "/", ""), res)
+		cookies := res.headers.GetAll("Set-Cookie")
+		require.Len(t, cookies, 1)
+		require.True(t, strings.HasPrefix(cookies[0], "csrf_token="))
+
+		req := newMockRequest("GET", "/", "")
+		req.headers.Set("Cookie", "csrf_token=abc")
+		res = newMockResponse(200, "")
+		serve(req, res)
+		require.Empty(t, res.headers.GetAll("Set-Cookie"), "the client already has a token")
+	})
+
+	t.Run("log action", func(t *testing.T) {
+		useEngine(t, `{
+			"csrf": {"action": "log"},
+			"directives": [
+				"SecRuleEngine On",
+				"SecRule TX:csrf_failed \"@eq 1\" \"id:1,phase:3,deny,status:403\""
+			]
+		}`)
+
+		failures := metrics.csrfFailures.Load()
+		next, reqCtx := HandleRequest(newMockRequest("POST", "/", ""), newMockResponse(200, ""))
+		require.True(t, next)
+		require.Equal(t, uint64(1), metrics.csrfFailures.Load()-failures)
+
+		res := newMockResponse(200, "")
+		HandleResponse(reqCtx, newMockRequest("POST", "/", ""), res, false)
+		require.Equal(t, uint32(403), res.statusCode, "later phases see TX:csrf_failed")
+	})
+}
@@ -0,0 +1,258 @@
+package guest
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// Data leak actions, taken on response bodies where a detector matches.
+const (
+	// dataLeakActionBlock replaces the response with a 403.
+	dataLeakActionBlock = "block"
+	// dataLeakActionMask replaces the matches with asterisks, keeping the
+	// last four digits of credit card numbers.
+	dataLeakActionMask = "mask"
+)
+
+// Data leak detectors.
+const (
+	// dataLeakCreditCard matches card numbers passing the Luhn check.
+	dataLeakCreditCard = "creditCard"
+	// dataLeakSSN matches US social security numbers.
+	dataLeakSSN = "ssn"
+	// dataLeakSecrets matches private keys and well-known API key formats.
+	dataLeakSecrets = "secrets"
+)
+
+// defaultDataLeakMaxBodySize bounds the response bodies scanned by default.
+const defaultDataLeakMaxBodySize = 1 << 20
+
+// dataLeakConfig configures the data leak detection on response bodies, an
+// easy alternative to the equivalent outbound rules.
+type dataLeakConfig struct {
+	detectors []dataLeakDetector
+	action    string
+	// maxBodySize bounds the response bodies scanned, larger ones are let
+	// through untouched.
+	maxBodySize int
+}
+
+// dataLeakDetector finds the candidate matches of pattern, keeping the part
+// valid tells apart from false positives when set, returned as a pair of
+// indexes into the candidate as regexp.FindIndex does, nil if none.
+type dataLeakDetector struct {
+	name    string
+	pattern *regexp.Regexp
+	valid   func([]byte) []int
+}
+
+var dataLeakDetectors = map[string]dataLeakDetector{
+	dataLeakCreditCard: {
+		name:    dataLeakCreditCard,
+		pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid:   cardNumber,
+	},
+	dataLeakSSN: {
+		name: dataLeakSSN,
+		// Area 000, 666 and 9xx, group 00 and serial 0000 are never issued.
+		pattern: regexp.MustCompile(`\b(?:00[1-9]|0[1-9]\d|[1-578]\d\d|6[0-57-9]\d|66[0-57-9])-(?:0[1-9]|[1-9]\d)-(?:000[1-9]|00[1-9]\d|0[1-9]\d\d|[1-9]\d{3})\b`),
+	},
+	dataLeakSecrets: {
+		name: dataLeakSecrets,
+		pattern: regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----` +
+			`|\b(?:AKIA|ASIA)[0-9A-Z]{16}\b` +
+			`|\bgh[pousr]_[A-Za-z0-9]{36}\b` +
+			`|\bxox[abposr]-[A-Za-z0-9-]{10,}` +
+			`|\bAIza[0-9A-Za-z_-]{35}\b` +
+			`|\bsk_live_[0-9A-Za-z]{24,}\b`),
+	},
+}
+
+// luhn tells whether the digits of the card number, separators aside, pass
+// the Luhn checksum.
+func luhn(number []byte) bool {
+	sum, n := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// cardNumber returns the longest run of digit groups in the candidate with 13
+// to 19 digits passing the Luhn checksum, the leftmost one among the longest,
+// so that the number is found when other digits follow it, as in
+// "4111 1111 1111 1111 123". Runs start and end at the separators only,
+// candidates without separators being checked as a whole.
+func cardNumber(candidate []byte) []int {
+	// starts and ends hold the indexes of the digit groups, digits the
+	// number of digits before each group.
+	var starts, ends, digits []int
+	n := 0
+	for i := 0; i < len(candidate); i++ {
+		if candidate[i] == ' ' || candidate[i] == '-' {
+			continue
+		}
+		if i == 0 || candidate[i-1] == ' ' || candidate[i-1] == '-' {
+			starts = append(starts, i)
+			digits = append(digits, n)
+		}
+		if i == len(candidate)-1 || candidate[i+1] == ' ' || candidate[i+1] == '-' {
+			ends = append(ends, i+1)
+		}
+		n++
+	}
+	digits = append(digits, n)
+
+	var best []int
+	for first := range starts {
+		for last := first; last < len(ends); last++ {
+			count := digits[last+1] - digits[first]
+			if count > 19 {
+				break
+			}
+			if count < 13 || (best != nil && ends[last]-starts[first] <= best[1]-best[0]) {
+				continue
+			}
+			if luhn(candidate[starts[first]:ends[last]]) {
+				best = []int{starts[first], ends[last]}
+			}
+		}
+	}
+	return best
+}
+
+// detectDataLeaks returns the names of the detectors matching the body and,
+// with the mask action, the body with the matches masked.
+func (cfg dataLeakConfig) detectDataLeaks(body []byte) ([]string, []byte) {
+	var found []string
+	var masked []byte
+	for _, d := range cfg.detectors {
+		matched := false
+		for _, loc := range d.pattern.FindAllIndex(body, -1) {
+			if d.valid != nil {
+				valid := d.valid(body[loc[0]:loc[1]])
+				if valid == nil {
+					continue
+				}
+				loc = []int{loc[0] + valid[0], loc[0] + valid[1]}
+			}
+			matched = true
+			if cfg.action != dataLeakActionMask {
+				break
+			}
+			if masked == nil {
+				masked = bytes.Clone(body)
+			}
+			maskMatch(masked[loc[0]:loc[1]], d.name == dataLeakCreditCard)
+		}
+		if matched {
+			found = append(found, d.name)
+		}
+	}
+	return found, masked
+}
+
+// maskMatch replaces the match in place, so that the body length doesn't
+// change, keeping the separators and the last four digits of card numbers.
+func maskMatch(match []byte, keepLastDigits bool) {
+	keep := 0
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if keepLastDigits {
+			if c == ' ' || c == '-' {
+				continue
+			}
+			if keep < 4 {
+				keep++
+				continue
+			}
+		}
+		match[i] = '*'
+	}
+}
+
+// dataLeakScannable tells whether the response body can be scanned: encoded
+// bodies can neither be matched nor masked.
+func dataLeakScannable(resp api.Response) bool {
+	encoding, ok := resp.Headers().Get("Content-Encoding")
+	return !ok || strings.EqualFold(encoding, "identity")
+}
+
+// readBodyPrefix reads the body until it ends or more than limit bytes were
+// read, telling whether the whole body was read. As the body is read in
+// segments, a whole body may still be larger than limit.
+func readBodyPrefix(body bodyReader, limit int) ([]byte, bool) {
+	segment := bodySegments.Get().(*[]byte)
+	defer bodySegments.Put(segment)
+
+	var prefix []byte
+	for {
+		size, eof := body.Read(*segment)
+		prefix = append(prefix, (*segment)[:size]...)
+		if eof || size == 0 {
+			return prefix, true
+		}
+		if len(prefix) > limit {
+			return prefix, false
+		}
+	}
+}
+
+// prefixedBody reads prefix, already read from body, then the rest of body
+// unless eof is set.
+type prefixedBody struct {
+	prefix []byte
+	body   bodyReader
+	eof    bool
+}
+
+func (b *prefixedBody) Read(p []byte) (uint32, bool) {
+	if len(b.prefix) == 0 {
+		if b.eof {
+			return 0, true
+		}
+		return b.body.Read(p)
+	}
+	n := copy(p, b.prefix)
+	b.prefix = b.prefix[n:]
+	return uint32(n), b.eof && len(b.prefix) == 0
+}
+
+// handleDataLeaks scans the response body, blocking or masking it when a
+// detector matches. Leaks are only logged in detect mode and for the
+// requests carrying a bypass token, as the request side checks do.
+func (e *engine) handleDataLeaks(tx types.Transaction, resp api.Response, body []byte, bypassed bool) {
+	found, masked := e.cfg.dataLeak.detectDataLeaks(body)
+	if len(found) == 0 {
+		return
+	}
+
+	metrics.dataLeaks.Add(1)
+	e.host.Log(api.LogLevelInfo, "Data leak detected in the response of transaction "+tx.ID()+": "+strings.Join(found, ", "))
+	if bypassed || e.mode() == modeDetect {
+		return
+	}
+	if e.cfg.dataLeak.action == dataLeakActionMask {
+		e.replaceResponseBody(resp, masked)
+		return
+	}
+
+	resp.SetStatusCode(http.StatusForbidden)
+	e.truncateResponseBody(resp)
+}
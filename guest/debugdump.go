@@ -0,0 +1,129 @@
+package guest
+
+import (
+	"crypto/subtle"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/collection"
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/corazawaf/coraza/v3/types/variables"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// maxDumpedValueSize truncates the values in the dumps, e.g. bodies.
+const maxDumpedValueSize = 256
+
+// debugDumpConfig configures the debug dumps: requests carrying the secret in
+// header get their transaction dumped to the host logs once done, so that a
+// false positive can be investigated without raising the log level of all
+// the requests.
+type debugDumpConfig struct {
+	enabled bool
+	header  string
+	secret  string
+}
+
+// txDump collects what is dumped besides the transaction itself, a nil one
+// meaning the transaction is not dumped.
+type txDump struct {
+	phases []phaseTiming
+}
+
+type phaseTiming struct {
+	phase    string
+	duration time.Duration
+}
+
+// debugDump returns the dump of the request, nil unless it carries the
+// secret. The header is removed so that the secret reaches neither the
+// upstream nor the audit logs.
+func (e *engine) debugDump(req api.Request) *txDump {
+	cfg := e.cfg.debugDump
+	secret, ok := req.Headers().Get(cfg.header)
+	if !ok {
+		return nil
+	}
+	req.Headers().Remove(cfg.header)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(cfg.secret)) != 1 {
+		return nil
+	}
+	return &txDump{}
+}
+
+// start returns the time a phase starts at, the zero time when not dumping
+// so that the others don't pay for it.
+func (d *txDump) start() time.Time {
+	if d == nil {
+		return time.Time{}
+	}
+	return timeSource.Now()
+}
+
+// done records the time taken by the phase started at start.
+func (d *txDump) done(phase string, start time.Time) {
+	if d != nil {
+		d.phases = append(d.phases, phaseTiming{phase: phase, duration: timeSource.Now().Sub(start)})
+	}
+}
+
+// dumpTransaction logs the phase timings, the matched rules and the
+// variables with a value of the transaction, once its phases ran.
+func (e *engine) dumpTransaction(tx types.Transaction, d *txDump) {
+	if d == nil {
+		return
+	}
+	metrics.debugDumps.Add(1)
+
+	var b strings.Builder
+	b.WriteString("Debug dump of the transaction " + tx.ID() + "\nPhases:")
+	for _, p := range d.phases {
+		b.WriteString("\n  " + p.phase + ": " + p.duration.String())
+	}
+
+	b.WriteString("\nMatched rules:")
+	for _, mr := range tx.MatchedRules() {
+		b.WriteString("\n  " + strconv.Itoa(mr.Rule().ID()))
+		if msg := mr.Message(); msg != "" {
+			b.WriteString(" " + strconv.Quote(msg))
+		}
+		if data := mr.Data(); data != "" {
+			b.WriteString(" data " + strconv.Quote(truncateDumped(data)))
+		}
+	}
+
+	if state, ok := tx.(plugintypes.TransactionState); ok {
+		b.WriteString("\nVariables:")
+		var lines []string
+		state.Variables().All(func(_ variables.RuleVariable, col collection.Collection) bool {
+			for _, md := range col.FindAll() {
+				if md.Value() == "" {
+					continue
+				}
+				name := md.Variable().Name()
+				if md.Key() != "" {
+					name += ":" + md.Key()
+				}
+				lines = append(lines, "\n  "+name+" = "+strconv.Quote(truncateDumped(md.Value())))
+			}
+			return true
+		})
+		// Collections are walked in no particular order.
+		sort.Strings(lines)
+		for _, l := range lines {
+			b.WriteString(l)
+		}
+	}
+
+	e.host.Log(api.LogLevelInfo, b.String())
+}
+
+func truncateDumped(v string) string {
+	if len(v) > maxDumpedValueSize {
+		return v[:maxDumpedValueSize] + "..."
+	}
+	return v
+}
@@ -0,0 +1,65 @@
+package guest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugDump(t *testing.T) {
+	useEngine(t, `{
+		"debugDump": {"secret": "0123456789abcdef"},
+		"directives": [
+			"SecRuleEngine On",
+			"SecResponseBodyAccess On",
+			"SecResponseBodyMimeType text/plain",
+			"SecRule ARGS:q \"@contains attack\" \"id:1,phase:1,deny,status:403,msg:'Attack'\"",
+			"SecRule RESPONSE_BODY \"@contains secret\" \"id:2,phase:4,deny,status:403\""
+		]
+	}`)
+	var logs bytes.Buffer
+	e := activeEngine.Load()
+	e.host = recordingHost{mockAPIHost: e.host.(mockAPIHost), logs: &logs}
+	t.Cleanup(func() { e.host = e.host.(recordingHost).mockAPIHost })
+
+	get := func(uri, secret string) *mockRequest {
+		logs.Reset()
+		req := newMockRequest("GET", uri, "")
+		if secret != "" {
+			req.headers.Set("X-Coraza-Debug", secret)
+		}
+		serve(req, newMockResponse(200, "hello"))
+		return req
+	}
+
+	t.Run("interrupted request", func(t *testing.T) {
+		req := get("/?q=attack", "0123456789abcdef")
+		require.Empty(t, req.headers.GetAll("X-Coraza-Debug"))
+		require.Contains(t, logs.String(), "Debug dump of the transaction")
+		require.Contains(t, logs.String(), "\n  request headers: ")
+		require.Contains(t, logs.String(), "\n  logging: ")
+		require.Contains(t, logs.String(), "\nMatched rules:\n  1 \"Attack\"\n")
+		require.Contains(t, logs.String(), "\n  ARGS_GET:q = \"attack\"")
+		require.NotContains(t, logs.String(), "0123456789abcdef")
+		require.NotContains(t, logs.String(), `REMOTE_HOST = ""`)
+	})
+
+	t.Run("response phases", func(t *testing.T) {
+		get("/", "0123456789abcdef")
+		require.Contains(t, logs.String(), "\n  response headers: ")
+		require.Contains(t, logs.String(), "\n  response body: ")
+		require.Contains(t, logs.String(), "\n  RESPONSE_BODY = \"hello\"")
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		req := get("/?q=attack", "fedcba9876543210")
+		require.Empty(t, req.headers.GetAll("X-Coraza-Debug"))
+		require.NotContains(t, logs.String(), "Debug dump")
+	})
+
+	t.Run("no header", func(t *testing.T) {
+		get("/?q=attack", "")
+		require.NotContains(t, logs.String(), "Debug dump")
+	})
+}
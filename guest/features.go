@@ -0,0 +1,113 @@
+package guest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// optionalFeatures are the features used when the host grants them, the
+// connector works the same without them:
+//   - api.FeatureTrailers adds the trailers to the request and response
+//     headers once the body has been read, e.g. to inspect grpc-status.
+const optionalFeatures = api.FeatureTrailers
+
+// negotiateFeatures enables the features the ruleset needs and returns the
+// ones granted by the host. Hosts differ on what they support, e.g. some of
+// them (Dapr, NGINX Unit) may not support buffering the response, hence
+// rather than assuming a feature is there, the connector falls back to a
+// header-only mode when buffering is not granted:
+//   - Without api.FeatureBufferRequest the request body is not read, as that
+//     would consume it before reaching the upstream. Phase 2 rules still run
+//     but the ones inspecting the body see an empty one.
+//   - Without api.FeatureBufferResponse the response has already been sent
+//     by the time the response handler runs, hence phase 3 rules run in
+//     detection only and the response body is not inspected. With it, the
+//     host holds the whole response until the handler returns, which is what
+//     guarantees blocked response bodies never reach the client.
+//
+// Features not required to enforce the ruleset, see optionalFeatures, are
+// requested along and used only when granted.
+func negotiateFeatures(host api.Host, ruleset rulesetInfo) api.Features {
+	var want api.Features
+	if ruleset.requestBody || ruleset.requestBodyLimit != 0 {
+		want |= api.FeatureBufferRequest
+	}
+
+	if ruleset.responsePhases {
+		want |= api.FeatureBufferResponse
+	} else {
+		host.Log(api.LogLevelInfo, "No rules for the response phases, skipping response processing")
+	}
+
+	var optional api.Features
+	if want != 0 {
+		// Trailers are only available once the body has been read.
+		optional = optionalFeatures
+	}
+
+	// Per spec, hosts not supporting a feature don't grant it rather than
+	// failing, hence optional features are requested at once.
+	have := host.EnableFeatures(want | optional)
+	if have&want != want {
+		host.Log(api.LogLevelWarn, "Unexpected features, want: "+want.String()+", have: "+have.String())
+	}
+
+	if missing := optional &^ have; missing != 0 && host.LogEnabled(api.LogLevelDebug) {
+		host.Log(api.LogLevelDebug, "Host does not support optional features: "+missing.String())
+	}
+
+	if ruleset.requestBody && !have.IsEnabled(api.FeatureBufferRequest) {
+		host.Log(api.LogLevelWarn, "Host does not support request buffering, request body rules are disabled")
+	}
+
+	if ruleset.responsePhases && !have.IsEnabled(api.FeatureBufferResponse) {
+		host.Log(api.LogLevelWarn, "Host does not support response buffering, response header rules run in detection only and response body rules are disabled: blocked responses reach the client")
+	}
+
+	return have
+}
+
+// headerOnly returns whether the ruleset needs buffering the host did not
+// grant, in which case only headers are inspected, see negotiateFeatures.
+func (e *engine) headerOnly() bool {
+	return (e.ruleset.requestBody && !e.features.IsEnabled(api.FeatureBufferRequest)) ||
+		(e.ruleset.responsePhases && !e.features.IsEnabled(api.FeatureBufferResponse))
+}
+
+// inspectRequestBody returns whether the request body has to be read.
+func (e *engine) inspectRequestBody() bool {
+	return e.ruleset.requestBody && e.features.IsEnabled(api.FeatureBufferRequest)
+}
+
+// mayExceedBodyLimit returns whether the request body has to be read for
+// coraza to enforce SecRequestBodyLimit, even though no rule inspects it. The
+// bodies declaring a length within the limit are not read.
+func (e *engine) mayExceedBodyLimit(headers api.Header) bool {
+	if e.ruleset.requestBodyLimit == 0 || !e.features.IsEnabled(api.FeatureBufferRequest) {
+		return false
+	}
+
+	contentLength, ok := headers.Get("Content-Length")
+	if !ok {
+		// e.g. chunked or HTTP/2 bodies.
+		return true
+	}
+	length, err := strconv.ParseInt(contentLength, 10, 64)
+	return err != nil || length > e.ruleset.requestBodyLimit
+}
+
+// addTrailers adds the trailers, if granted, using add.
+func (e *engine) addTrailers(add func(key, value string), trailers func() api.Header) {
+	if !e.features.IsEnabled(api.FeatureTrailers) {
+		return
+	}
+
+	t := trailers()
+	for _, k := range t.Names() {
+		if ts := t.GetAll(k); len(ts) > 0 {
+			add(k, strings.Join(ts, "; "))
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package guest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostFeatures(t *testing.T) {
+	const cfg = `
+	{
+		"directives": [
+			"SecRuleEngine On",
+			"SecRequestBodyAccess On",
+			"SecResponseBodyAccess On",
+			"SecResponseBodyMimeType text/plain",
+			"SecRule ARGS_POST \"@contains attack\" \"id:1,phase:2,deny,status:403\"",
+			"SecRule RESPONSE_BODY \"@contains secret\" \"id:2,phase:4,deny,status:403\""
+		]
+	}`
+
+	// Each host is described by the features it grants.
+	hosts := map[string]struct {
+		features                  api.Features
+		expectedHeaderOnly        bool
+		expectedRequestInspected  bool
+		expectedResponseInspected bool
+	}{
+		"fully featured": {
+			features:                  allFeatures,
+			expectedRequestInspected:  true,
+			expectedResponseInspected: true,
+		},
+		"no response buffering": {
+			features:                 api.FeatureBufferRequest,
+			expectedHeaderOnly:       true,
+			expectedRequestInspected: true,
+		},
+		"no buffering": {
+			expectedHeaderOnly: true,
+		},
+	}
+
+	for name, host := range hosts {
+		t.Run(name, func(t *testing.T) {
+			useEngine(t, cfg, host.features)
+			require.Equal(t, host.expectedHeaderOnly, activeEngine.Load().headerOnly())
+
+			req := newMockRequest("POST", "/", "q=attack")
+			req.headers.Set("Content-Type", "application/x-www-form-urlencoded")
+			res := newMockResponse(200, "")
+			serve(req, res)
+			require.Equal(t, host.expectedRequestInspected, res.statusCode == 403)
+			require.Equal(t, host.expectedRequestInspected, req.body.reads > 0)
+
+			res = newMockResponse(200, "secret")
+			serve(newMockRequest("GET", "/", ""), res)
+			require.Equal(t, host.expectedResponseInspected, res.statusCode == 403)
+			require.Equal(t, host.expectedResponseInspected, res.body.reads > 0)
+
+			require.Zero(t, txs.len(), "transactions leaked")
+		})
+	}
+
+	t.Run("response headers in header-only mode", func(t *testing.T) {
+		useEngine(t, `
+		{
+			"directives": [
+				"SecRuleEngine On",
+				"SecRule RESPONSE_HEADERS:X-Leak \"@rx .\" \"id:1,phase:3,deny,status:403\""
+			]
+		}`, 0)
+
+		res := newMockResponse(200, "")
+		res.headers.Set("X-Leak", "yes")
+		serve(newMockRequest("GET", "/", ""), res)
+		require.Equal(t, uint32(200), res.statusCode, "response interruption can't be enforced")
+		require.Zero(t, txs.len(), "transactions leaked")
+	})
+
+	t.Run("trailers are inspected when granted", func(t *testing.T) {
+		const cfg = `
+		{
+			"directives": [
+				"SecRuleEngine On",
+				"SecRequestBodyAccess On",
+				"SecResponseBodyAccess On",
+				"SecResponseBodyMimeType text/plain",
+				"SecRule REQUEST_HEADERS:X-Checksum \"@streq bad\" \"id:1,phase:2,deny,status:400\"",
+				"SecRule RESPONSE_HEADERS:Grpc-Status \"@streq 13\" \"id:2,phase:4,deny,status:403\"",
+				"SecRule REQUEST_BODY \"@rx .\" \"id:3,phase:2,pass,nolog\""
+			]
+		}`
+
+		for _, features := range []api.Features{allFeatures, allFeatures &^ api.FeatureTrailers} {
+			useEngine(t, cfg, features)
+			granted := features.IsEnabled(api.FeatureTrailers)
+
+			req := newMockRequest("POST", "/", "payload")
+			req.trailers.Set("X-Checksum", "bad")
+			res := newMockResponse(200, "")
+			serve(req, res)
+			require.Equal(t, granted, res.statusCode == 400)
+
+			res = newMockResponse(200, "payload")
+			res.trailers.Set("Grpc-Status", "13")
+			serve(newMockRequest("GET", "/", ""), res)
+			require.Equal(t, granted, res.statusCode == 403)
+		}
+	})
+
+	t.Run("features not needed by the ruleset are not requested", func(t *testing.T) {
+		var logs bytes.Buffer
+		have := negotiateFeatures(recordingHost{mockAPIHost: mockAPIHost{features: allFeatures}, logs: &logs}, rulesetInfo{})
+		require.Zero(t, have)
+		require.NotContains(t, logs.String(), "Unexpected features")
+	})
+
+	t.Run("missing optional features are not unexpected", func(t *testing.T) {
+		var logs bytes.Buffer
+		host := recordingHost{mockAPIHost: mockAPIHost{features: api.FeatureBufferRequest | api.FeatureBufferResponse}, logs: &logs}
+		have := negotiateFeatures(host, allPhases)
+		require.Equal(t, api.FeatureBufferRequest|api.FeatureBufferResponse, have)
+		require.NotContains(t, logs.String(), "Unexpected features")
+	})
+}
+
+// recordingHost records the log messages.
+type recordingHost struct {
+	mockAPIHost
+	logs *bytes.Buffer
+}
+
+func (h recordingHost) Log(_ api.LogLevel, msg string) {
+	h.logs.WriteString(msg + "\n")
+}
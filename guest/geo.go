@@ -0,0 +1,33 @@
+package guest
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// geoPolicyConfig configures the country policy. There is no GeoIP database
+// in the guest, hence the country is read from a header set by the host or a
+// CDN in front of it, e.g. CF-IPCountry.
+type geoPolicyConfig struct {
+	enabled       bool
+	countryHeader string
+	// deny lists the ISO 3166-1 alpha-2 country codes rejected.
+	deny []string
+	// allowOnly lists the only country codes accepted when set, requests
+	// without a country being rejected as well.
+	allowOnly  []string
+	statusCode uint32
+}
+
+// deniedCountry tells whether the country the request comes from is denied
+// by the policy, along with the country.
+func (cfg geoPolicyConfig) deniedCountry(headers api.Header) (string, bool) {
+	country, _ := headers.Get(cfg.countryHeader)
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if cfg.allowOnly != nil && !slices.Contains(cfg.allowOnly, country) {
+		return country, true
+	}
+	return country, country != "" && slices.Contains(cfg.deny, country)
+}
@@ -0,0 +1,38 @@
+package guest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoPolicy(t *testing.T) {
+	get := func(country string) uint32 {
+		req := newMockRequest("GET", "/", "")
+		if country != "" {
+			req.headers.Set("CF-IPCountry", country)
+		}
+		res := newMockResponse(200, "")
+		serve(req, res)
+		return res.statusCode
+	}
+
+	t.Run("deny", func(t *testing.T) {
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "geoPolicy": {"countryHeader": "CF-IPCountry", "deny": ["RU", "kp"], "status": 451}}`)
+
+		denied := metrics.geoDenied.Load()
+		require.Equal(t, uint32(451), get("RU"))
+		require.Equal(t, uint32(451), get("kp"))
+		require.Equal(t, uint32(200), get("FR"))
+		require.Equal(t, uint32(200), get(""))
+		require.Equal(t, uint64(2), metrics.geoDenied.Load()-denied)
+	})
+
+	t.Run("allow only", func(t *testing.T) {
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "geoPolicy": {"countryHeader": "CF-IPCountry", "allowOnly": ["FR", "DE"]}}`)
+
+		require.Equal(t, uint32(200), get("FR"))
+		require.Equal(t, uint32(403), get("US"))
+		require.Equal(t, uint32(403), get(""), "requests without a country should be denied")
+	})
+}
@@ -0,0 +1,873 @@
+// Package guest implements the Coraza http-wasm guest: Init initializes the
+// WAF while HandleRequest and HandleResponse implement the http-wasm handlers.
+// It allows embedding the same logic in custom guests, e.g.
+//
+//	func main() {
+//		handler.HandleRequestFn = guest.HandleRequest
+//		handler.HandleResponseFn = guest.HandleResponse
+//		if err := guest.Init(handler.Host); err != nil {
+//			handler.Host.Log(api.LogLevelError, err.Error())
+//			os.Exit(1)
+//		}
+//	}
+package guest
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	coreruleset "github.com/corazawaf/coraza-coreruleset/v4"
+	"github.com/corazawaf/coraza/v3"
+	"github.com/corazawaf/coraza/v3/debuglog"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+	"github.com/jcchavezs/mergefs"
+	fsio "github.com/jcchavezs/mergefs/io"
+)
+
+// engine holds the WAF along with the analysis of its ruleset, both are
+// swapped at once so that a request never sees a mix of them.
+type engine struct {
+	// host is used to log outside of transactions.
+	host api.Host
+	waf  coraza.WAF
+	cfg  config
+	// ruleset describes the phases the loaded rules need, see analyzeRuleset.
+	ruleset rulesetInfo
+	// features holds the features granted by the host.
+	features api.Features
+	// overrides holds the changes made through the admin API.
+	overrides overrides
+	// limiter enforces the rate limits, nil unless configured.
+	limiter *rateLimiter
+	// detect is the WAF running the ruleset in detection only mode for the
+	// requests carrying a bypass token, see validBypassToken, and for all
+	// of them while the kill switch is engaged. Nil unless either is
+	// configured.
+	detect coraza.WAF
+	// canary is the WAF enforcing the canary ruleset on a slice of the
+	// traffic, nil unless configured, see inCanary.
+	canary coraza.WAF
+	// canaryDetect is the canary counterpart of detect, serving the canary
+	// slice while the kill switch is engaged. Nil unless both are
+	// configured.
+	canaryDetect coraza.WAF
+	// shadow is the WAF evaluating the candidate ruleset, nil unless
+	// configured, see shadowRequest.
+	shadow coraza.WAF
+}
+
+// Concurrency model: a wasm module instance is single threaded. Hosts invoke
+// the handlers of an instance for one request at a time, from HandleRequest
+// to HandleResponse, and serve concurrent requests from a pool of instances,
+// each one with its own state. The ABI layer relies on it, see readBuf in
+// internal/abi. Native Go programs using this package, e.g. through
+// hosttest, may however invoke the handlers from several goroutines at once,
+// hence the state shared across requests is safe for concurrent use:
+//   - activeEngine is only read atomically and replaced as a whole.
+//   - txs guards its slots with a mutex, timeSource and txIDs are only
+//     replaced by tests, before any request.
+//   - body segments are taken from a sync.Pool.
+//   - admin changes are serialized by adminMu, the kill switch and metrics
+//     are atomic.
+//
+// Transactions themselves are never shared: each one is only used by the
+// handler invocations of its own request.
+var activeEngine atomic.Pointer[engine]
+
+// txs holds the transactions waiting for the response to be processed.
+var txs = newTxStore()
+
+// Init initializes the WAF from the host config and negotiates the features
+// it needs with the host, see negotiateFeatures. It has to be called once
+// before the handlers.
+//
+// Note: we use the same WAF instance for all requests.
+func Init(host api.Host) error {
+	host.Log(api.LogLevelInfo, "Starting coraza-http-wasm "+Version+" ("+Commit+")")
+
+	e, err := initializeWAF(host, overrides{})
+	if err != nil {
+		return err
+	}
+
+	e.features = negotiateFeatures(host, e.ruleset)
+	if e.headerOnly() {
+		host.Log(api.LogLevelWarn, "Running in header-only mode")
+	}
+	activeEngine.Store(e)
+	return nil
+}
+
+func toHostLevel(lvl debuglog.Level) api.LogLevel {
+	switch lvl {
+	case debuglog.LevelNoLog:
+		return api.LogLevelNone
+	case debuglog.LevelError:
+		return api.LogLevelError
+	case debuglog.LevelWarn:
+		return api.LogLevelWarn
+	case debuglog.LevelInfo:
+		return api.LogLevelInfo
+	default:
+		return api.LogLevelDebug
+	}
+}
+
+func errorCb(host api.Host) func(types.MatchedRule) {
+	return func(mr types.MatchedRule) {
+		logMsg := mr.ErrorLog()
+		switch mr.Rule().Severity() {
+		case types.RuleSeverityEmergency,
+			types.RuleSeverityAlert,
+			types.RuleSeverityCritical,
+			types.RuleSeverityError:
+			host.Log(api.LogLevelError, logMsg)
+		case types.RuleSeverityWarning:
+			host.Log(api.LogLevelWarn, logMsg)
+		case types.RuleSeverityNotice,
+			types.RuleSeverityInfo:
+			host.Log(api.LogLevelInfo, logMsg)
+		case types.RuleSeverityDebug:
+			host.Log(api.LogLevelDebug, logMsg)
+		}
+	}
+}
+
+// initializeWAF builds the WAF from the host config, applying the overrides
+// on top of the configured directives.
+func initializeWAF(host api.Host, ov overrides) (*engine, error) {
+	cfg, err := getConfigFromHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.versionCheck != nil {
+		if err := cfg.versionCheck.check(Version); err != nil {
+			return nil, err
+		}
+	}
+
+	root := rootFS(cfg)
+
+	if cfg.directives == "" {
+		host.Log(api.LogLevelWarn, "Initializing WAF with no directives")
+	} else if host.LogEnabled(api.LogLevelDebug) {
+		if cfg.includeCRS {
+			host.Log(api.LogLevelDebug, "Initializing WAF with CRS embedded and directives:\n"+cfg.directives)
+		} else {
+			host.Log(api.LogLevelDebug, "Initializing WAF with directives:\n"+cfg.directives)
+		}
+	}
+
+	if d := ov.directives(); d != "" {
+		host.Log(api.LogLevelInfo, "Applying admin overrides:\n"+d)
+	}
+
+	mode := effectiveMode(cfg, ov)
+	if mode == modeDetect || mode == modeBypass {
+		host.Log(api.LogLevelWarn, "Running in "+mode+" mode, requests are not blocked")
+	}
+
+	// Virtual patches apply to every enforcing ruleset.
+	directives, canaryDirectives := cfg.directives, cfg.canary.directives
+	if patches := append(slices.Clone(cfg.virtualPatches), ov.virtualPatches...); len(patches) > 0 {
+		// The runtime patches were checked against the configured ones
+		// when added, but those may have changed since.
+		if err := checkVirtualPatchRuleIDs(patches); err != nil {
+			return nil, err
+		}
+		d := virtualPatchDirectives(patches)
+		if host.LogEnabled(api.LogLevelDebug) {
+			host.Log(api.LogLevelDebug, "Applying virtual patches:\n"+d)
+		}
+		directives += "\n" + d
+		canaryDirectives += "\n" + d
+	}
+
+	waf, err := newWAF(host, root, directives, ov, mode)
+	if err != nil {
+		return nil, locateDirectiveError(root, cfg, err)
+	}
+
+	e := &engine{
+		host:      host,
+		waf:       waf,
+		cfg:       cfg,
+		ruleset:   analyzeRuleset(root, directives),
+		overrides: ov,
+		limiter:   newRateLimiter(cfg.rateLimits),
+	}
+
+	ipRateLimits := slices.ContainsFunc(cfg.rateLimits, func(l rateLimitConfig) bool { return l.by != rateLimitByFingerprint })
+	if cfg.clientIPHeader == "" && cfg.defaultClientIP == "" && (cfg.honeypot.enabled || cfg.bruteForce.enabled || ipRateLimits) {
+		// clientAddr reports the unusable source addresses once seen, this
+		// tells upfront what they cost.
+		host.Log(api.LogLevelWarn, "Neither clientIPHeader nor defaultClientIP is set, the honeypot bans, IP rate limits and brute force IP counters are skipped for requests without a usable source address")
+	}
+
+	if cfg.honeypot.file != "" {
+		if err := loadBans(cfg.honeypot.file, timeSource.Now()); err != nil {
+			host.Log(api.LogLevelWarn, "Failed to load the persisted bans: "+err.Error())
+		}
+	}
+
+	if cfg.csrf.enabled {
+		// Tokens are issued on the responses, and may be sent in the body.
+		e.ruleset = e.ruleset.union(rulesetInfo{responsePhases: true, requestBody: cfg.csrf.field != ""})
+	}
+
+	if cfg.bruteForce.enabled {
+		// Failures are seen on the responses, and usernames in the body.
+		e.ruleset = e.ruleset.union(rulesetInfo{responsePhases: true, requestBody: cfg.bruteForce.usernameField != ""})
+	}
+
+	if cfg.scrub.enabled {
+		// Headers can only be changed before the response is sent.
+		e.ruleset = e.ruleset.union(rulesetInfo{responsePhases: true})
+	}
+
+	if cfg.signedRequests.enabled {
+		// Signatures cover the body.
+		e.ruleset = e.ruleset.union(rulesetInfo{requestBody: true})
+	}
+
+	if cfg.dataLeak.detectors != nil {
+		e.ruleset = e.ruleset.union(rulesetInfo{responsePhases: true})
+	}
+
+	if cfg.bypassTokens.log || cfg.killSwitch.enabled {
+		if e.detect, err = newWAF(host, root, directives, ov, modeDetect); err != nil {
+			return nil, err
+		}
+	}
+
+	// The data the shadow and canary WAFs inspect is read the same way as
+	// for the primary one, hence the ruleset info has to cover all of them.
+	if cfg.canary.directives != "" {
+		if host.LogEnabled(api.LogLevelDebug) {
+			host.Log(api.LogLevelDebug, "Initializing canary WAF with directives:\n"+cfg.canary.directives)
+		}
+		if e.canary, err = newWAF(host, root, canaryDirectives, ov, mode); err != nil {
+			return nil, errors.New("invalid canary directives: " + err.Error())
+		}
+		if cfg.killSwitch.enabled {
+			if e.canaryDetect, err = newWAF(host, root, canaryDirectives, ov, modeDetect); err != nil {
+				return nil, errors.New("invalid canary directives: " + err.Error())
+			}
+		}
+		e.ruleset = e.ruleset.union(analyzeRuleset(root, canaryDirectives))
+	}
+
+	if cfg.shadowDirectives != "" {
+		if e.shadow, err = newShadowWAF(host, root, cfg.shadowDirectives); err != nil {
+			return nil, err
+		}
+		e.ruleset = e.ruleset.union(analyzeRuleset(root, cfg.shadowDirectives))
+	}
+
+	return e, nil
+}
+
+// rootFS returns the filesystem the directives are resolved in, the embedded
+// CRS being merged on top of the host one when included.
+func rootFS(cfg config) fs.FS {
+	if cfg.includeCRS {
+		return mergefs.Merge(coreruleset.FS, fsio.OSFS)
+	}
+	return fsio.OSFS
+}
+
+// newWAF builds a WAF from the directives, applying the overrides and the
+// mode on top of them.
+func newWAF(host api.Host, root fs.FS, directives string, ov overrides, mode string) (coraza.WAF, error) {
+	wafConfig := coraza.NewWAFConfig().WithRootFS(root)
+	if directives != "" {
+		wafConfig = wafConfig.WithDirectives(directives)
+	}
+	if d := ov.directives(); d != "" {
+		wafConfig = wafConfig.WithDirectives(d)
+	}
+	if mode == modeDetect {
+		wafConfig = wafConfig.WithDirectives("SecRuleEngine DetectionOnly")
+	}
+
+	return coraza.NewWAF(wafConfig.WithDebugLogger(debugLogger(host, ov.debugLogLevel)).WithErrorCallback(errorCb(host)))
+}
+
+// HandleRequest implements api.HandleRequest, running the request phases.
+func HandleRequest(req api.Request, res api.Response) (next bool, reqCtx uint32) {
+	e := activeEngine.Load()
+	if e.cfg.admin.path != "" && e.serveAdmin(req, res) {
+		return false, 0
+	}
+
+	now := timeSource.Now()
+	e.pollKillSwitch(now)
+	e.revertDebugLogLevel(now)
+	e.reapInflight(now)
+	if e.maintenance() && e.serveMaintenance(req, res) {
+		return false, 0
+	}
+
+	// The debug dump header, the bypass token and the verdict headers sent
+	// by the client are looked up first so that they get removed whatever
+	// happens to the request.
+	if e.cfg.verdictHeaders {
+		removeVerdictHeaders(req.Headers())
+	}
+
+	var dump *txDump
+	if e.cfg.debugDump.enabled {
+		dump = e.debugDump(req)
+	}
+
+	var bypassToken string
+	if e.cfg.bypassTokens.secret != "" {
+		bypassToken = e.takeBypassToken(req)
+	}
+
+	if e.mode() == modeBypass {
+		return true, 0
+	}
+
+	bypassed := bypassToken != "" && e.validBypassToken(req, bypassToken, now)
+	if bypassed {
+		metrics.bypassedRequests.Add(1)
+		if !e.cfg.bypassTokens.log {
+			return true, 0
+		}
+	}
+
+	client, cport := e.clientAddr(req)
+
+	if e.cfg.honeypot.enabled && !bypassed && !e.checkHoneypot(req, res, client, now) {
+		return false, 0
+	}
+
+	if e.cfg.geoPolicy.enabled && !bypassed {
+		if country, denied := e.cfg.geoPolicy.deniedCountry(req.Headers()); denied {
+			metrics.geoDenied.Add(1)
+			if e.host.LogEnabled(api.LogLevelInfo) {
+				e.host.Log(api.LogLevelInfo, "Request from "+client+" comes from the denied country "+strconv.Quote(country))
+			}
+			if e.mode() != modeDetect {
+				res.SetStatusCode(e.cfg.geoPolicy.statusCode)
+				return false, 0
+			}
+		}
+	}
+
+	if e.cfg.allowedMethods != nil && !bypassed && !slices.Contains(e.cfg.allowedMethods, req.GetMethod()) {
+		metrics.disallowedMethods.Add(1)
+		if e.host.LogEnabled(api.LogLevelInfo) {
+			e.host.Log(api.LogLevelInfo, "Request from "+client+" uses the disallowed method "+req.GetMethod())
+		}
+		if e.mode() != modeDetect {
+			res.Headers().Set("Allow", strings.Join(e.cfg.allowedMethods, ", "))
+			res.SetStatusCode(http.StatusMethodNotAllowed)
+			return false, 0
+		}
+	}
+
+	if e.cfg.requestLimits.enabled() && !bypassed {
+		if status := e.cfg.requestLimits.exceededRequestLimit(req); status != 0 {
+			metrics.oversizedRequests.Add(1)
+			if e.host.LogEnabled(api.LogLevelInfo) {
+				e.host.Log(api.LogLevelInfo, "Request from "+client+" exceeds the request line or header size limits")
+			}
+			if e.mode() != modeDetect {
+				res.SetStatusCode(status)
+				return false, 0
+			}
+		}
+	}
+
+	if e.cfg.protocolChecks && !bypassed {
+		if reason := requestSmugglingReason(req); reason != "" {
+			metrics.protocolViolations.Add(1)
+			if e.host.LogEnabled(api.LogLevelInfo) {
+				e.host.Log(api.LogLevelInfo, "Request from "+client+" to "+req.GetURI()+" fails the protocol checks: "+reason)
+			}
+			if e.mode() != modeDetect {
+				serveProtocolViolation(res, reason)
+				return false, 0
+			}
+		}
+	}
+
+	if e.limiter != nil && !bypassed {
+		if retryAfter, ok := e.rateLimit(req, client, now); !ok {
+			metrics.rateLimited.Add(1)
+			if e.host.LogEnabled(api.LogLevelInfo) {
+				e.host.Log(api.LogLevelInfo, "Request from "+client+" to "+req.GetURI()+" exceeds the rate limit")
+			}
+			if e.mode() != modeDetect {
+				serveRateLimited(res, retryAfter)
+				return false, 0
+			}
+		}
+	}
+
+	var conn tlsInfo
+	if e.cfg.tlsPolicy.enabled {
+		conn = e.cfg.tlsPolicy.connectionTLS(req.Headers())
+		if status, reason := e.cfg.tlsPolicy.tlsViolation(conn, req.Headers()); status != 0 && !bypassed {
+			metrics.tlsViolations.Add(1)
+			if e.host.LogEnabled(api.LogLevelInfo) {
+				e.host.Log(api.LogLevelInfo, "Request from "+client+" violates the TLS policy: "+reason)
+			}
+			if e.mode() != modeDetect {
+				res.SetStatusCode(status)
+				return false, 0
+			}
+		}
+	}
+
+	botScore := -1
+	if e.cfg.botDetection.enabled && !bypassed {
+		if botScore = scoreBot(req.Headers()); botScore >= e.cfg.botDetection.threshold {
+			metrics.bots.Add(1)
+			if e.mode() != modeDetect && e.serveBotAction(req, res, client, now) {
+				return false, 0
+			}
+		}
+	}
+
+	metrics.requests.Add(1)
+	waf, canary := e.waf, false
+	switch {
+	case bypassed:
+		// The request is still inspected so that it gets logged, but never
+		// interrupted.
+		waf = e.detect
+	case e.killSwitchEngaged():
+		// Blocking is disabled, the canary included, which keeps running
+		// its own ruleset.
+		waf = e.detect
+		if e.inCanary(client) {
+			canary = true
+			metrics.canaryRequests.Add(1)
+			waf = e.canaryDetect
+		}
+	case e.inCanary(client):
+		canary = true
+		metrics.canaryRequests.Add(1)
+		waf = e.canary
+	}
+	tx := newTransaction(waf)
+
+	// Early return, Coraza is not going to process any rule
+	if tx.IsRuleEngineOff() {
+		tx.ProcessLogging()
+		if err := tx.Close(); err != nil {
+			tx.DebugLogger().Error().Err(err).Msg("Failed to close the transaction")
+		}
+		return true, 0
+	}
+
+	defer func() {
+		if !next && e.shadow != nil {
+			// The request is not going further, the shadow evaluates it
+			// before the verdict headers are added.
+			e.shadowRequest(tx, req, client, cport, false)
+		}
+
+		if e.cfg.verdictHeaders {
+			setVerdictHeaders(tx, req.Headers())
+		}
+
+		if tx.IsInterrupted() {
+			metrics.requestInterruptions.Add(1)
+			if canary {
+				metrics.canaryRequestInterruptions.Add(1)
+			}
+		}
+
+		// The request is not going further, whether interrupted, rejected
+		// by a check or failing to be read, hence the transaction ends here.
+		// Otherwise it is finished below or by HandleResponse.
+		if !next {
+			// We run phase 5 rules and create audit logs (if enabled)
+			start := dump.start()
+			tx.ProcessLogging()
+			dump.done("logging", start)
+			e.dumpTransaction(tx, dump)
+			// we remove temporary files and free some memory
+			if err := tx.Close(); err != nil {
+				tx.DebugLogger().Error().Err(err).Msg("Failed to close the transaction")
+			}
+		}
+	}()
+
+	var it *types.Interruption
+	// There is no socket access in the request object, so we neither know the server client nor port.
+	if botScore >= 0 {
+		setBotScore(tx, botScore)
+	}
+	if e.cfg.tlsPolicy.enabled {
+		setTLSInfo(tx, conn)
+	}
+	tx.ProcessConnection(client, cport, "", 0)
+	target := parseRequestTarget(req.GetMethod(), req.GetURI())
+	tx.ProcessURI(target.uri, req.GetMethod(), req.GetProtocolVersion())
+	addRequestHeaders(tx, req.Headers(), target, conn.sni)
+
+	start := dump.start()
+	it = tx.ProcessRequestHeaders()
+	dump.done("request headers", start)
+	if it != nil {
+		handleInterruption(it, res)
+		return
+	}
+
+	body := bodyReader(req.Body())
+	if e.cfg.signedRequests.enabled && !bypassed && matchPaths(req.GetURI(), e.cfg.signedRequests.paths) {
+		content, complete, reason := e.verifySignedRequest(req, body, now)
+		if content != nil {
+			// The body read for the verification is still to be inspected.
+			body = &prefixedBody{prefix: content, body: body, eof: complete}
+		}
+		if reason != "" {
+			metrics.signatureFailures.Add(1)
+			if e.host.LogEnabled(api.LogLevelInfo) {
+				e.host.Log(api.LogLevelInfo, "Request from "+client+" to "+req.GetURI()+" fails the signature verification: "+reason)
+			}
+			if e.mode() != modeDetect {
+				res.SetStatusCode(http.StatusUnauthorized)
+				return
+			}
+		}
+	}
+
+	start = dump.start()
+	if tx.IsRequestBodyAccessible() && (e.inspectRequestBody() || e.mayExceedBodyLimit(req.Headers())) {
+		// We only do body buffering if the transaction requires request
+		// body inspection and there are rules looking at it or a body limit
+		// to enforce, otherwise we just let the request follow its regular
+		// flow.
+		var slow *slowBody
+		if e.cfg.slowRequests.enabled && !bypassed {
+			slow = &slowBody{
+				body:  body,
+				cfg:   e.cfg.slowRequests,
+				start: now,
+				now:   timeSource.Now,
+				stop:  e.cfg.slowRequests.action == slowRequestActionBlock && e.mode() != modeDetect,
+			}
+			body = slow
+		}
+
+		it, err := copyBody(tx.WriteRequestBody, body)
+		if err != nil {
+			tx.DebugLogger().Error().Err(err).Msg("Failed to read request body")
+			return
+		}
+
+		if slow != nil && slow.slow {
+			metrics.slowRequests.Add(1)
+			if e.host.LogEnabled(api.LogLevelInfo) {
+				e.host.Log(api.LogLevelInfo, "Request from "+client+" to "+req.GetURI()+" has a body below the minimum rate")
+			}
+			if slow.stop {
+				serveSlowRequest(res)
+				return
+			}
+		}
+
+		if it != nil {
+			handleInterruption(it, res)
+			return
+		}
+		e.addTrailers(tx.AddRequestHeader, req.Trailers)
+	}
+
+	var err error
+	it, err = tx.ProcessRequestBody()
+	dump.done("request body", start)
+	if err != nil {
+		tx.DebugLogger().Error().Err(err).Msg("Failed to process request body")
+		return
+	}
+
+	if it != nil {
+		handleInterruption(it, res)
+		return
+	}
+
+	if e.cfg.csrf.enabled && !bypassed && !e.checkCSRF(tx, req) && e.handleCSRFFailure(tx, res) {
+		return
+	}
+
+	if e.cfg.bruteForce.enabled && !bypassed && !e.checkBruteForce(tx, req, res, client, now) {
+		return
+	}
+
+	var shadow types.Transaction
+	if e.shadow != nil {
+		shadow = e.shadowRequest(tx, req, client, cport, e.ruleset.responsePhases)
+	}
+
+	if e.ruleset.responsePhases {
+		if reqCtx, ok := txs.put(inflight{tx: tx, shadow: shadow, canary: canary, bypassed: bypassed, dump: dump, started: now}); ok {
+			return true, reqCtx
+		}
+		tx.DebugLogger().Warn().Msg("Too many in-flight transactions, skipping response processing")
+		if shadow != nil {
+			finishShadow(e.host, tx, shadow)
+		}
+	}
+
+	// Nothing left to do on the response, hence we finish the transaction
+	// here rather than keeping it until HandleResponse.
+	start = dump.start()
+	tx.ProcessLogging()
+	dump.done("logging", start)
+	e.dumpTransaction(tx, dump)
+	if err := tx.Close(); err != nil {
+		tx.DebugLogger().Error().Err(err).Msg("Failed to close the transaction")
+	}
+	return true, 0
+}
+
+func handleInterruption(in *types.Interruption, res api.Response) {
+	statusCode := obtainStatusCodeFromInterruptionOrDefault(in, 403)
+	res.SetStatusCode(statusCode)
+}
+
+// addRequestHeaders adds the request headers to the transaction and sets the
+// server name, see serverName.
+func addRequestHeaders(tx types.Transaction, headers api.Header, target requestTarget, sni string) {
+	hasHost := false
+	for _, k := range headers.Names() {
+		if hs := headers.GetAll(k); len(hs) > 0 {
+			tx.AddRequestHeader(k, strings.Join(hs, "; "))
+			hasHost = hasHost || strings.EqualFold(k, "Host")
+		}
+	}
+	// Some hosts, e.g. net/http based ones, promote Host out of the header
+	// names while still returning it, so we manually add it.
+	if host, ok := headers.Get("Host"); ok && !hasHost {
+		tx.AddRequestHeader("Host", host)
+	}
+	tx.SetServerName(serverName(headers, target, sni))
+}
+
+// interimResponse tells whether the status is the one of an interim response,
+// e.g. 103 Early Hints, which hosts surfacing them hand over before the final
+// response. 101 Switching Protocols is the final response of the request.
+func interimResponse(statusCode uint32) bool {
+	return statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols
+}
+
+// obtainStatusCodeFromInterruptionOrDefault returns the desired status code derived from the interruption
+// on a "deny" action or a default value.
+func obtainStatusCodeFromInterruptionOrDefault(it *types.Interruption, defaultStatusCode uint32) uint32 {
+	if it.Action == "deny" {
+		statusCode := it.Status
+		if statusCode == 0 {
+			statusCode = 403
+		}
+
+		return uint32(statusCode)
+	}
+
+	return defaultStatusCode
+}
+
+// inspectHostError runs the response headers rules on the error response
+// produced by the host, e.g. when the upstream is unreachable. The response
+// is the host's, hence interruptions are only reported.
+func (e *engine) inspectHostError(tx types.Transaction, req api.Request, resp api.Response, dump *txDump) {
+	if tx.IsInterrupted() {
+		return
+	}
+
+	for _, h := range resp.Headers().Names() {
+		tx.AddResponseHeader(h, strings.Join(resp.Headers().GetAll(h), ";"))
+	}
+
+	statusCode := resp.GetStatusCode()
+	if statusCode == 0 {
+		// Hosts may leave the status to be set once the guest returns.
+		statusCode = http.StatusInternalServerError
+	}
+	start := dump.start()
+	it := tx.ProcessResponseHeaders(int(statusCode), req.GetProtocolVersion())
+	dump.done("response headers", start)
+	if it != nil {
+		tx.DebugLogger().Warn().Int("rule_id", it.RuleID).Msg("Response interruption can't be enforced on a host error response")
+	}
+}
+
+// HandleResponse implements api.HandleResponse, running the response phases
+// for the transactions kept by HandleRequest.
+func HandleResponse(reqCtx uint32, req api.Request, resp api.Response, isError bool) {
+	if reqCtx == 0 {
+		return
+	}
+
+	if !isError && interimResponse(resp.GetStatusCode()) {
+		// The transaction is left in the store for the final response.
+		return
+	}
+
+	in, ok := txs.take(reqCtx)
+	if !ok {
+		// The transaction was closed, e.g. as aborted by reapInflight, and
+		// the response can't be inspected anymore.
+		metrics.lateResponses.Add(1)
+		activeEngine.Load().host.Log(api.LogLevelWarn, "Response "+strconv.Itoa(int(resp.GetStatusCode()))+" for "+req.GetURI()+
+			" received once its transaction was closed, passing it without inspection")
+		return
+	}
+	tx := in.tx
+	e := activeEngine.Load()
+
+	defer func() {
+		if in.shadow != nil {
+			if !isError {
+				shadowResponseBody(tx, in.shadow)
+			}
+			finishShadow(e.host, tx, in.shadow)
+		}
+
+		if tx.IsInterrupted() {
+			metrics.responseInterruptions.Add(1)
+			if in.canary {
+				metrics.canaryResponseInterruptions.Add(1)
+			}
+		}
+		// We run phase 5 rules and create audit logs (if enabled)
+		start := in.dump.start()
+		tx.ProcessLogging()
+		in.dump.done("logging", start)
+		e.dumpTransaction(tx, in.dump)
+		// we remove temporary files and free some memory
+		if err := tx.Close(); err != nil {
+			tx.DebugLogger().Error().Err(err).Msg("Failed to close the transaction")
+		}
+	}()
+
+	if isError {
+		metrics.hostErrors.Add(1)
+		if e.cfg.inspectHostErrors {
+			e.inspectHostError(tx, req, resp, in.dump)
+		}
+		return
+	}
+
+	if e.cfg.scrub.enabled && e.features.IsEnabled(api.FeatureBufferResponse) {
+		defer e.scrubResponse(tx, req, resp, resp.GetStatusCode())
+	}
+
+	if in.shadow != nil {
+		// The shadow sees the response headers before the primary
+		// transaction changes them.
+		shadowResponseHeaders(in.shadow, req, resp)
+	}
+
+	if e.cfg.bruteForce.enabled {
+		e.recordAuthFailure(tx, req, resp, timeSource.Now())
+	}
+
+	if e.cfg.csrf.enabled && e.features.IsEnabled(api.FeatureBufferResponse) {
+		e.issueCSRFToken(req, resp)
+	}
+
+	// We look for interruptions triggered at phase 3 (response headers)
+	// and during writing the response body. If so, response status code
+	// has been sent over the flush already.
+	if tx.IsInterrupted() {
+		return
+	}
+
+	for _, h := range resp.Headers().Names() {
+		tx.AddResponseHeader(h, strings.Join(resp.Headers().GetAll(h), ";"))
+	}
+
+	statusCode := resp.GetStatusCode()
+	start := in.dump.start()
+	it := tx.ProcessResponseHeaders(int(statusCode), req.GetProtocolVersion())
+	in.dump.done("response headers", start)
+	if !e.features.IsEnabled(api.FeatureBufferResponse) {
+		// Header-only mode: the response has already been sent, we can only
+		// report it.
+		if it != nil {
+			tx.DebugLogger().Warn().Msg("Response interruption can't be enforced in header-only mode")
+		}
+		return
+	}
+
+	if it != nil {
+		handleInterruption(it, resp)
+		// The host holds the upstream body until we return, it is replaced
+		// so that it never reaches the client.
+		if responseHasBody(req.GetMethod(), statusCode) {
+			e.truncateResponseBody(resp)
+		}
+		return
+	}
+
+	if !responseHasBody(req.GetMethod(), statusCode) {
+		// There is no body to buffer nor to run phase 4 on, nor to clear
+		// when interrupting.
+		return
+	}
+
+	start = in.dump.start()
+	defer in.dump.done("response body", start)
+
+	body := bodyReader(resp.Body())
+	var scanned []byte
+	if e.cfg.dataLeak.detectors != nil && dataLeakScannable(resp) {
+		// The body is read upfront to be scanned once the transaction is
+		// done with it.
+		prefix, complete := readBodyPrefix(resp.Body(), e.cfg.dataLeak.maxBodySize)
+		if complete && len(prefix) <= e.cfg.dataLeak.maxBodySize {
+			scanned = prefix
+		} else {
+			tx.DebugLogger().Debug().Msg("Response body too large to be scanned for data leaks")
+		}
+		body = &prefixedBody{prefix: prefix, body: resp.Body(), eof: complete}
+	}
+
+	it, err := copyBody(tx.WriteResponseBody, body)
+	if err != nil {
+		tx.DebugLogger().Error().Err(err).Msg("Failed to read response body")
+		resp.SetStatusCode(http.StatusInternalServerError)
+		e.truncateResponseBody(resp)
+		return
+	}
+	if it != nil {
+		handleInterruption(it, resp)
+		e.truncateResponseBody(resp)
+		return
+	}
+
+	e.addTrailers(tx.AddResponseHeader, resp.Trailers)
+
+	if tx.IsResponseBodyAccessible() && tx.IsResponseBodyProcessable() {
+		if it, err := tx.ProcessResponseBody(); err != nil {
+			resp.SetStatusCode(http.StatusInternalServerError)
+			tx.DebugLogger().Error().Err(err).Msg("Failed to process response body")
+			e.truncateResponseBody(resp)
+			return
+		} else if it != nil {
+			resp.SetStatusCode(obtainStatusCodeFromInterruptionOrDefault(it, statusCode))
+			e.truncateResponseBody(resp)
+			return
+		}
+	}
+
+	// The body of a scrubbed error page never reaches the client, there is
+	// nothing to mask.
+	if scanned != nil && !e.scrubsErrorPage(tx, req, statusCode) {
+		e.handleDataLeaks(tx, resp, scanned, in.bypassed)
+	}
+}
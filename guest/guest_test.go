@@ -0,0 +1,714 @@
+package guest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAPIHost struct {
+	api.Host
+	t         *testing.T
+	getConfig func() []byte
+	// features are the features the host supports.
+	features api.Features
+}
+
+func (h mockAPIHost) GetConfig() []byte {
+	return h.getConfig()
+}
+
+func (h mockAPIHost) EnableFeatures(features api.Features) api.Features {
+	return features & h.features
+}
+
+func (h mockAPIHost) LogEnabled(api.LogLevel) bool {
+	return h.t != nil
+}
+
+func (h mockAPIHost) Log(_ api.LogLevel, msg string) {
+	if h.t != nil {
+		h.t.Log(msg)
+	}
+}
+
+// mockHeader implements api.Header on top of http.Header.
+type mockHeader http.Header
+
+func (h mockHeader) Names() []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (h mockHeader) Get(name string) (string, bool) {
+	values := http.Header(h).Values(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+func (h mockHeader) GetAll(name string) []string {
+	return http.Header(h).Values(name)
+}
+
+func (h mockHeader) Set(name, value string) {
+	http.Header(h).Set(name, value)
+}
+
+func (h mockHeader) Add(name, value string) {
+	http.Header(h).Add(name, value)
+}
+
+func (h mockHeader) Remove(name string) {
+	http.Header(h).Del(name)
+}
+
+type mockRequest struct {
+	api.Request
+	method     string
+	uri        string
+	proto      string
+	sourceAddr string
+	headers    mockHeader
+	trailers   mockHeader
+	body       *mockBody
+}
+
+func newMockRequest(method, uri, body string) *mockRequest {
+	return &mockRequest{
+		method:     method,
+		uri:        uri,
+		proto:      "HTTP/1.1",
+		sourceAddr: "127.0.0.1:54321",
+		headers:    mockHeader{"Host": {"localhost"}},
+		trailers:   mockHeader{},
+		body:       &mockBody{r: bytes.NewReader([]byte(body))},
+	}
+}
+
+func (r *mockRequest) GetMethod() string          { return r.method }
+func (r *mockRequest) GetURI() string             { return r.uri }
+func (r *mockRequest) GetProtocolVersion() string { return r.proto }
+func (r *mockRequest) GetSourceAddr() string      { return r.sourceAddr }
+func (r *mockRequest) Headers() api.Header        { return r.headers }
+func (r *mockRequest) Body() api.Body             { return r.body }
+func (r *mockRequest) Trailers() api.Header       { return r.trailers }
+
+type mockResponse struct {
+	api.Response
+	statusCode uint32
+	headers    mockHeader
+	trailers   mockHeader
+	body       *mockBody
+}
+
+func newMockResponse(statusCode uint32, body string) *mockResponse {
+	return &mockResponse{
+		statusCode: statusCode,
+		headers:    mockHeader{"Content-Type": {"text/plain"}},
+		trailers:   mockHeader{},
+		body:       &mockBody{r: bytes.NewReader([]byte(body))},
+	}
+}
+
+func (r *mockResponse) GetStatusCode() uint32           { return r.statusCode }
+func (r *mockResponse) SetStatusCode(statusCode uint32) { r.statusCode = statusCode }
+func (r *mockResponse) Headers() api.Header             { return r.headers }
+func (r *mockResponse) Body() api.Body                  { return r.body }
+func (r *mockResponse) Trailers() api.Header            { return r.trailers }
+
+// allFeatures are the features supported by a fully featured host such as
+// the wazero based reference host.
+const allFeatures = api.FeatureBufferRequest | api.FeatureBufferResponse | api.FeatureTrailers
+
+// useEngine initializes the WAF with the given host config and makes it the
+// active engine for the duration of the test. The host supports all the
+// features unless others are given.
+func useEngine(t *testing.T, cfg string, features ...api.Features) {
+	t.Helper()
+
+	host := mockAPIHost{t: t, features: allFeatures, getConfig: func() []byte {
+		return []byte(cfg)
+	}}
+	if len(features) > 0 {
+		host.features = features[0]
+	}
+
+	e, err := initializeWAF(host, overrides{})
+	require.NoError(t, err)
+	e.features = negotiateFeatures(host, e.ruleset)
+
+	previous := activeEngine.Swap(e)
+	t.Cleanup(func() { activeEngine.Store(previous) })
+}
+
+// serve runs the request through the handlers the way a host does.
+func serve(req *mockRequest, res *mockResponse) {
+	next, reqCtx := HandleRequest(req, res)
+	if next {
+		HandleResponse(reqCtx, req, res, false)
+	}
+}
+
+// TestConcurrentRequests drives parallel requests through the handlers, as
+// native hosts may do, to be run with the race detector. Wasm instances are
+// single threaded, see activeEngine.
+func TestConcurrentRequests(t *testing.T) {
+	useEngine(t, `
+	{
+		"directives": [
+			"SecRuleEngine On",
+			"SecRequestBodyAccess On",
+			"SecResponseBodyAccess On",
+			"SecResponseBodyMimeType text/plain",
+			"SecRule ARGS \"@contains attack\" \"id:1,phase:2,deny,status:403\"",
+			"SecRule RESPONSE_BODY \"@contains secret\" \"id:2,phase:4,deny,status:403\""
+		]
+	}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var (
+				req            = newMockRequest("POST", "/", "name=coraza")
+				res            = newMockResponse(200, "hello")
+				expectedStatus = uint32(200)
+			)
+			switch i % 3 {
+			case 1:
+				req.body = &mockBody{r: bytes.NewReader([]byte("name=attack"))}
+				expectedStatus = 403
+			case 2:
+				res.body = &mockBody{r: bytes.NewReader([]byte("secret"))}
+				expectedStatus = 403
+			}
+			req.headers.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			serve(req, res)
+			assert.Equal(t, expectedStatus, res.statusCode, "request %d", i)
+		}(i)
+	}
+	wg.Wait()
+
+	require.Zero(t, txs.len(), "transactions leaked")
+}
+
+func TestInitializeWAF(t *testing.T) {
+	_, err := initializeWAF(mockAPIHost{t: t, getConfig: func() []byte {
+		return []byte(`
+		{
+			"directives": [
+				"SecRuleEngine On",
+				"SecDebugLog /dev/stdout",
+				"SecDebugLogLevel 9",
+				"SecRule REQUEST_URI \"@rx .\" \"phase:1,deny,status:403,id:'1234'\""
+			]
+		}`)
+	}}, overrides{})
+	require.NoError(t, err)
+}
+
+// BenchmarkHandlers measures the connector overhead per request, without
+// the wasm runtime. Transactions are taken from the coraza transaction pool
+// and returned to it when closed, hence allocations here are mostly the ones
+// made while evaluating the rules.
+func BenchmarkHandlers(b *testing.B) {
+	e, err := initializeWAF(mockAPIHost{getConfig: func() []byte {
+		return []byte(`
+		{
+			"directives": [
+				"SecRuleEngine On",
+				"SecRequestBodyAccess On",
+				"SecResponseBodyAccess On",
+				"SecResponseBodyMimeType text/plain",
+				"SecRule ARGS \"@contains attack\" \"id:1,phase:2,deny,status:403\"",
+				"SecRule RESPONSE_BODY \"@contains secret\" \"id:2,phase:4,deny,status:403\""
+			]
+		}`)
+	}}, overrides{})
+	require.NoError(b, err)
+	e.features = allFeatures
+	activeEngine.Store(e)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := newMockRequest("POST", "/anything?id=1", "name=coraza")
+		req.headers.Set("Content-Type", "application/x-www-form-urlencoded")
+		serve(req, newMockResponse(200, "hello"))
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	useEngine(t, `{"directives": ["SecRuleEngine On"], "allowedMethods": ["GET", "HEAD", "POST"]}`)
+
+	for method, expectedStatus := range map[string]uint32{
+		"GET":   200,
+		"POST":  200,
+		"TRACE": 405,
+		"TRACK": 405,
+		"get":   405,
+	} {
+		t.Run(method, func(t *testing.T) {
+			res := newMockResponse(200, "")
+			serve(newMockRequest(method, "/", ""), res)
+			require.Equal(t, expectedStatus, res.statusCode)
+			if expectedStatus == 405 {
+				require.Equal(t, []string{"GET, HEAD, POST"}, res.headers.GetAll("Allow"))
+			}
+		})
+	}
+}
+
+// FuzzRequestHeaders runs requests with hostile headers, URIs and source
+// addresses through the checks made before the rules and the CRS.
+func FuzzRequestHeaders(f *testing.F) {
+	host := mockAPIHost{features: allFeatures, getConfig: func() []byte {
+		return []byte(`{
+			"directives": ["Include @coraza.conf-recommended", "Include @crs-setup.conf.example", "Include @owasp_crs/*.conf", "SecRuleEngine On"],
+			"requestLimits": {"maxHeaderSize": 4096},
+			"botDetection": {"action": "block"},
+			"tls": {"versionHeader": "X-Tls-Version", "sniHeader": "X-Tls-Sni", "minVersion": "1.2", "sniMatch": true},
+			"clientIPHeader": "X-Forwarded-For"
+		}`)
+	}}
+	e, err := initializeWAF(host, overrides{})
+	require.NoError(f, err)
+	e.features = negotiateFeatures(host, e.ruleset)
+	previous := activeEngine.Swap(e)
+	f.Cleanup(func() { activeEngine.Store(previous) })
+
+	f.Add("/", "127.0.0.1:54321", "User-Agent", "Mozilla/5.0")
+	f.Add("/?q=<script>", "[2001:db8::1]:8080", "X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+	f.Add("http://localhost/a", "@", "Host", "other")
+	f.Add("/", "10.0.0.1", "Transfer-Encoding", "chunked, identity")
+	f.Add("/", "", "Content-Length", "-1")
+	f.Add("*", "10.0.0.1:99999", "X-Tls-Sni", "\x00")
+	f.Fuzz(func(t *testing.T, uri, sourceAddr, name, value string) {
+		inFlight := txs.len()
+		req := newMockRequest("GET", uri, "")
+		req.sourceAddr = sourceAddr
+		req.headers.Add(name, value)
+		res := newMockResponse(200, "")
+		serve(req, res)
+		require.Equal(t, inFlight, txs.len(), "the transaction should be released")
+	})
+}
+
+func TestInspectHostErrors(t *testing.T) {
+	for _, inspect := range []bool{false, true} {
+		t.Run(map[bool]string{false: "skipped", true: "inspected"}[inspect], func(t *testing.T) {
+			useEngine(t, `{
+				"inspectHostErrors": `+strconv.FormatBool(inspect)+`,
+				"debugDump": {"secret": "0123456789abcdef"},
+				"directives": [
+					"SecRuleEngine On",
+					"SecRule RESPONSE_STATUS \"@streq 502\" \"id:1,phase:3,deny,status:403,msg:'Upstream error'\""
+				]
+			}`)
+			var logs bytes.Buffer
+			e := activeEngine.Load()
+			e.host = recordingHost{mockAPIHost: e.host.(mockAPIHost), logs: &logs}
+
+			req, res := newMockRequest("GET", "/", ""), newMockResponse(502, "Bad Gateway")
+			req.headers.Set("X-Coraza-Debug", "0123456789abcdef")
+			hostErrors := metrics.hostErrors.Load()
+			next, reqCtx := HandleRequest(req, res)
+			require.True(t, next)
+			HandleResponse(reqCtx, req, res, true)
+
+			require.Equal(t, uint64(1), metrics.hostErrors.Load()-hostErrors)
+			require.Equal(t, uint32(502), res.statusCode, "the host response should be left as is")
+			require.Nil(t, res.body.written)
+			if inspect {
+				require.Contains(t, logs.String(), "\nMatched rules:\n  1 \"Upstream error\"\n")
+			} else {
+				require.Contains(t, logs.String(), "\nMatched rules:\nVariables:")
+			}
+		})
+	}
+}
+
+// TestTransactionLogging checks the logging phase runs exactly once per
+// transaction, whichever way it ends, by counting the audit log entries.
+func TestTransactionLogging(t *testing.T) {
+	useAuditedEngine := func(t *testing.T, engine string, extra string) string {
+		auditLog := filepath.Join(t.TempDir(), "audit.log")
+		useEngine(t, `{`+extra+`"directives": [
+			"SecRuleEngine `+engine+`",
+			"SecAuditEngine On",
+			"SecAuditLogParts ABZ",
+			"SecAuditLogFormat JSON",
+			"SecAuditLog `+auditLog+`",
+			"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\"",
+			"SecRule RESPONSE_STATUS \"@streq 500\" \"id:2,phase:3,deny,status:403\""
+		]}`)
+		return auditLog
+	}
+	auditEntries := func(t *testing.T, auditLog string) int {
+		content, err := os.ReadFile(auditLog)
+		if os.IsNotExist(err) {
+			return 0
+		}
+		require.NoError(t, err)
+		return bytes.Count(content, []byte("\n"))
+	}
+
+	tests := map[string]struct {
+		engine string
+		extra  string
+		req    *mockRequest
+		// isError ends the response with a host error.
+		isError bool
+		// noTransaction is set when the request is answered before any
+		// transaction is started.
+		noTransaction bool
+		expectedNext  bool
+	}{
+		"engine off":           {engine: "Off", req: newMockRequest("GET", "/?id=0", ""), expectedNext: true},
+		"interrupted request":  {engine: "On", req: newMockRequest("GET", "/?id=0", "")},
+		"passed":               {engine: "On", req: newMockRequest("GET", "/", ""), expectedNext: true},
+		"host error":           {engine: "On", req: newMockRequest("GET", "/", ""), isError: true, expectedNext: true},
+		"detection only":       {engine: "DetectionOnly", req: newMockRequest("GET", "/?id=0", ""), expectedNext: true},
+		"rejected by a check":  {engine: "On", extra: `"csrf": {},`, req: newMockRequest("POST", "/", "")},
+		"maintenance response": {engine: "On", extra: `"maintenance": {"enabled": true},`, req: newMockRequest("GET", "/", ""), noTransaction: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			auditLog := useAuditedEngine(t, test.engine, test.extra)
+			res := newMockResponse(200, "")
+			next, reqCtx := HandleRequest(test.req, res)
+			require.Equal(t, test.expectedNext, next)
+			if reqCtx != 0 {
+				require.Zero(t, auditEntries(t, auditLog), "the transaction should be logged once the response is done")
+				HandleResponse(reqCtx, test.req, res, test.isError)
+			}
+
+			expected := 1
+			if test.noTransaction {
+				expected = 0
+			}
+			require.Equal(t, expected, auditEntries(t, auditLog))
+		})
+	}
+
+	t.Run("too many in-flight transactions", func(t *testing.T) {
+		auditLog := useAuditedEngine(t, "On", "")
+		previous := txs
+		txs = &txStore{}
+		t.Cleanup(func() { txs = previous })
+
+		next, reqCtx := HandleRequest(newMockRequest("GET", "/", ""), newMockResponse(200, ""))
+		require.True(t, next)
+		require.Zero(t, reqCtx)
+		require.Equal(t, 1, auditEntries(t, auditLog))
+	})
+}
+
+func TestBodilessResponses(t *testing.T) {
+	useEngine(t, `{"scrubResponse": {"errorPages": true}, "directives": [
+		"SecRuleEngine On",
+		"SecResponseBodyAccess On",
+		"SecResponseBodyMimeType text/plain",
+		"SecRule RESPONSE_BODY \"@contains secret\" \"id:1,phase:4,deny,status:403\""
+	]}`)
+
+	tests := map[string]struct {
+		method     string
+		statusCode uint32
+		// expectedStatusCode is the status of the response once handled.
+		expectedStatusCode uint32
+		expectedBodyRead   bool
+	}{
+		"GET":          {method: "GET", statusCode: 200, expectedStatusCode: 403, expectedBodyRead: true},
+		"HEAD":         {method: "HEAD", statusCode: 200, expectedStatusCode: 200},
+		"HEAD error":   {method: "HEAD", statusCode: 404, expectedStatusCode: 404},
+		"no content":   {method: "GET", statusCode: 204, expectedStatusCode: 204},
+		"not modified": {method: "GET", statusCode: 304, expectedStatusCode: 304},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// The bodies a host would never send are given to check they
+			// are not read.
+			res := newMockResponse(test.statusCode, "secret")
+			serve(newMockRequest(test.method, "/", ""), res)
+			require.Equal(t, test.expectedStatusCode, res.statusCode)
+			require.Equal(t, test.expectedBodyRead, res.body.reads > 0)
+			if !test.expectedBodyRead {
+				require.Nil(t, res.body.written, "no body should be written")
+				require.Empty(t, res.headers.GetAll("Content-Length"))
+			}
+		})
+	}
+}
+
+func TestInterimResponses(t *testing.T) {
+	clk := useDeterministicHandlers(t)
+	useEngine(t, `{"directives": [
+		"SecRuleEngine On",
+		"SecResponseBodyAccess On",
+		"SecResponseBodyMimeType text/plain",
+		"SecRule RESPONSE_STATUS \"@rx ^1\" \"id:1,phase:3,deny,status:403\"",
+		"SecRule RESPONSE_HEADERS:X-Leak \"@rx .\" \"id:2,phase:3,deny,status:403\"",
+		"SecRule RESPONSE_BODY \"@contains secret\" \"id:3,phase:4,deny,status:403\""
+	]}`)
+
+	tests := map[string]struct {
+		interimStatusCode uint32
+		final             *mockResponse
+		// expectedStatusCode is the status of the final response once
+		// handled.
+		expectedStatusCode uint32
+	}{
+		"early hints":        {interimStatusCode: 103, final: newMockResponse(200, "hello"), expectedStatusCode: 200},
+		"continue":           {interimStatusCode: 100, final: newMockResponse(200, "hello"), expectedStatusCode: 200},
+		"final body blocked": {interimStatusCode: 103, final: newMockResponse(200, "secret"), expectedStatusCode: 403},
+		"final header blocked": {interimStatusCode: 103, final: func() *mockResponse {
+			res := newMockResponse(200, "hello")
+			res.headers.Set("X-Leak", "1")
+			return res
+		}(), expectedStatusCode: 403},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := newMockRequest("GET", "/", "")
+			next, reqCtx := HandleRequest(req, newMockResponse(200, ""))
+			require.True(t, next)
+
+			// The interim response is neither inspected nor changed.
+			interim := newMockResponse(test.interimStatusCode, "secret")
+			interim.headers.Set("Link", "</style.css>; rel=preload")
+			HandleResponse(reqCtx, req, interim, false)
+			require.Equal(t, test.interimStatusCode, interim.statusCode)
+			require.Zero(t, interim.body.reads)
+			require.Nil(t, interim.body.written)
+			require.Equal(t, 1, txs.len(), "the transaction should wait for the final response")
+
+			HandleResponse(reqCtx, req, test.final, false)
+			require.Equal(t, test.expectedStatusCode, test.final.statusCode)
+			require.Zero(t, txs.len())
+		})
+	}
+
+	t.Run("switching protocols", func(t *testing.T) {
+		res := newMockResponse(101, "")
+		serve(newMockRequest("GET", "/", ""), res)
+		require.Equal(t, uint32(403), res.statusCode, "101 is the final response")
+		require.Zero(t, txs.len())
+	})
+
+	t.Run("interim response only", func(t *testing.T) {
+		// The host may never invoke the handler for the final response,
+		// e.g. when the upstream goes away after a 100 Continue.
+		inflightNextReap.Store(0)
+		aborted := metrics.abortedTransactions.Load()
+		req := newMockRequest("GET", "/", "")
+		_, reqCtx := HandleRequest(req, newMockResponse(200, ""))
+		HandleResponse(reqCtx, req, newMockResponse(100, ""), false)
+		require.Equal(t, 1, txs.len())
+
+		clk.Advance(defaultInflightTimeout + time.Second)
+		serve(newMockRequest("GET", "/", ""), newMockResponse(200, ""))
+		require.Zero(t, txs.len(), "the transaction should be closed as aborted")
+		require.Equal(t, aborted+1, metrics.abortedTransactions.Load())
+	})
+
+	t.Run("interim response then host error", func(t *testing.T) {
+		req := newMockRequest("GET", "/", "")
+		_, reqCtx := HandleRequest(req, newMockResponse(200, ""))
+		HandleResponse(reqCtx, req, newMockResponse(100, ""), false)
+		HandleResponse(reqCtx, req, newMockResponse(502, ""), true)
+		require.Zero(t, txs.len())
+	})
+}
+
+func TestReplacedResponseFraming(t *testing.T) {
+	useEngine(t, `{
+		"scrubResponse": {"errorPages": true},
+		"dataLeak": {"detectors": ["creditCard"], "action": "mask"},
+		"directives": [
+			"SecRuleEngine On",
+			"SecResponseBodyAccess On",
+			"SecResponseBodyMimeType text/plain",
+			"SecRule RESPONSE_BODY \"@contains secret\" \"id:1,phase:4,deny,status:403\""
+		]
+	}`)
+
+	tests := map[string]struct {
+		statusCode uint32
+		body       string
+		headers    map[string]string
+		// expectedBody is the body written by the guest.
+		expectedBody string
+	}{
+		"interrupted":               {statusCode: 200, body: "secret", headers: map[string]string{"Transfer-Encoding": "chunked"}},
+		"interrupted with trailers": {statusCode: 200, body: "secret", headers: map[string]string{"Transfer-Encoding": "chunked", "Trailer": "X-Checksum"}},
+		"masked":                    {statusCode: 200, body: "card: 4111111111111111", headers: map[string]string{"Transfer-Encoding": "chunked"}, expectedBody: "card: ************1111"},
+		"error page":                {statusCode: 500, body: "Fatal error", headers: map[string]string{"Content-Length": "11"}, expectedBody: "500 Internal Server Error"},
+		"encoded error page":        {statusCode: 502, body: "\x1f\x8b", headers: map[string]string{"Content-Encoding": "gzip"}, expectedBody: "502 Bad Gateway"},
+		"error page leaking a card": {statusCode: 500, body: "card: 4111111111111111", expectedBody: "500 Internal Server Error"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := newMockResponse(test.statusCode, test.body)
+			for k, v := range test.headers {
+				res.headers.Set(k, v)
+			}
+			if _, ok := test.headers["Trailer"]; ok {
+				res.trailers.Set("X-Checksum", "secret")
+			}
+			serve(newMockRequest("GET", "/", ""), res)
+			require.Equal(t, test.expectedBody, string(res.body.written))
+			require.Equal(t, []string{strconv.Itoa(len(test.expectedBody))}, res.headers.GetAll("Content-Length"))
+			require.Empty(t, res.headers.GetAll("Transfer-Encoding"))
+			require.Empty(t, res.headers.GetAll("Content-Encoding"))
+			require.Empty(t, res.headers.GetAll("Trailer"))
+			require.Empty(t, res.trailers.Names())
+		})
+	}
+
+	t.Run("untruncatable body", func(t *testing.T) {
+		res := newMockResponse(200, "secret")
+		res.headers.Set("Transfer-Encoding", "chunked")
+		res.headers.Set("Trailer", "X-Checksum")
+		res.trailers.Set("X-Checksum", "secret")
+		next, reqCtx := HandleRequest(newMockRequest("GET", "/", ""), res)
+		require.True(t, next)
+		HandleResponse(reqCtx, newMockRequest("GET", "/", ""), untruncatableResponse{res}, false)
+
+		// The body written by the guest replaces the upstream one.
+		require.Equal(t, uint32(403), res.statusCode)
+		require.Equal(t, "403 Forbidden", string(res.body.written))
+		require.Equal(t, []string{"13"}, res.headers.GetAll("Content-Length"))
+		require.Empty(t, res.headers.GetAll("Transfer-Encoding"))
+		require.Empty(t, res.headers.GetAll("Trailer"))
+		require.Empty(t, res.trailers.Names())
+	})
+
+	t.Run("trailers not granted", func(t *testing.T) {
+		useEngine(t, `{"directives": [
+			"SecRuleEngine On",
+			"SecResponseBodyAccess On",
+			"SecResponseBodyMimeType text/plain",
+			"SecRule RESPONSE_BODY \"@contains secret\" \"id:1,phase:4,deny,status:403\""
+		]}`, api.FeatureBufferRequest|api.FeatureBufferResponse)
+
+		res := newMockResponse(200, "secret")
+		res.headers.Set("Transfer-Encoding", "chunked")
+		res.headers.Set("Trailer", "X-Checksum")
+		serve(newMockRequest("GET", "/", ""), res)
+		// The trailers are out of reach, they are no longer announced.
+		require.Equal(t, uint32(403), res.statusCode)
+		require.Equal(t, []string{"0"}, res.headers.GetAll("Content-Length"))
+		require.Empty(t, res.headers.GetAll("Trailer"))
+	})
+}
+
+func TestBlockedResponseBodies(t *testing.T) {
+	useEngine(t, `{
+		"dataLeak": {"detectors": ["creditCard"]},
+		"directives": [
+			"SecRuleEngine On",
+			"SecResponseBodyAccess On",
+			"SecResponseBodyMimeType text/plain",
+			"SecRule RESPONSE_HEADERS:X-Leak \"@rx .\" \"id:1,phase:3,deny,status:403\"",
+			"SecRule RESPONSE_BODY \"@contains secret\" \"id:2,phase:4,deny,status:403\""
+		]
+	}`)
+
+	tests := map[string]struct {
+		body    string
+		headers map[string]string
+	}{
+		"response headers": {body: "4111111111111111 secret", headers: map[string]string{"X-Leak": "1"}},
+		"response body":    {body: "secret " + strings.Repeat("a", 2*bodySegmentSize)},
+		"data leak":        {body: "4111111111111111"},
+	}
+	for name, test := range tests {
+		for _, truncatable := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s truncatable %t", name, truncatable), func(t *testing.T) {
+				req := newMockRequest("GET", "/", "")
+				res := newMockResponse(200, test.body)
+				for k, v := range test.headers {
+					res.headers.Set(k, v)
+				}
+				next, reqCtx := HandleRequest(req, res)
+				require.True(t, next)
+				if truncatable {
+					HandleResponse(reqCtx, req, res, false)
+				} else {
+					HandleResponse(reqCtx, req, untruncatableResponse{res}, false)
+				}
+
+				require.Equal(t, uint32(403), res.statusCode)
+				require.NotNil(t, res.body.written, "the upstream body should be replaced")
+				require.NotContains(t, string(res.body.written), "4111")
+				require.NotContains(t, string(res.body.written), "secret")
+				require.Equal(t, []string{strconv.Itoa(len(res.body.written))}, res.headers.GetAll("Content-Length"))
+			})
+		}
+	}
+
+	for name, fail := range map[string]failingTransaction{
+		"failed body read":       {writeErr: errors.New("write failed")},
+		"failed body processing": {processErr: errors.New("process failed")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := newMockRequest("GET", "/", "")
+			res := newMockResponse(200, "4111111111111111 clean")
+			next, reqCtx := HandleRequest(req, res)
+			require.True(t, next)
+			in, ok := txs.take(reqCtx)
+			require.True(t, ok)
+			fail.Transaction = in.tx
+			in.tx = fail
+			reqCtx, ok = txs.put(in)
+			require.True(t, ok)
+
+			HandleResponse(reqCtx, req, res, false)
+			require.Equal(t, uint32(500), res.statusCode)
+			require.NotNil(t, res.body.written, "the upstream body should be replaced")
+			require.NotContains(t, string(res.body.written), "4111")
+			require.Equal(t, []string{strconv.Itoa(len(res.body.written))}, res.headers.GetAll("Content-Length"))
+		})
+	}
+}
+
+// failingTransaction fails writing or processing the response body.
+type failingTransaction struct {
+	types.Transaction
+	writeErr, processErr error
+}
+
+func (tx failingTransaction) WriteResponseBody(b []byte) (*types.Interruption, int, error) {
+	if tx.writeErr != nil {
+		return nil, 0, tx.writeErr
+	}
+	return tx.Transaction.WriteResponseBody(b)
+}
+
+func (tx failingTransaction) ProcessResponseBody() (*types.Interruption, error) {
+	if tx.processErr != nil {
+		return nil, tx.processErr
+	}
+	return tx.Transaction.ProcessResponseBody()
+}
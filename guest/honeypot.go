@@ -0,0 +1,187 @@
+package guest
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// maxBans bounds the memory held by the ban list.
+const maxBans = 1 << 16
+
+// banPersistInterval is how often at most the bans are written to the file,
+// so that a scan hitting many decoys doesn't write it on every request.
+const banPersistInterval = 5 * time.Second
+
+// honeypotConfig configures the honeypot paths: decoys no legitimate client
+// requests, e.g. /wp-login.php on a site that isn't WordPress, which get the
+// clients requesting them banned for a while.
+type honeypotConfig struct {
+	enabled bool
+	// paths are the path prefixes of the decoys.
+	paths       []string
+	banDuration time.Duration
+	statusCode  uint32
+	// file persists the bans across restarts when set, it has to be in a
+	// directory the host mounts writable.
+	file string
+}
+
+// bans holds the client IPs banned until the given time, across engine
+// reloads. changed is set when bans were added since they were persisted.
+var bans = struct {
+	sync.Mutex
+	until   map[string]time.Time
+	changed bool
+}{until: map[string]time.Time{}}
+
+// bansNextPersist holds the time the bans can be persisted again, in Unix
+// nanoseconds.
+var bansNextPersist atomic.Int64
+
+// bansFileMu serializes the writes of the ban file, so that an older
+// snapshot never overwrites a newer one.
+var bansFileMu sync.Mutex
+
+// banned tells whether the client is banned at now.
+func banned(client string, now time.Time) bool {
+	bans.Lock()
+	defer bans.Unlock()
+	until, ok := bans.until[client]
+	if ok && !now.Before(until) {
+		delete(bans.until, client)
+		return false
+	}
+	return ok
+}
+
+// ban bans the client until now plus the ban duration.
+func (e *engine) ban(client string, now time.Time) {
+	bans.Lock()
+	defer bans.Unlock()
+	if len(bans.until) >= maxBans {
+		evictBans(now)
+	}
+	bans.until[client] = now.Add(e.cfg.honeypot.banDuration)
+	bans.changed = true
+}
+
+// persistBans writes the bans to the file when they changed, at most once
+// per banPersistInterval. The file is written outside of the bans lock, so
+// that requests checking the bans don't wait for it. Bans added since the
+// last write are lost if the module stops before the next one.
+func (e *engine) persistBans(now time.Time) {
+	next := bansNextPersist.Load()
+	if now.UnixNano() < next {
+		return
+	}
+
+	bansFileMu.Lock()
+	defer bansFileMu.Unlock()
+
+	bans.Lock()
+	if !bans.changed || !bansNextPersist.CompareAndSwap(next, now.Add(banPersistInterval).UnixNano()) {
+		bans.Unlock()
+		return
+	}
+	content := formatBans()
+	bans.changed = false
+	bans.Unlock()
+
+	if err := os.WriteFile(e.cfg.honeypot.file, []byte(content), 0o600); err != nil {
+		e.host.Log(api.LogLevelWarn, "Failed to persist the bans: "+err.Error())
+		bans.Lock()
+		bans.changed = true
+		bans.Unlock()
+	}
+}
+
+// evictBans removes the expired bans, and all of them if the list is still
+// full. It must be called with bans locked.
+func evictBans(now time.Time) {
+	for client, until := range bans.until {
+		if !now.Before(until) {
+			delete(bans.until, client)
+		}
+	}
+	if len(bans.until) >= maxBans {
+		clear(bans.until)
+	}
+}
+
+// formatBans formats the bans one per line, the client IP followed by the
+// Unix time the ban ends at. It must be called with bans locked.
+func formatBans() string {
+	var b strings.Builder
+	for client, until := range bans.until {
+		b.WriteString(client + " " + strconv.FormatInt(until.Unix(), 10) + "\n")
+	}
+	return b.String()
+}
+
+// loadBans adds the bans persisted in file to the ban list, a missing file
+// meaning there are none.
+func loadBans(file string, now time.Time) error {
+	content, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	bans.Lock()
+	defer bans.Unlock()
+	for _, line := range strings.Split(string(content), "\n") {
+		client, expiry, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		unix, err := strconv.ParseInt(expiry, 10, 64)
+		if err != nil {
+			continue
+		}
+		if until := time.Unix(unix, 0); now.Before(until) && until.After(bans.until[client]) && len(bans.until) < maxBans {
+			bans.until[client] = until
+		}
+	}
+	return nil
+}
+
+// checkHoneypot tells whether the request may go on, banning the client when
+// it requests a decoy and rejecting the requests of banned clients.
+func (e *engine) checkHoneypot(req api.Request, res api.Response, client string, now time.Time) bool {
+	if e.cfg.honeypot.file != "" {
+		defer e.persistBans(now)
+	}
+
+	switch {
+	case banned(client, now):
+		metrics.bannedRequests.Add(1)
+	case matchPaths(req.GetURI(), e.cfg.honeypot.paths):
+		metrics.honeypotHits.Add(1)
+		if client == "" {
+			// Banning the unknown IP would ban every client.
+			break
+		}
+		e.ban(client, now)
+		if e.host.LogEnabled(api.LogLevelInfo) {
+			e.host.Log(api.LogLevelInfo, "Client "+client+" requested the honeypot "+req.GetURI()+", banned for "+e.cfg.honeypot.banDuration.String())
+		}
+	default:
+		return true
+	}
+
+	if e.mode() == modeDetect {
+		return true
+	}
+	res.SetStatusCode(e.cfg.honeypot.statusCode)
+	return false
+}
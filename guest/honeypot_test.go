@@ -0,0 +1,103 @@
+package guest
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoneypot(t *testing.T) {
+	t.Cleanup(func() { clear(bans.until) })
+
+	get := func(client, uri string) uint32 {
+		req := newMockRequest("GET", uri, "")
+		req.sourceAddr = client + ":12345"
+		res := newMockResponse(200, "")
+		serve(req, res)
+		return res.statusCode
+	}
+
+	t.Run("ban", func(t *testing.T) {
+		clear(bans.until)
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "honeypot": {"paths": ["/wp-login.php", "/.env"]}}`)
+
+		hits, banned := metrics.honeypotHits.Load(), metrics.bannedRequests.Load()
+		require.Equal(t, uint32(200), get("10.0.0.1", "/"))
+		require.Equal(t, uint32(403), get("10.0.0.1", "/wp-login.php"))
+		require.Equal(t, uint32(403), get("10.0.0.1", "/"), "the client should be banned")
+		require.Equal(t, uint32(200), get("10.0.0.2", "/"), "other clients should not be banned")
+		require.Equal(t, uint64(1), metrics.honeypotHits.Load()-hits)
+		require.Equal(t, uint64(1), metrics.bannedRequests.Load()-banned)
+	})
+
+	t.Run("unknown client IP", func(t *testing.T) {
+		clear(bans.until)
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "honeypot": {"paths": ["/.env"]}}`)
+
+		// Banning the unknown IP would ban all the clients without a
+		// usable source address.
+		require.Equal(t, uint32(403), get("", "/.env"))
+		require.Equal(t, uint32(200), get("", "/"))
+		require.Equal(t, uint32(200), get("10.0.0.2", "/"))
+		require.Empty(t, bans.until)
+	})
+
+	t.Run("ban expiry", func(t *testing.T) {
+		clear(bans.until)
+		now := time.Now()
+		bans.until["10.0.0.1"] = now.Add(time.Second)
+		require.True(t, banned("10.0.0.1", now))
+		require.False(t, banned("10.0.0.1", now.Add(time.Second)))
+		require.Empty(t, bans.until)
+	})
+
+	t.Run("persistence", func(t *testing.T) {
+		clear(bans.until)
+		bansNextPersist.Store(0)
+		clk := useDeterministicHandlers(t)
+		file := filepath.Join(t.TempDir(), "bans")
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "honeypot": {"paths": ["/.env"], "banDuration": 60, "file": `+strconv.Quote(file)+`}}`)
+		persisted := func() string {
+			content, err := os.ReadFile(file)
+			require.NoError(t, err)
+			return string(content)
+		}
+
+		require.Equal(t, uint32(403), get("10.0.0.1", "/.env"))
+		require.Contains(t, persisted(), "10.0.0.1 ")
+
+		// The file is written at most once per interval.
+		require.Equal(t, uint32(403), get("10.0.0.2", "/.env"))
+		require.NotContains(t, persisted(), "10.0.0.2 ")
+		clk.Advance(banPersistInterval)
+		require.Equal(t, uint32(200), get("10.0.0.3", "/"))
+		require.Contains(t, persisted(), "10.0.0.2 ")
+
+		// Nor when the bans didn't change.
+		require.NoError(t, os.Remove(file))
+		clk.Advance(banPersistInterval)
+		require.Equal(t, uint32(200), get("10.0.0.3", "/"))
+		require.NoFileExists(t, file)
+		require.Equal(t, uint32(403), get("10.0.0.4", "/.env"))
+		require.Contains(t, persisted(), "10.0.0.4 ")
+
+		// Bans are restored on restart.
+		clear(bans.until)
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "honeypot": {"paths": ["/.env"], "file": `+strconv.Quote(file)+`}}`)
+		require.Equal(t, uint32(403), get("10.0.0.1", "/"))
+	})
+
+	t.Run("load expired", func(t *testing.T) {
+		clear(bans.until)
+		file := filepath.Join(t.TempDir(), "bans")
+		now := time.Now()
+		require.NoError(t, os.WriteFile(file, []byte("10.0.0.1 "+strconv.FormatInt(now.Add(-time.Second).Unix(), 10)+"\nmalformed\n"), 0o600))
+		require.NoError(t, loadBans(file, now))
+		require.Empty(t, bans.until)
+		require.NoError(t, loadBans(filepath.Join(t.TempDir(), "missing"), now))
+	})
+}
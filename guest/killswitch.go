@@ -0,0 +1,90 @@
+package guest
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+	"github.com/tidwall/gjson"
+)
+
+// defaultKillSwitchInterval is how often the kill switch is polled unless
+// configured otherwise.
+const defaultKillSwitchInterval = 10 * time.Second
+
+// killSwitchConfig configures the kill switch, which disables blocking
+// (detection stays on) across a fleet without redeploying, e.g. to
+// neutralize a bad rule push. It is engaged when either:
+//   - file, on the host filesystem mounted into the module, contains "on",
+//     "true" or "1".
+//   - the host config has killSwitch.engaged set to true, for hosts able to
+//     update the config of a running module.
+type killSwitchConfig struct {
+	file     string
+	interval time.Duration
+	enabled  bool
+}
+
+// killSwitchNextPoll holds the time of the next poll, in Unix nanoseconds.
+var killSwitchNextPoll atomic.Int64
+
+// killSwitchState is set while the kill switch is engaged. It is a flag
+// rather than an override so that flipping it doesn't rebuild the WAF: the
+// engine keeps a detection only WAF at hand instead, see engine.detect.
+var killSwitchState atomic.Bool
+
+// pollKillSwitch checks the kill switch once per interval and flips
+// killSwitchState accordingly. Requests keep being served while polling,
+// only the one winning the poll pays for reading the switch.
+func (e *engine) pollKillSwitch(now time.Time) {
+	if !e.cfg.killSwitch.enabled {
+		return
+	}
+
+	next := killSwitchNextPoll.Load()
+	if now.UnixNano() < next || !killSwitchNextPoll.CompareAndSwap(next, now.Add(e.cfg.killSwitch.interval).UnixNano()) {
+		return
+	}
+
+	engaged := e.readKillSwitch()
+	if killSwitchState.Swap(engaged) == engaged {
+		return
+	}
+
+	if engaged {
+		e.host.Log(api.LogLevelWarn, "Kill switch engaged, blocking is disabled")
+	} else {
+		e.host.Log(api.LogLevelWarn, "Kill switch released, blocking is enabled")
+	}
+}
+
+// killSwitchEngaged returns whether the kill switch is configured and
+// engaged.
+func (e *engine) killSwitchEngaged() bool {
+	return e.cfg.killSwitch.enabled && killSwitchState.Load()
+}
+
+func (e *engine) readKillSwitch() bool {
+	if gjson.GetBytes(e.host.GetConfig(), "killSwitch.engaged").Bool() {
+		return true
+	}
+
+	if e.cfg.killSwitch.file == "" {
+		return false
+	}
+
+	// A missing file means the kill switch is released.
+	content, err := os.ReadFile(e.cfg.killSwitch.file)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(content))) {
+	case "on", "true", "1":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,113 @@
+package guest
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKillSwitch(t *testing.T) {
+	const directives = `
+		"directives": [
+			"SecRuleEngine On",
+			"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\""
+		]`
+
+	blocked := func() bool {
+		res := newMockResponse(200, "")
+		serve(newMockRequest("GET", "/?id=0", ""), res)
+		return res.statusCode == 403
+	}
+
+	reset := func(t *testing.T) {
+		killSwitchNextPoll.Store(0)
+		killSwitchState.Store(false)
+		t.Cleanup(func() { killSwitchState.Store(false) })
+	}
+
+	t.Run("file", func(t *testing.T) {
+		reset(t)
+		file := filepath.Join(t.TempDir(), "killswitch")
+		useEngine(t, `{"killSwitch": {"file": "`+file+`", "interval": 0}, `+directives+`}`)
+		e := activeEngine.Load()
+
+		require.True(t, blocked(), "missing file releases the kill switch")
+
+		require.NoError(t, os.WriteFile(file, []byte("on\n"), 0o600))
+		require.False(t, blocked())
+		require.Equal(t, modeDetect, activeEngine.Load().mode())
+
+		require.NoError(t, os.WriteFile(file, []byte("off"), 0o600))
+		require.True(t, blocked())
+		require.Equal(t, modeEnforce, activeEngine.Load().mode())
+
+		require.Same(t, e, activeEngine.Load(), "the engine is not rebuilt")
+	})
+
+	t.Run("host config", func(t *testing.T) {
+		reset(t)
+		var engaged atomic.Bool
+		host := mockAPIHost{t: t, features: allFeatures, getConfig: func() []byte {
+			if engaged.Load() {
+				return []byte(`{"killSwitch": {"engaged": true, "interval": 0}, ` + directives + `}`)
+			}
+			return []byte(`{"killSwitch": {"interval": 0}, ` + directives + `}`)
+		}}
+		e, err := initializeWAF(host, overrides{})
+		require.NoError(t, err)
+		e.features = allFeatures
+		previous := activeEngine.Swap(e)
+		t.Cleanup(func() { activeEngine.Store(previous) })
+
+		require.True(t, blocked())
+		engaged.Store(true)
+		require.False(t, blocked())
+		engaged.Store(false)
+		require.True(t, blocked())
+	})
+
+	t.Run("canary", func(t *testing.T) {
+		reset(t)
+		file := filepath.Join(t.TempDir(), "killswitch")
+		require.NoError(t, os.WriteFile(file, []byte("on"), 0o600))
+		useEngine(t, `{
+			"killSwitch": {"file": "`+file+`", "interval": 0},
+			"verdictHeaders": true,
+			"canary": {
+				"directives": [
+					"SecRuleEngine On",
+					"SecRule ARGS:id \"@eq 1\" \"id:2,phase:1,deny,status:403,msg:'Canary'\""
+				],
+				"percent": 100
+			},
+			`+directives+`
+		}`)
+
+		requests := metrics.canaryRequests.Load()
+		req, res := newMockRequest("GET", "/?id=1", ""), newMockResponse(200, "")
+		serve(req, res)
+		require.Equal(t, uint32(200), res.statusCode)
+		require.Equal(t, uint64(1), metrics.canaryRequests.Load()-requests)
+		ruleIDs, _ := req.headers.Get(verdictRuleIDsHeader)
+		require.Equal(t, "2", ruleIDs, "the canary ruleset runs in detection only")
+	})
+
+	t.Run("polled once per interval", func(t *testing.T) {
+		reset(t)
+		file := filepath.Join(t.TempDir(), "killswitch")
+		useEngine(t, `{"killSwitch": {"file": "`+file+`", "interval": 60}, `+directives+`}`)
+
+		now := time.Now()
+		activeEngine.Load().pollKillSwitch(now)
+		require.NoError(t, os.WriteFile(file, []byte("on"), 0o600))
+		activeEngine.Load().pollKillSwitch(now.Add(30 * time.Second))
+		require.Equal(t, modeEnforce, activeEngine.Load().mode())
+
+		activeEngine.Load().pollKillSwitch(now.Add(61 * time.Second))
+		require.Equal(t, modeDetect, activeEngine.Load().mode())
+	})
+}
@@ -0,0 +1,120 @@
+package guest
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/debuglog"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// debugLogLevelOverride is the debug log level set through the admin API. It
+// is shared by the loggers of the WAFs built while it is set, so that
+// reverting it flips their level without rebuilding them.
+type debugLogLevelOverride struct {
+	level debuglog.Level
+	// until is when the configured level is restored, if not zero.
+	until    time.Time
+	reverted atomic.Bool
+}
+
+// active tells whether the override replaces the configured level.
+func (o *debugLogLevelOverride) active() bool {
+	return o != nil && !o.reverted.Load()
+}
+
+// revertDebugLogLevel restores the configured debug log level once the
+// window set through the admin API is over, returning whether it was
+// reverted by this call.
+func (e *engine) revertDebugLogLevel(now time.Time) bool {
+	o := e.overrides.debugLogLevel
+	if !o.active() || o.until.IsZero() || now.Before(o.until) || !o.reverted.CompareAndSwap(false, true) {
+		return false
+	}
+	e.host.Log(api.LogLevelInfo, "Debug log level reverted to the configured one")
+	return true
+}
+
+func debugLogger(host api.Host, override *debugLogLevelOverride) debuglog.Logger {
+	logger := debuglog.DefaultWithPrinterFactory(func(io.Writer) debuglog.Printer {
+		return func(lvl debuglog.Level, message, fields string) {
+			host.Log(toHostLevel(lvl), message+" "+fields)
+		}
+	})
+	return leveledLogger{
+		logger:   logger.WithLevel(debuglog.LevelTrace),
+		level:    debuglog.LevelInfo,
+		override: override,
+	}
+}
+
+// noopEvent is the event returned for the disabled levels.
+var noopEvent = debuglog.Noop().Error()
+
+// leveledLogger filters the events of logger, which is enabled at every
+// level, by the level set with SecDebugLogLevel, or by the override while it
+// is active.
+type leveledLogger struct {
+	logger   debuglog.Logger
+	level    debuglog.Level
+	override *debugLogLevelOverride
+}
+
+// enabled tells whether the events of level lvl are logged.
+func (l leveledLogger) enabled(lvl debuglog.Level) bool {
+	if l.override.active() {
+		return lvl <= l.override.level
+	}
+	return lvl <= l.level
+}
+
+func (l leveledLogger) WithOutput(w io.Writer) debuglog.Logger {
+	l.logger = l.logger.WithOutput(w)
+	return l
+}
+
+func (l leveledLogger) WithLevel(lvl debuglog.Level) debuglog.Logger {
+	l.level = lvl
+	return l
+}
+
+func (l leveledLogger) With(fields ...debuglog.ContextField) debuglog.Logger {
+	l.logger = l.logger.With(fields...)
+	return l
+}
+
+func (l leveledLogger) Trace() debuglog.Event {
+	if !l.enabled(debuglog.LevelTrace) {
+		return noopEvent
+	}
+	return l.logger.Trace()
+}
+
+func (l leveledLogger) Debug() debuglog.Event {
+	if !l.enabled(debuglog.LevelDebug) {
+		return noopEvent
+	}
+	return l.logger.Debug()
+}
+
+func (l leveledLogger) Info() debuglog.Event {
+	if !l.enabled(debuglog.LevelInfo) {
+		return noopEvent
+	}
+	return l.logger.Info()
+}
+
+func (l leveledLogger) Warn() debuglog.Event {
+	if !l.enabled(debuglog.LevelWarn) {
+		return noopEvent
+	}
+	return l.logger.Warn()
+}
+
+func (l leveledLogger) Error() debuglog.Event {
+	if !l.enabled(debuglog.LevelError) {
+		return noopEvent
+	}
+	return l.logger.Error()
+}
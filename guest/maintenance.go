@@ -0,0 +1,72 @@
+package guest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// maintenanceConfig configures the maintenance response, returned instead of
+// forwarding the requests while maintenance is on, e.g. as an emergency
+// shield while an unpatched upstream is actively exploited.
+type maintenanceConfig struct {
+	// enabled turns maintenance on at startup, it can also be switched
+	// through the admin API.
+	enabled bool
+	// paths restricts maintenance to the requests under these path prefixes,
+	// all requests are concerned when empty.
+	paths       []string
+	statusCode  uint32
+	contentType string
+	body        string
+}
+
+// maintenance tells whether maintenance is on, the admin API overriding the
+// config.
+func (e *engine) maintenance() bool {
+	if e.overrides.setMaintenance {
+		return e.overrides.maintenance
+	}
+	return e.cfg.maintenance.enabled
+}
+
+// serveMaintenance answers the request with the maintenance response when
+// maintenance is on and the request is concerned, returning false otherwise.
+func (e *engine) serveMaintenance(req api.Request, res api.Response) bool {
+	cfg := e.cfg.maintenance
+	if !matchPaths(req.GetURI(), cfg.paths) {
+		return false
+	}
+
+	res.Headers().Set("Content-Type", cfg.contentType)
+	res.Headers().Set("Cache-Control", "no-store")
+	res.SetStatusCode(cfg.statusCode)
+	res.Body().WriteString(cfg.body)
+	return true
+}
+
+func defaultMaintenanceConfig() maintenanceConfig {
+	return maintenanceConfig{
+		statusCode:  http.StatusServiceUnavailable,
+		contentType: "text/plain",
+		body:        "Service temporarily unavailable",
+	}
+}
+
+// matchPaths tells whether the path of uri, once normalized as the rules see
+// it, is under one of the path prefixes, any path matching when there are
+// none.
+func matchPaths(uri string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	path := parseRequestTarget("", uri).path()
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package guest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenance(t *testing.T) {
+	const directives = `
+		"directives": [
+			"SecRuleEngine On",
+			"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\""
+		]`
+
+	t.Run("all traffic", func(t *testing.T) {
+		useEngine(t, `{"maintenance": {"enabled": true}, `+directives+`}`)
+
+		res := newMockResponse(200, "")
+		next, reqCtx := HandleRequest(newMockRequest("GET", "/", ""), res)
+		require.False(t, next)
+		require.Zero(t, reqCtx)
+		require.Equal(t, uint32(503), res.statusCode)
+		require.Equal(t, "Service temporarily unavailable", string(res.body.written))
+	})
+
+	t.Run("matched paths and custom page", func(t *testing.T) {
+		useEngine(t, `{"maintenance": {
+			"enabled": true,
+			"paths": ["/api/"],
+			"status": 502,
+			"contentType": "text/html",
+			"body": "<h1>Be right back</h1>"
+		}, `+directives+`}`)
+
+		res := newMockResponse(200, "")
+		next, _ := HandleRequest(newMockRequest("GET", "/api/users?id=1", ""), res)
+		require.False(t, next)
+		require.Equal(t, uint32(502), res.statusCode)
+		require.Equal(t, []string{"text/html"}, res.headers.GetAll("Content-Type"))
+		require.Equal(t, "<h1>Be right back</h1>", string(res.body.written))
+
+		res = newMockResponse(200, "")
+		next, _ = HandleRequest(newMockRequest("GET", "/?api/", ""), res)
+		require.True(t, next)
+		require.Equal(t, uint32(200), res.statusCode)
+	})
+
+	t.Run("admin switch", func(t *testing.T) {
+		useEngine(t, `{"admin": {"path": "/_coraza", "token": "secret"}, `+directives+`}`)
+
+		for _, value := range []string{"on", "off"} {
+			req := newMockRequest("POST", "/_coraza/maintenance?value="+value, "")
+			req.headers.Set("Authorization", "Bearer secret")
+			res := newMockResponse(200, "")
+			HandleRequest(req, res)
+			require.Equal(t, uint32(200), res.statusCode)
+
+			res = newMockResponse(200, "")
+			serve(newMockRequest("GET", "/", ""), res)
+			require.Equal(t, value == "on", res.statusCode == 503)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package guest
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// metrics holds the counters exposed by the admin API, they are kept across
+// engine reloads.
+var metrics struct {
+	// requests counts the requests inspected by the WAF.
+	requests atomic.Uint64
+	// requestInterruptions and responseInterruptions count the requests
+	// interrupted by the WAF, by the phase they were interrupted at.
+	requestInterruptions  atomic.Uint64
+	responseInterruptions atomic.Uint64
+	// geoDenied counts the requests from a denied country.
+	geoDenied atomic.Uint64
+	// honeypotHits counts the requests to a honeypot, bannedRequests the
+	// requests of the clients banned for it.
+	honeypotHits   atomic.Uint64
+	bannedRequests atomic.Uint64
+	// tlsViolations counts the requests violating the TLS policy.
+	tlsViolations atomic.Uint64
+	// disallowedMethods counts the requests with a method not in
+	// allowedMethods.
+	disallowedMethods atomic.Uint64
+	// oversizedRequests counts the requests exceeding the request line or
+	// header size limits.
+	oversizedRequests atomic.Uint64
+	// protocolViolations counts the requests failing the request smuggling
+	// checks.
+	protocolViolations atomic.Uint64
+	// slowRequests counts the requests with a body below the minimum rate.
+	slowRequests atomic.Uint64
+	// bots counts the requests with a bot score reaching the threshold.
+	bots atomic.Uint64
+	// signatureFailures counts the requests failing the signature
+	// verification.
+	signatureFailures atomic.Uint64
+	// csrfFailures counts the requests failing the CSRF check.
+	csrfFailures atomic.Uint64
+	// bruteForce counts the login requests exceeding the failure threshold.
+	bruteForce atomic.Uint64
+	// dataLeaks counts the responses a data leak was detected in.
+	dataLeaks atomic.Uint64
+	// rateLimited counts the requests exceeding a rate limit.
+	rateLimited atomic.Uint64
+	// bypassedRequests counts the requests carrying a valid bypass token.
+	bypassedRequests atomic.Uint64
+	// hostErrors counts the responses the host failed to get from the
+	// upstream, see inspectHostErrors.
+	hostErrors atomic.Uint64
+	// abortedTransactions counts the transactions closed without their
+	// response, see reapInflight.
+	abortedTransactions atomic.Uint64
+	// lateResponses counts the responses arriving once their transaction
+	// is no longer in flight, e.g. closed as aborted, see HandleResponse.
+	lateResponses atomic.Uint64
+	// debugDumps counts the transactions dumped for debugging.
+	debugDumps atomic.Uint64
+	// canaryRequests and the canary interruptions count the same for the
+	// requests going through the canary WAF, they are included in the totals.
+	canaryRequests              atomic.Uint64
+	canaryRequestInterruptions  atomic.Uint64
+	canaryResponseInterruptions atomic.Uint64
+	// shadowWouldBlock and shadowWouldAllow count the requests the shadow
+	// WAF gives a different verdict for, see finishShadow.
+	shadowWouldBlock atomic.Uint64
+	shadowWouldAllow atomic.Uint64
+}
+
+// writeMetrics writes the metrics in the Prometheus text format.
+func writeMetrics(b *strings.Builder) {
+	writeMetric(b, "coraza_requests_total", "counter", "Requests inspected by the WAF.", "", metrics.requests.Load())
+	writeMetric(b, "coraza_interruptions_total", "counter", "Requests interrupted by the WAF.", `phase="request"`, metrics.requestInterruptions.Load())
+	writeMetricValue(b, "coraza_interruptions_total", `phase="response"`, metrics.responseInterruptions.Load())
+	writeMetric(b, "coraza_geo_denied_requests_total", "counter", "Requests from a denied country.", "", metrics.geoDenied.Load())
+	writeMetric(b, "coraza_honeypot_requests_total", "counter", "Requests to a honeypot path.", "", metrics.honeypotHits.Load())
+	writeMetric(b, "coraza_banned_requests_total", "counter", "Requests from clients banned for requesting a honeypot.", "", metrics.bannedRequests.Load())
+	writeMetric(b, "coraza_tls_violations_total", "counter", "Requests violating the TLS policy.", "", metrics.tlsViolations.Load())
+	writeMetric(b, "coraza_disallowed_method_requests_total", "counter", "Requests with a method not allowed.", "", metrics.disallowedMethods.Load())
+	writeMetric(b, "coraza_oversized_requests_total", "counter", "Requests exceeding the request line or header size limits.", "", metrics.oversizedRequests.Load())
+	writeMetric(b, "coraza_protocol_violations_total", "counter", "Requests failing the request smuggling checks.", "", metrics.protocolViolations.Load())
+	writeMetric(b, "coraza_slow_requests_total", "counter", "Requests with a body below the minimum rate.", "", metrics.slowRequests.Load())
+	writeMetric(b, "coraza_bot_requests_total", "counter", "Requests with a bot score reaching the threshold.", "", metrics.bots.Load())
+	writeMetric(b, "coraza_signature_failures_total", "counter", "Requests failing the signature verification.", "", metrics.signatureFailures.Load())
+	writeMetric(b, "coraza_csrf_failures_total", "counter", "Requests failing the CSRF check.", "", metrics.csrfFailures.Load())
+	writeMetric(b, "coraza_brute_force_requests_total", "counter", "Login requests exceeding the failure threshold.", "", metrics.bruteForce.Load())
+	writeMetric(b, "coraza_data_leak_responses_total", "counter", "Responses a data leak was detected in.", "", metrics.dataLeaks.Load())
+	writeMetric(b, "coraza_rate_limited_requests_total", "counter", "Requests exceeding a rate limit.", "", metrics.rateLimited.Load())
+	writeMetric(b, "coraza_bypassed_requests_total", "counter", "Requests carrying a valid bypass token.", "", metrics.bypassedRequests.Load())
+	writeMetric(b, "coraza_host_errors_total", "counter", "Responses the host failed to get from the upstream.", "", metrics.hostErrors.Load())
+	writeMetric(b, "coraza_aborted_transactions_total", "counter", "Transactions closed without their response.", "", metrics.abortedTransactions.Load())
+	writeMetric(b, "coraza_late_responses_total", "counter", "Responses passed without inspection as their transaction was no longer in flight.", "", metrics.lateResponses.Load())
+	writeMetric(b, "coraza_debug_dumps_total", "counter", "Transactions dumped for debugging.", "", metrics.debugDumps.Load())
+	writeMetric(b, "coraza_canary_requests_total", "counter", "Requests inspected by the canary WAF.", "", metrics.canaryRequests.Load())
+	writeMetric(b, "coraza_canary_interruptions_total", "counter", "Requests interrupted by the canary WAF.", `phase="request"`, metrics.canaryRequestInterruptions.Load())
+	writeMetricValue(b, "coraza_canary_interruptions_total", `phase="response"`, metrics.canaryResponseInterruptions.Load())
+	writeMetric(b, "coraza_shadow_divergences_total", "counter", "Requests the shadow WAF gives a different verdict for.", `kind="would_block"`, metrics.shadowWouldBlock.Load())
+	writeMetricValue(b, "coraza_shadow_divergences_total", `kind="would_allow"`, metrics.shadowWouldAllow.Load())
+	writeMetric(b, "coraza_inflight_transactions", "gauge", "Transactions waiting for their response.", "", uint64(txs.len()))
+}
+
+func writeMetric(b *strings.Builder, name, typ, help, labels string, value uint64) {
+	b.WriteString("# HELP " + name + " " + help + "\n")
+	b.WriteString("# TYPE " + name + " " + typ + "\n")
+	writeMetricValue(b, name, labels, value)
+}
+
+func writeMetricValue(b *strings.Builder, name, labels string, value uint64) {
+	b.WriteString(name)
+	if labels != "" {
+		b.WriteString("{" + labels + "}")
+	}
+	b.WriteString(" " + strconv.FormatUint(value, 10) + "\n")
+}
@@ -0,0 +1,40 @@
+package guest
+
+// The modes the whole engine can be switched to, e.g. during change windows
+// or incident response, either from the host config or the admin API:
+//   - modeEnforce runs the ruleset as configured.
+//   - modeDetect runs the ruleset with SecRuleEngine DetectionOnly, note
+//     that rules can still switch it back on with ctl:ruleEngine.
+//   - modeBypass lets all the requests through without inspecting them.
+const (
+	modeEnforce = "enforce"
+	modeDetect  = "detect"
+	modeBypass  = "bypass"
+)
+
+func validMode(mode string) bool {
+	return mode == modeEnforce || mode == modeDetect || mode == modeBypass
+}
+
+// effectiveMode returns the mode set through the admin API, or else the
+// configured one.
+func effectiveMode(cfg config, ov overrides) string {
+	mode := modeEnforce
+	switch {
+	case ov.mode != "":
+		mode = ov.mode
+	case cfg.mode != "":
+		mode = cfg.mode
+	}
+	return mode
+}
+
+// mode returns the mode the engine runs in. An engaged kill switch turns
+// enforce into detect.
+func (e *engine) mode() string {
+	mode := effectiveMode(e.cfg, e.overrides)
+	if mode == modeEnforce && e.killSwitchEngaged() {
+		return modeDetect
+	}
+	return mode
+}
@@ -0,0 +1,59 @@
+package guest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestModes(t *testing.T) {
+	const directives = `
+		"directives": [
+			"SecRuleEngine On",
+			"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\""
+		]`
+
+	tests := map[string]struct {
+		mode            string
+		expectedBlocked bool
+		expectedNext    bool
+	}{
+		"default": {expectedBlocked: true},
+		"enforce": {mode: modeEnforce, expectedBlocked: true},
+		"detect":  {mode: modeDetect, expectedNext: true},
+		"bypass":  {mode: modeBypass, expectedNext: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := `{` + directives
+			if test.mode != "" {
+				cfg += `, "mode": "` + test.mode + `"`
+			}
+			useEngine(t, cfg+`}`)
+
+			res := newMockResponse(200, "")
+			next, reqCtx := HandleRequest(newMockRequest("GET", "/?id=0", ""), res)
+			require.Equal(t, test.expectedBlocked, res.statusCode == 403)
+			require.Equal(t, test.expectedNext, next)
+			require.Zero(t, reqCtx)
+		})
+	}
+
+	t.Run("admin switch", func(t *testing.T) {
+		useEngine(t, `{"admin": {"path": "/_coraza", "token": "secret"}, `+directives+`}`)
+
+		for _, mode := range []string{modeBypass, modeDetect, modeEnforce} {
+			req := newMockRequest("POST", "/_coraza/mode?value="+mode, "")
+			req.headers.Set("Authorization", "Bearer secret")
+			res := newMockResponse(200, "")
+			HandleRequest(req, res)
+			require.Equal(t, uint32(200), res.statusCode)
+			require.Equal(t, mode, activeEngine.Load().mode())
+
+			res = newMockResponse(200, "")
+			serve(newMockRequest("GET", "/?id=0", ""), res)
+			require.Equal(t, mode == modeEnforce, res.statusCode == 403)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package guest
+
+import (
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// maxRateLimitBuckets bounds the memory held by the rate limiter.
+const maxRateLimitBuckets = 1 << 16
+
+// Rate limit keys, the requests sharing a key share a bucket.
+const (
+	rateLimitByIP          = "ip"
+	rateLimitByIPAndPath   = "ip+path"
+	rateLimitByFingerprint = "fingerprint"
+)
+
+// rateLimitConfig configures a token bucket limit: requests sharing the key
+// are allowed at rate per second on average, with bursts up to burst.
+type rateLimitConfig struct {
+	by    string
+	rate  float64
+	burst float64
+}
+
+// rateLimiter implements the rate limits in guest memory, as the CRS DoS
+// rules depend on persistent collections this connector lacks. It is kept
+// across engine reloads as long as the limits don't change.
+type rateLimiter struct {
+	limits []rateLimitConfig
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*tokenBucket
+}
+
+type bucketKey struct {
+	limit int
+	key   string
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limits []rateLimitConfig) *rateLimiter {
+	if len(limits) == 0 {
+		return nil
+	}
+	return &rateLimiter{limits: limits, buckets: map[bucketKey]*tokenBucket{}}
+}
+
+// rateLimit takes a token for the request from the bucket of each limit,
+// returning how long to wait before retrying when one of them is empty. The
+// buckets are all checked before any token is taken, so that a request
+// rejected by one limit doesn't count against the others.
+func (e *engine) rateLimit(req api.Request, client string, now time.Time) (time.Duration, bool) {
+	l := e.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.buckets) >= maxRateLimitBuckets {
+		l.evict(now)
+	}
+
+	var retryAfter time.Duration
+	buckets := make([]*tokenBucket, len(l.limits))
+	for i, limit := range l.limits {
+		if client == "" && limit.by != rateLimitByFingerprint {
+			// All the clients would share the bucket of the unknown IP.
+			continue
+		}
+		key := bucketKey{limit: i, key: rateLimitKey(limit.by, req, client)}
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &tokenBucket{tokens: limit.burst, last: now}
+			l.buckets[key] = b
+		}
+
+		b.tokens = math.Min(limit.burst, b.tokens+now.Sub(b.last).Seconds()*limit.rate)
+		b.last = now
+		if b.tokens < 1 {
+			retryAfter = max(retryAfter, time.Duration((1-b.tokens)/limit.rate*float64(time.Second)))
+		}
+		buckets[i] = b
+	}
+	if retryAfter > 0 {
+		return retryAfter, false
+	}
+
+	for _, b := range buckets {
+		if b != nil {
+			b.tokens--
+		}
+	}
+	return 0, true
+}
+
+// evict drops the buckets refilled by now, which are the same as new ones,
+// or all of them when it is not enough.
+func (l *rateLimiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		limit := l.limits[key.limit]
+		if b.tokens+now.Sub(b.last).Seconds()*limit.rate >= limit.burst {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) >= maxRateLimitBuckets {
+		clear(l.buckets)
+	}
+}
+
+func rateLimitKey(by string, req api.Request, client string) string {
+	switch by {
+	case rateLimitByIPAndPath:
+		// The path is the one the rules see, so that the limit can't be
+		// dodged by sending the target in another shape, e.g. "//login".
+		return client + " " + parseRequestTarget(req.GetMethod(), req.GetURI()).path()
+	case rateLimitByFingerprint:
+		return requestFingerprint(req.Headers())
+	default:
+		return client
+	}
+}
+
+// requestFingerprint identifies the client software by the headers it sends,
+// so that a tool spreading its requests over many IPs is still limited.
+func requestFingerprint(headers api.Header) string {
+	h := fnv.New64a()
+	for _, name := range []string{"User-Agent", "Accept", "Accept-Language", "Accept-Encoding"} {
+		v, _ := headers.Get(name)
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// serveRateLimited answers a rate limited request with a 429.
+func serveRateLimited(res api.Response, retryAfter time.Duration) {
+	res.Headers().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	res.SetStatusCode(http.StatusTooManyRequests)
+}
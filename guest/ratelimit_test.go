@@ -0,0 +1,150 @@
+package guest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	const directives = `"directives": ["SecRuleEngine On"]`
+
+	t.Run("token bucket", func(t *testing.T) {
+		useEngine(t, `{"rateLimits": [{"rate": 2, "burst": 3}], `+directives+`}`)
+		e := activeEngine.Load()
+		req := newMockRequest("GET", "/", "")
+		now := time.Now()
+
+		for i := 0; i < 3; i++ {
+			_, ok := e.rateLimit(req, "10.0.0.1", now)
+			require.True(t, ok, "burst")
+		}
+		retryAfter, ok := e.rateLimit(req, "10.0.0.1", now)
+		require.False(t, ok)
+		require.Equal(t, 500*time.Millisecond, retryAfter)
+
+		_, ok = e.rateLimit(req, "10.0.0.2", now)
+		require.True(t, ok, "other clients have their own bucket")
+
+		_, ok = e.rateLimit(req, "10.0.0.1", now.Add(500*time.Millisecond))
+		require.True(t, ok, "refilled")
+		_, ok = e.rateLimit(req, "10.0.0.1", now.Add(500*time.Millisecond))
+		require.False(t, ok)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		useEngine(t, `{"rateLimits": [{"by": "ip+path", "rate": 1}, {"by": "fingerprint", "rate": 1, "burst": 3}], `+directives+`}`)
+		e := activeEngine.Load()
+		now := time.Now()
+
+		allowed := func(client, uri, userAgent string) bool {
+			req := newMockRequest("GET", uri, "")
+			req.headers.Set("User-Agent", userAgent)
+			_, ok := e.rateLimit(req, client, now)
+			return ok
+		}
+
+		require.True(t, allowed("10.0.0.1", "/a", "curl"))
+		require.False(t, allowed("10.0.0.1", "/a?x=1", "firefox"), "same IP and path")
+		require.True(t, allowed("10.0.0.1", "/b", "curl"))
+		require.True(t, allowed("10.0.0.2", "/a", "curl"))
+		require.False(t, allowed("10.0.0.3", "/a", "curl"), "same fingerprint")
+		require.True(t, allowed("10.0.0.4", "/a", "firefox"))
+	})
+
+	t.Run("normalized path", func(t *testing.T) {
+		useEngine(t, `{"rateLimits": [{"by": "ip+path", "rate": 1}], `+directives+`}`)
+		e := activeEngine.Load()
+		now := time.Now()
+
+		_, ok := e.rateLimit(newMockRequest("GET", "/login", ""), "10.0.0.1", now)
+		require.True(t, ok)
+		for _, uri := range []string{"//login", "http://example.com/login?x=1", "/login#x"} {
+			_, ok := e.rateLimit(newMockRequest("GET", uri, ""), "10.0.0.1", now)
+			require.False(t, ok, uri)
+		}
+	})
+
+	t.Run("unknown client IP", func(t *testing.T) {
+		useEngine(t, `{"rateLimits": [{"by": "ip", "rate": 1}, {"by": "ip+path", "rate": 1}, {"by": "fingerprint", "rate": 1, "burst": 2}], `+directives+`}`)
+		e := activeEngine.Load()
+		now := time.Now()
+
+		// The IP limits don't apply, all the clients would share their
+		// buckets, the fingerprint one does.
+		for _, expected := range []bool{true, true, false} {
+			_, ok := e.rateLimit(newMockRequest("GET", "/", ""), "", now)
+			require.Equal(t, expected, ok)
+		}
+	})
+
+	t.Run("rejected requests take no token", func(t *testing.T) {
+		useEngine(t, `{"rateLimits": [{"by": "ip", "rate": 1, "burst": 2}, {"by": "ip+path", "rate": 1}], `+directives+`}`)
+		e := activeEngine.Load()
+		now := time.Now()
+
+		allowed := func(uri string) bool {
+			_, ok := e.rateLimit(newMockRequest("GET", uri, ""), "10.0.0.1", now)
+			return ok
+		}
+
+		require.True(t, allowed("/a"))
+		require.False(t, allowed("/a"), "same path")
+		require.True(t, allowed("/b"), "the IP bucket was not taken from")
+		require.False(t, allowed("/c"), "IP bucket empty")
+	})
+
+	t.Run("too many requests", func(t *testing.T) {
+		useEngine(t, `{"rateLimits": [{"rate": 1}], `+directives+`}`)
+
+		res := newMockResponse(200, "")
+		serve(newMockRequest("GET", "/", ""), res)
+		require.Equal(t, uint32(200), res.statusCode)
+
+		limited := metrics.rateLimited.Load()
+		res = newMockResponse(200, "")
+		next, _ := HandleRequest(newMockRequest("GET", "/", ""), res)
+		require.False(t, next)
+		require.Equal(t, uint32(429), res.statusCode)
+		require.Equal(t, []string{"1"}, res.headers.GetAll("Retry-After"))
+		require.Equal(t, uint64(1), metrics.rateLimited.Load()-limited)
+	})
+
+	t.Run("detect mode", func(t *testing.T) {
+		useEngine(t, `{"mode": "detect", "rateLimits": [{"rate": 1}], `+directives+`}`)
+
+		for i := 0; i < 3; i++ {
+			res := newMockResponse(200, "")
+			serve(newMockRequest("GET", "/", ""), res)
+			require.Equal(t, uint32(200), res.statusCode)
+		}
+	})
+
+	t.Run("logged", func(t *testing.T) {
+		for _, mode := range []string{"enforce", "detect"} {
+			useEngine(t, `{"mode": "`+mode+`", "rateLimits": [{"rate": 1}], `+directives+`}`)
+			var logs bytes.Buffer
+			e := activeEngine.Load()
+			e.host = recordingHost{mockAPIHost: e.host.(mockAPIHost), logs: &logs}
+
+			for i := 0; i < 2; i++ {
+				serve(newMockRequest("GET", "/", ""), newMockResponse(200, ""))
+			}
+			require.Equal(t, 1, strings.Count(logs.String(), "exceeds the rate limit"), mode)
+		}
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		l := newRateLimiter([]rateLimitConfig{{by: rateLimitByIP, rate: 1, burst: 1}})
+		now := time.Now()
+		l.buckets[bucketKey{key: "idle"}] = &tokenBucket{tokens: 0, last: now.Add(-time.Second)}
+		l.buckets[bucketKey{key: "busy"}] = &tokenBucket{tokens: 0, last: now}
+
+		l.evict(now)
+		require.NotContains(t, l.buckets, bucketKey{key: "idle"})
+		require.Contains(t, l.buckets, bucketKey{key: "busy"})
+	})
+}
@@ -0,0 +1,330 @@
+package guest
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxIncludes mirrors the limit on the number of included files enforced by
+// the seclang parser, so a self including file can't hang the analysis.
+const maxIncludes = 100
+
+// defaultRequestBodyLimit is the SecRequestBodyLimit of coraza, enforced with
+// SecRequestBodyLimitAction Reject unless configured otherwise.
+const defaultRequestBodyLimit = 13107200
+
+// rulesetInfo summarizes what the loaded ruleset actually needs from the
+// connector, so that work nothing is going to look at can be skipped.
+type rulesetInfo struct {
+	// responsePhases is true when there are rules for phases 3, 4 or 5 or
+	// audit logging is enabled, both requiring the response to be processed.
+	responsePhases bool
+	// requestBody is true when there are rules inspecting variables populated
+	// from the request body or audit logging is enabled, both requiring the
+	// request body to be buffered.
+	requestBody bool
+	// requestBodyLimit is the SecRequestBodyLimit rejecting larger request
+	// bodies, zero when the body is not accessible or the limit action is
+	// ProcessPartial. The body has to be read for coraza to enforce it, see
+	// mayExceedBodyLimit.
+	requestBodyLimit int64
+}
+
+// allPhases is used whenever the ruleset can't be fully analyzed (e.g. an
+// Include can't be resolved) so that nothing gets skipped.
+var allPhases = rulesetInfo{responsePhases: true, requestBody: true}
+
+// union returns the info of a ruleset running along with another one.
+func (r rulesetInfo) union(other rulesetInfo) rulesetInfo {
+	limit := r.requestBodyLimit
+	if limit == 0 || other.requestBodyLimit != 0 && other.requestBodyLimit < limit {
+		limit = other.requestBodyLimit
+	}
+	return rulesetInfo{
+		responsePhases:   r.responsePhases || other.responsePhases,
+		requestBody:      r.requestBody || other.requestBody,
+		requestBodyLimit: limit,
+	}
+}
+
+// requestBodyVariables lists the variables populated by the request body
+// processors.
+var requestBodyVariables = map[string]struct{}{
+	"ARGS":                    {},
+	"ARGS_COMBINED_SIZE":      {},
+	"ARGS_NAMES":              {},
+	"ARGS_POST":               {},
+	"ARGS_POST_NAMES":         {},
+	"FILES":                   {},
+	"FILES_COMBINED_SIZE":     {},
+	"FILES_NAMES":             {},
+	"FILES_SIZES":             {},
+	"FILES_TMPNAMES":          {},
+	"FILES_TMP_CONTENT":       {},
+	"MULTIPART_FILENAME":      {},
+	"MULTIPART_NAME":          {},
+	"MULTIPART_PART_HEADERS":  {},
+	"MULTIPART_STRICT_ERROR":  {},
+	"REQBODY_ERROR":           {},
+	"REQBODY_ERROR_MSG":       {},
+	"REQBODY_PROCESSOR_ERROR": {},
+	"REQUEST_BODY":            {},
+	"REQUEST_BODY_LENGTH":     {},
+	"XML":                     {},
+}
+
+// analyzeRuleset walks the directives the same way the seclang parser does,
+// following includes in root, and reports which phases have rules.
+func analyzeRuleset(root fs.FS, directives string) rulesetInfo {
+	a := rulesetAnalyzer{root: root, bodyLimit: defaultRequestBodyLimit}
+	if !a.analyze(directives, "") {
+		return allPhases
+	}
+
+	if a.bodyAccess && !a.processPartial {
+		a.info.requestBodyLimit = a.bodyLimit
+	}
+	return a.info
+}
+
+// countDirectives returns the number of directives and rules, following
+// includes in root, and false if the directives could not be analyzed.
+func countDirectives(root fs.FS, directives string) (int, int, bool) {
+	a := rulesetAnalyzer{root: root}
+	ok := a.analyze(directives, "")
+	return a.directives, a.rules, ok
+}
+
+type rulesetAnalyzer struct {
+	root     fs.FS
+	info     rulesetInfo
+	includes int
+	// bodyAccess, bodyLimit and processPartial track the request body
+	// directives, see rulesetInfo.requestBodyLimit.
+	bodyAccess     bool
+	bodyLimit      int64
+	processPartial bool
+	// directives and rules count the directives, includes aside, and the
+	// rules, chained ones included.
+	directives int
+	rules      int
+	// chainPhase holds the phase of the parent rule while parsing a chain, as
+	// chained rules inherit it.
+	chainPhase int
+}
+
+// analyze returns false if the directives could not be analyzed.
+func (a *rulesetAnalyzer) analyze(directives string, currentDir string) bool {
+	var line strings.Builder
+	for _, l := range strings.Split(directives, "\n") {
+		l = strings.TrimSpace(l)
+		if len(l) == 0 || l[0] == '#' {
+			continue
+		}
+
+		if l[len(l)-1] == '\\' {
+			line.WriteString(strings.TrimSuffix(l, "\\"))
+			continue
+		}
+
+		line.WriteString(l)
+		if !a.analyzeLine(line.String(), currentDir) {
+			return false
+		}
+		line.Reset()
+	}
+
+	return true
+}
+
+func (a *rulesetAnalyzer) analyzeLine(line string, currentDir string) bool {
+	directive, opts, _ := strings.Cut(line, " ")
+	opts = strings.TrimSpace(opts)
+
+	if strings.ToLower(directive) != "include" {
+		a.directives++
+	}
+
+	switch strings.ToLower(directive) {
+	case "include":
+		return a.include(strings.Trim(opts, `"`), currentDir)
+	case "secrequestbodyaccess":
+		a.bodyAccess = strings.ToLower(strings.Trim(opts, `"`)) == "on"
+	case "secrequestbodylimit":
+		if limit, err := strconv.ParseInt(strings.Trim(opts, `"`), 10, 64); err == nil {
+			a.bodyLimit = limit
+		}
+	case "secrequestbodylimitaction":
+		a.processPartial = strings.ToLower(strings.Trim(opts, `"`)) == "processpartial"
+	case "secauditengine":
+		if strings.ToLower(strings.Trim(opts, `"`)) != "off" {
+			a.info.responsePhases = true
+			a.info.requestBody = true
+		}
+	case "secrule":
+		args := splitArgs(opts)
+		for len(args) < 3 {
+			args = append(args, "")
+		}
+		a.addRule(args[0], args[2])
+	case "secaction":
+		if args := splitArgs(opts); len(args) > 0 {
+			a.addRule("", args[0])
+		} else {
+			a.addRule("", "")
+		}
+	case "secruleupdatetargetbyid", "secruleupdatetargetbytag", "secruleupdatetargetbymsg":
+		// We don't track which rule gets updated so we assume the worst case.
+		if args := splitArgs(opts); len(args) > 1 && hasRequestBodyVariable(args[1]) {
+			a.info.requestBody = true
+		}
+	}
+
+	return true
+}
+
+func (a *rulesetAnalyzer) addRule(variables, actions string) {
+	a.rules++
+	phase, chained := parseActions(actions)
+	if strings.Contains(strings.ToLower(actions), "ctl:requestbodyaccess=on") {
+		a.bodyAccess = true
+	}
+	if a.chainPhase != 0 {
+		phase = a.chainPhase
+	}
+
+	if chained {
+		a.chainPhase = phase
+	} else {
+		a.chainPhase = 0
+	}
+
+	if phase >= 3 {
+		a.info.responsePhases = true
+	}
+
+	// Body variables are available from phase 2 onwards, before that they
+	// are always empty.
+	if phase >= 2 && hasRequestBodyVariable(variables) {
+		a.info.requestBody = true
+	}
+}
+
+// hasRequestBodyVariable returns whether a rule variables list (e.g.
+// "REQUEST_HEADERS|!ARGS:id|&FILES") contains variables from the request body.
+func hasRequestBodyVariable(variables string) bool {
+	for _, v := range strings.Split(variables, "|") {
+		v = strings.TrimLeft(strings.TrimSpace(v), "!&")
+		name, _, _ := strings.Cut(v, ":")
+		if _, ok := requestBodyVariables[strings.ToUpper(name)]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *rulesetAnalyzer) include(path string, currentDir string) bool {
+	if a.includes >= maxIncludes {
+		return false
+	}
+	a.includes++
+
+	files := []string{path}
+	if strings.Contains(path, "*") {
+		var err error
+		if files, err = fs.Glob(a.root, path); err != nil {
+			return false
+		}
+	}
+
+	for _, f := range files {
+		f = strings.TrimSpace(f)
+		if !strings.HasPrefix(f, "/") {
+			f = filepath.Join(currentDir, f)
+		}
+
+		content, err := fs.ReadFile(a.root, f)
+		if err != nil {
+			return false
+		}
+
+		if !a.analyze(string(content), filepath.Dir(f)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseActions returns the phase a rule runs in given its actions, defaulting
+// to phase 2 as coraza does, and whether the rule starts a chain.
+func parseActions(actions string) (phase int, chained bool) {
+	phase = 2
+	for _, action := range strings.Split(actions, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(action), ":")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "chain":
+			chained = true
+		case "phase":
+			switch strings.ToLower(strings.Trim(strings.TrimSpace(value), `'"`)) {
+			case "1":
+				phase = 1
+			case "2", "request":
+				phase = 2
+			case "3":
+				phase = 3
+			case "4", "response":
+				phase = 4
+			case "5", "logging":
+				phase = 5
+			}
+		}
+	}
+
+	return
+}
+
+// splitArgs splits directive options into its arguments, honoring double
+// quoted arguments and escaped quotes within them.
+func splitArgs(opts string) []string {
+	var (
+		args    []string
+		current strings.Builder
+		quoted  bool
+		escaped bool
+	)
+
+	for i := 0; i < len(opts); i++ {
+		c := opts[i]
+		switch {
+		case escaped:
+			current.WriteByte(c)
+			escaped = false
+		case c == '\\' && quoted:
+			current.WriteByte(c)
+			escaped = true
+		case c == '"':
+			if quoted {
+				args = append(args, current.String())
+				current.Reset()
+			}
+			quoted = !quoted
+		case (c == ' ' || c == '\t') && !quoted:
+			if current.Len() > 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteByte(c)
+		}
+	}
+
+	if current.Len() > 0 {
+		args = append(args, current.String())
+	}
+
+	return args
+}
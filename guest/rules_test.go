@@ -0,0 +1,107 @@
+package guest
+
+import (
+	"testing"
+	"testing/fstest"
+
+	coreruleset "github.com/corazawaf/coraza-coreruleset/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeRuleset(t *testing.T) {
+	root := fstest.MapFS{
+		"rules/request.conf":  {Data: []byte("SecRule REQUEST_URI \"@rx .\" \"id:1,phase:1,deny\"")},
+		"rules/response.conf": {Data: []byte("SecRule RESPONSE_STATUS \"@streq 500\" \\\n\t\"id:2,phase:3,deny\"")},
+	}
+
+	tests := map[string]struct {
+		directives string
+		expected   rulesetInfo
+	}{
+		"no directives": {},
+		"request rules only": {
+			directives: "SecRule REQUEST_URI \"@rx .\" \"id:1,phase:1,deny\"\nSecRule ARGS \"@rx .\" \"id:2,deny\"",
+			expected:   rulesetInfo{requestBody: true},
+		},
+		"header rules only": {
+			directives: "SecRule ARGS_GET|REQUEST_HEADERS \"@rx .\" \"id:1,phase:2,deny\"",
+		},
+		"body variable in phase 1": {
+			directives: "SecRule &ARGS \"@gt 0\" \"id:1,phase:1,deny\"",
+		},
+		"excluded body variable": {
+			directives: "SecRule REQUEST_HEADERS|!ARGS_POST:id \"@rx .\" \"id:1,phase:2,deny\"",
+			expected:   rulesetInfo{requestBody: true},
+		},
+		"chained body rule": {
+			directives: "SecRule REQUEST_METHOD \"@streq POST\" \"id:1,phase:2,chain,deny\"\nSecRule REQUEST_BODY \"@rx .\" \"\"",
+			expected:   rulesetInfo{requestBody: true},
+		},
+		"updated target": {
+			directives: "SecRuleUpdateTargetById 1 \"XML:/*\"",
+			expected:   rulesetInfo{requestBody: true},
+		},
+		"response rule": {
+			directives: "SecRule RESPONSE_STATUS \"@streq 500\" \"id:1,phase:4,deny\"",
+			expected:   rulesetInfo{responsePhases: true},
+		},
+		"response phase alias": {
+			directives: "SecAction \"id:1,phase:response,pass\"",
+			expected:   rulesetInfo{responsePhases: true},
+		},
+		"logging rule": {
+			directives: "SecRule RESPONSE_STATUS \"@streq 500\" \"id:1,phase:5,log\"",
+			expected:   rulesetInfo{responsePhases: true},
+		},
+		"chained rule inherits phase": {
+			directives: "SecRule REQUEST_URI \"@rx .\" \"id:1,phase:1,chain,deny\"\nSecRule ARGS \"@rx .\" \"\"",
+		},
+		"audit engine": {
+			directives: "SecAuditEngine RelevantOnly",
+			expected:   allPhases,
+		},
+		"quoted phase in operator": {
+			directives: "SecRule REQUEST_URI \"@contains phase:3\" \"id:1,phase:2,deny\"",
+		},
+		"included request rules": {
+			directives: "Include rules/request.conf",
+		},
+		"included response rules": {
+			directives: "Include rules/*.conf",
+			expected:   rulesetInfo{responsePhases: true},
+		},
+		"body limit": {
+			directives: "SecRequestBodyAccess On\nSecRequestBodyLimit 100\nSecRequestBodyLimitAction Reject",
+			expected:   rulesetInfo{requestBodyLimit: 100},
+		},
+		"default body limit": {
+			directives: "SecRequestBodyAccess On",
+			expected:   rulesetInfo{requestBodyLimit: defaultRequestBodyLimit},
+		},
+		"body limit without body access": {
+			directives: "SecRequestBodyLimit 100",
+		},
+		"body limit with partial processing": {
+			directives: "SecRequestBodyAccess On\nSecRequestBodyLimit 100\nSecRequestBodyLimitAction ProcessPartial",
+		},
+		"missing include": {
+			directives: "Include rules/missing.conf",
+			expected:   allPhases,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.expected, analyzeRuleset(root, test.directives))
+		})
+	}
+
+	t.Run("CRS", func(t *testing.T) {
+		info := analyzeRuleset(coreruleset.FS, "Include @crs-setup.conf.example\nInclude @owasp_crs/REQUEST-*.conf")
+		require.False(t, info.responsePhases)
+		require.True(t, info.requestBody)
+
+		info = analyzeRuleset(coreruleset.FS, "Include @crs-setup.conf.example\nInclude @owasp_crs/*.conf")
+		require.True(t, info.responsePhases)
+	})
+}
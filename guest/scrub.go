@@ -0,0 +1,54 @@
+package guest
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// defaultScrubbedHeaders are the response headers identifying the upstream
+// software removed by default.
+var defaultScrubbedHeaders = []string{"Server", "X-Powered-By", "X-AspNet-Version", "X-AspNetMvc-Version"}
+
+// scrubConfig configures the response scrubbing, which removes what
+// identifies the upstream software, e.g. versions to look vulnerabilities up
+// for.
+type scrubConfig struct {
+	enabled bool
+	headers []string
+	// server replaces the Server header rather than removing it when set.
+	server string
+	// errorPages replaces the bodies of the upstream error responses, which
+	// often carry stack traces or framework banners, with the status text.
+	errorPages bool
+}
+
+// scrubResponse scrubs the response, once the WAF is done with it so that
+// the rules still see the original one. upstreamStatus is the status of the
+// response before the WAF handled it.
+func (e *engine) scrubResponse(tx types.Transaction, req api.Request, resp api.Response, upstreamStatus uint32) {
+	cfg := e.cfg.scrub
+	for _, h := range cfg.headers {
+		resp.Headers().Remove(h)
+	}
+	if cfg.server != "" {
+		resp.Headers().Set("Server", cfg.server)
+	}
+
+	if e.scrubsErrorPage(tx, req, upstreamStatus) {
+		status := int(resp.GetStatusCode())
+		resp.Headers().Set("Content-Type", "text/plain; charset=utf-8")
+		e.replaceResponseBody(resp, []byte(strconv.Itoa(status)+" "+http.StatusText(status)))
+	}
+}
+
+// scrubsErrorPage tells whether scrubResponse replaces the body of the
+// upstream response with the status text.
+func (e *engine) scrubsErrorPage(tx types.Transaction, req api.Request, upstreamStatus uint32) bool {
+	// Responses blocked by the WAF are not the upstream ones, and their body
+	// was already replaced.
+	return e.cfg.scrub.enabled && e.cfg.scrub.errorPages && e.features.IsEnabled(api.FeatureBufferResponse) &&
+		upstreamStatus >= 400 && !tx.IsInterrupted() && responseHasBody(req.GetMethod(), upstreamStatus)
+}
@@ -0,0 +1,41 @@
+package guest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubResponse(t *testing.T) {
+	respond := func(status uint32, body string) *mockResponse {
+		res := newMockResponse(status, body)
+		res.headers.Set("Server", "Apache/2.4.41 (Ubuntu)")
+		res.headers.Set("X-Powered-By", "PHP/7.4.3")
+		res.headers.Set("X-Request-Id", "1")
+		serve(newMockRequest("GET", "/", ""), res)
+		return res
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "scrubResponse": {}}`)
+
+		res := respond(500, "Fatal error: Uncaught Exception in /var/www/index.php:3")
+		require.Nil(t, res.headers.GetAll("Server"))
+		require.Nil(t, res.headers.GetAll("X-Powered-By"))
+		require.Equal(t, []string{"1"}, res.headers.GetAll("X-Request-Id"))
+		require.Empty(t, res.body.written, "error pages should be left untouched")
+	})
+
+	t.Run("rewrite and error pages", func(t *testing.T) {
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "scrubResponse": {"headers": ["X-Powered-By"], "server": "waf", "errorPages": true}}`)
+
+		res := respond(500, "Fatal error: Uncaught Exception in /var/www/index.php:3")
+		require.Equal(t, []string{"waf"}, res.headers.GetAll("Server"))
+		require.Nil(t, res.headers.GetAll("X-Powered-By"))
+		require.Equal(t, "500 Internal Server Error", string(res.body.written))
+		require.Equal(t, []string{"25"}, res.headers.GetAll("Content-Length"))
+
+		res = respond(200, "hello")
+		require.Empty(t, res.body.written)
+	})
+}
@@ -0,0 +1,133 @@
+package guest
+
+import (
+	"errors"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// newShadowWAF builds the WAF evaluating the candidate ruleset. Its verdict is
+// never enforced, hence its rules always run as with SecRuleEngine On so that
+// the requests it would block are known.
+func newShadowWAF(host api.Host, root fs.FS, directives string) (coraza.WAF, error) {
+	if host.LogEnabled(api.LogLevelDebug) {
+		host.Log(api.LogLevelDebug, "Initializing shadow WAF with directives:\n"+directives)
+	}
+
+	waf, err := coraza.NewWAF(coraza.NewWAFConfig().
+		WithRootFS(root).
+		WithDirectives(directives).
+		WithDirectives("SecRuleEngine On").
+		WithDebugLogger(debugLogger(host, nil)).
+		WithErrorCallback(shadowErrorCb(host)))
+	if err != nil {
+		return nil, errors.New("invalid shadow directives: " + err.Error())
+	}
+	return waf, nil
+}
+
+// shadowErrorCb logs the rules matched by the shadow WAF at debug level, as
+// they are not acted upon.
+func shadowErrorCb(host api.Host) func(types.MatchedRule) {
+	return func(mr types.MatchedRule) {
+		if host.LogEnabled(api.LogLevelDebug) {
+			host.Log(api.LogLevelDebug, "[shadow] "+mr.ErrorLog())
+		}
+	}
+}
+
+// shadowRequest evaluates the request phases of the shadow WAF with the data
+// read for tx, the primary transaction: the shadow only sees the request body
+// when it was read for tx. The shadow transaction is returned when keep is
+// set and its response phases are still to run, see shadowResponseHeaders,
+// otherwise it is finished here and nil is returned.
+func (e *engine) shadowRequest(tx types.Transaction, req api.Request, client string, cport int, keep bool) types.Transaction {
+	shadow := newTransaction(e.shadow)
+	shadow.ProcessConnection(client, cport, "", 0)
+	target := parseRequestTarget(req.GetMethod(), req.GetURI())
+	shadow.ProcessURI(target.uri, req.GetMethod(), req.GetProtocolVersion())
+	var sni string
+	if e.cfg.tlsPolicy.enabled {
+		sni = e.cfg.tlsPolicy.connectionTLS(req.Headers()).sni
+	}
+	addRequestHeaders(shadow, req.Headers(), target, sni)
+
+	if shadow.ProcessRequestHeaders() == nil {
+		if err := shadowRequestBody(tx, shadow); err != nil {
+			shadow.DebugLogger().Error().Err(err).Msg("Failed to process shadow request body")
+		}
+	}
+
+	if keep && !shadow.IsInterrupted() {
+		return shadow
+	}
+	finishShadow(e.host, tx, shadow)
+	return nil
+}
+
+func shadowRequestBody(tx, shadow types.Transaction) error {
+	if shadow.IsRequestBodyAccessible() {
+		body, err := tx.RequestBodyReader()
+		if err != nil {
+			return err
+		}
+		if it, _, err := shadow.ReadRequestBodyFrom(body); err != nil || it != nil {
+			return err
+		}
+	}
+
+	_, err := shadow.ProcessRequestBody()
+	return err
+}
+
+// shadowResponseHeaders evaluates the response headers phase of the shadow
+// WAF, it has to be called before the primary transaction changes the
+// response.
+func shadowResponseHeaders(shadow types.Transaction, req api.Request, resp api.Response) {
+	for _, h := range resp.Headers().Names() {
+		shadow.AddResponseHeader(h, strings.Join(resp.Headers().GetAll(h), ";"))
+	}
+	shadow.ProcessResponseHeaders(int(resp.GetStatusCode()), req.GetProtocolVersion())
+}
+
+// shadowResponseBody evaluates the response body phase of the shadow WAF
+// with the body read for tx, the primary transaction.
+func shadowResponseBody(tx, shadow types.Transaction) {
+	if shadow.IsInterrupted() || !shadow.IsResponseBodyAccessible() || !shadow.IsResponseBodyProcessable() {
+		return
+	}
+
+	body, err := tx.ResponseBodyReader()
+	if err == nil {
+		var it *types.Interruption
+		if it, _, err = shadow.ReadResponseBodyFrom(body); it == nil && err == nil {
+			_, err = shadow.ProcessResponseBody()
+		}
+	}
+	if err != nil {
+		shadow.DebugLogger().Error().Err(err).Msg("Failed to process shadow response body")
+	}
+}
+
+// finishShadow reports whether the verdicts of tx, the primary transaction,
+// and of its shadow diverge, then closes the shadow transaction.
+func finishShadow(host api.Host, tx, shadow types.Transaction) {
+	switch primary, candidate := tx.Interruption(), shadow.Interruption(); {
+	case primary == nil && candidate != nil:
+		metrics.shadowWouldBlock.Add(1)
+		host.Log(api.LogLevelInfo, "[shadow] Transaction "+tx.ID()+" would be blocked by rule "+strconv.Itoa(candidate.RuleID))
+	case primary != nil && candidate == nil:
+		metrics.shadowWouldAllow.Add(1)
+		host.Log(api.LogLevelInfo, "[shadow] Transaction "+tx.ID()+" blocked by rule "+strconv.Itoa(primary.RuleID)+" would be allowed")
+	}
+
+	shadow.ProcessLogging()
+	if err := shadow.Close(); err != nil {
+		shadow.DebugLogger().Error().Err(err).Msg("Failed to close the shadow transaction")
+	}
+}
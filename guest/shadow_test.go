@@ -0,0 +1,73 @@
+package guest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadow(t *testing.T) {
+	useEngine(t, `
+	{
+		"directives": [
+			"SecRuleEngine On",
+			"SecRequestBodyAccess On",
+			"SecResponseBodyAccess On",
+			"SecResponseBodyMimeType text/plain",
+			"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\""
+		],
+		"shadow": {
+			"directives": [
+				"SecRuleEngine DetectionOnly",
+				"SecRequestBodyAccess On",
+				"SecResponseBodyAccess On",
+				"SecResponseBodyMimeType text/plain",
+				"SecRule ARGS:id \"@eq 1\" \"id:101,phase:1,deny,status:403\"",
+				"SecRule ARGS_POST:q \"@contains evil\" \"id:102,phase:2,deny,status:403\"",
+				"SecRule RESPONSE_BODY \"@contains secret\" \"id:104,phase:4,deny,status:403\""
+			]
+		}
+	}
+	`)
+
+	tests := map[string]struct {
+		method, uri, body  string
+		respBody           string
+		expectedStatus     uint32
+		expectedWouldBlock uint64
+		expectedWouldAllow uint64
+	}{
+		"both allow":           {method: "GET", uri: "/", respBody: "hello", expectedStatus: 200},
+		"both block":           {method: "GET", uri: "/?id=0&id=1", respBody: "hello", expectedStatus: 403},
+		"shadow would allow":   {method: "GET", uri: "/?id=0", respBody: "hello", expectedStatus: 403, expectedWouldAllow: 1},
+		"shadow would block":   {method: "GET", uri: "/?id=1", respBody: "hello", expectedStatus: 200, expectedWouldBlock: 1},
+		"shadow request body":  {method: "POST", uri: "/", body: "q=evil", respBody: "hello", expectedStatus: 200, expectedWouldBlock: 1},
+		"shadow response body": {method: "GET", uri: "/", respBody: "secret", expectedStatus: 200, expectedWouldBlock: 1},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			wouldBlock, wouldAllow := metrics.shadowWouldBlock.Load(), metrics.shadowWouldAllow.Load()
+
+			req := newMockRequest(test.method, test.uri, test.body)
+			if test.body != "" {
+				req.headers.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			res := newMockResponse(200, test.respBody)
+			serve(req, res)
+
+			require.Equal(t, test.expectedStatus, res.statusCode)
+			require.Equal(t, test.expectedWouldBlock, metrics.shadowWouldBlock.Load()-wouldBlock)
+			require.Equal(t, test.expectedWouldAllow, metrics.shadowWouldAllow.Load()-wouldAllow)
+			require.Zero(t, txs.len())
+		})
+	}
+
+	t.Run("invalid shadow directives", func(t *testing.T) {
+		host := mockAPIHost{t: t, features: allFeatures, getConfig: func() []byte {
+			return []byte(`{"directives": ["SecRuleEngine On"], "shadow": {"directives": ["SecRule"]}}`)
+		}}
+		_, err := initializeWAF(host, overrides{})
+		require.ErrorContains(t, err, "invalid shadow directives")
+	})
+}
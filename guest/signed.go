@@ -0,0 +1,109 @@
+package guest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// maxSeenSignatures bounds the memory held by the replay detection.
+const maxSeenSignatures = 1 << 16
+
+// signedRequestsConfig configures the verification of signed requests, e.g.
+// webhooks: requests under paths have to carry the Unix time they were sent
+// at in timestampHeader and, in signatureHeader, the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, optionally prefixed with "sha256=".
+type signedRequestsConfig struct {
+	enabled         bool
+	paths           []string
+	secret          []byte
+	timestampHeader string
+	signatureHeader string
+	// maxSkew bounds the difference between the timestamp and the time the
+	// request is received at, signatures being remembered for that long to
+	// detect replays.
+	maxSkew time.Duration
+	// maxBodySize bounds the bodies read to be verified, larger ones are
+	// rejected.
+	maxBodySize int
+}
+
+// seenSignatures holds the signatures of the verified requests until their
+// timestamp gets too old to be accepted anyway, across engine reloads.
+var seenSignatures = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: map[string]time.Time{}}
+
+// verifySignedRequest reads the body, returning it along with whether it was
+// entirely read, and why the request fails the verification if it does.
+func (e *engine) verifySignedRequest(req api.Request, body bodyReader, now time.Time) ([]byte, bool, string) {
+	cfg := e.cfg.signedRequests
+	headers := req.Headers()
+
+	timestamp, _ := headers.Get(cfg.timestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, false, "missing or invalid timestamp"
+	}
+	sent := time.Unix(unix, 0)
+	if skew := now.Sub(sent); skew > cfg.maxSkew || skew < -cfg.maxSkew {
+		return nil, false, "timestamp outside the allowed skew"
+	}
+
+	signature, _ := headers.Get(cfg.signatureHeader)
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(expected) != sha256.Size {
+		return nil, false, "missing or invalid signature"
+	}
+
+	if !e.features.IsEnabled(api.FeatureBufferRequest) {
+		// Reading the body would consume it before reaching the upstream.
+		return nil, false, "body unavailable without request buffering"
+	}
+	content, complete := readBodyPrefix(body, cfg.maxBodySize)
+	if !complete || len(content) > cfg.maxBodySize {
+		return content, complete, "body too large to be verified"
+	}
+
+	mac := hmac.New(sha256.New, cfg.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(content)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return content, true, "signature mismatch"
+	}
+
+	if replayed(hex.EncodeToString(expected), sent.Add(cfg.maxSkew), now) {
+		return content, true, "replayed request"
+	}
+	return content, true, ""
+}
+
+// replayed tells whether the signature was already seen, remembering it
+// until otherwise.
+func replayed(signature string, until, now time.Time) bool {
+	seenSignatures.Lock()
+	defer seenSignatures.Unlock()
+	if seen, ok := seenSignatures.until[signature]; ok && now.Before(seen) {
+		return true
+	}
+
+	if len(seenSignatures.until) >= maxSeenSignatures {
+		for s, seen := range seenSignatures.until {
+			if !now.Before(seen) {
+				delete(seenSignatures.until, s)
+			}
+		}
+		if len(seenSignatures.until) >= maxSeenSignatures {
+			clear(seenSignatures.until)
+		}
+	}
+	seenSignatures.until[signature] = until
+	return false
+}
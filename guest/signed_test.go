@@ -0,0 +1,80 @@
+package guest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedRequests(t *testing.T) {
+	t.Cleanup(func() { clear(seenSignatures.until) })
+
+	const secret = "0123456789abcdef0123456789abcdef"
+	useEngine(t, `
+	{
+		"directives": [
+			"SecRuleEngine On",
+			"SecRequestBodyAccess On",
+			"SecRule ARGS_POST:q \"@contains evil\" \"id:1,phase:2,deny,status:403\""
+		],
+		"signedRequests": {"paths": ["/webhooks/"], "secret": "`+secret+`", "maxSkew": 60, "maxBodySize": 32}
+	}
+	`)
+
+	sign := func(timestamp, body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "." + body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	post := func(uri, body, timestamp, signature string) uint32 {
+		req := newMockRequest("POST", uri, body)
+		req.headers.Set("Content-Type", "application/x-www-form-urlencoded")
+		if timestamp != "" {
+			req.headers.Set("X-Signature-Timestamp", timestamp)
+		}
+		if signature != "" {
+			req.headers.Set("X-Signature", signature)
+		}
+		res := newMockResponse(200, "")
+		serve(req, res)
+		return res.statusCode
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+
+	tests := map[string]struct {
+		uri, body, timestamp, signature string
+		expectedStatus                  uint32
+	}{
+		"unsigned path":      {uri: "/", body: "q=hello", expectedStatus: 200},
+		"valid":              {uri: "/webhooks/a", body: "q=hello", timestamp: now, signature: sign(now, "q=hello"), expectedStatus: 200},
+		"prefixed":           {uri: "/webhooks/a", body: "q=hi", timestamp: now, signature: "sha256=" + sign(now, "q=hi"), expectedStatus: 200},
+		"body still checked": {uri: "/webhooks/a", body: "q=evil", timestamp: now, signature: sign(now, "q=evil"), expectedStatus: 403},
+		"missing signature":  {uri: "/webhooks/a", body: "q=hello", timestamp: now, expectedStatus: 401},
+		"missing timestamp":  {uri: "/webhooks/a", body: "q=hello", signature: sign(now, "q=hello"), expectedStatus: 401},
+		"tampered body":      {uri: "/webhooks/a", body: "q=hellO", timestamp: now, signature: sign(now, "q=hello"), expectedStatus: 401},
+		"expired":            {uri: "/webhooks/a", body: "q=hello", timestamp: old, signature: sign(old, "q=hello"), expectedStatus: 401},
+		"body too large":     {uri: "/webhooks/a", body: "q=" + string(make([]byte, 40)), timestamp: now, signature: sign(now, "q="+string(make([]byte, 40))), expectedStatus: 401},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.expectedStatus, post(test.uri, test.body, test.timestamp, test.signature))
+		})
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		failures := metrics.signatureFailures.Load()
+		signature := sign(now, "q=once")
+		require.Equal(t, uint32(200), post("/webhooks/a", "q=once", now, signature))
+		require.Equal(t, uint32(401), post("/webhooks/a", "q=once", now, signature))
+		require.Equal(t, uint64(1), metrics.signatureFailures.Load()-failures)
+	})
+}
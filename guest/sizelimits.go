@@ -0,0 +1,61 @@
+package guest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// requestLimitsConfig caps the size of the request line and headers, checked
+// before any transaction is created so that enormous inputs are rejected
+// without running the rules. Zero disables a limit.
+type requestLimitsConfig struct {
+	maxURILength int
+	// maxHeaderSize caps each header line, name and value.
+	maxHeaderSize int
+	// maxHeadersSize caps all the header lines together.
+	maxHeadersSize int
+}
+
+func (cfg requestLimitsConfig) enabled() bool {
+	return cfg.maxURILength > 0 || cfg.maxHeaderSize > 0 || cfg.maxHeadersSize > 0
+}
+
+// exceededRequestLimit returns the status code to answer the request with
+// when it exceeds a limit, zero otherwise.
+func (cfg requestLimitsConfig) exceededRequestLimit(req api.Request) uint32 {
+	if cfg.maxURILength > 0 && len(req.GetURI()) > cfg.maxURILength {
+		return http.StatusRequestURITooLong
+	}
+
+	if cfg.maxHeaderSize <= 0 && cfg.maxHeadersSize <= 0 {
+		return 0
+	}
+	total, tooLarge := 0, false
+	add := func(name, value string) {
+		size := len(name) + len(value)
+		tooLarge = tooLarge || cfg.maxHeaderSize > 0 && size > cfg.maxHeaderSize
+		total += size
+	}
+	headers := req.Headers()
+	for _, name := range headers.Names() {
+		if strings.EqualFold(name, "Host") {
+			continue
+		}
+		for _, value := range headers.GetAll(name) {
+			add(name, value)
+		}
+	}
+	// Host may not be in the names, see HandleRequest.
+	if host, ok := headers.Get("Host"); ok {
+		add("Host", host)
+	}
+	if tooLarge {
+		return http.StatusRequestHeaderFieldsTooLarge
+	}
+	if cfg.maxHeadersSize > 0 && total > cfg.maxHeadersSize {
+		return http.StatusRequestHeaderFieldsTooLarge
+	}
+	return 0
+}
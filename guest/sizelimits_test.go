@@ -0,0 +1,46 @@
+package guest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLimits(t *testing.T) {
+	useEngine(t, `
+	{
+		"directives": ["SecRuleEngine On"],
+		"requestLimits": {"maxURILength": 32, "maxHeaderSize": 64, "maxHeadersSize": 128}
+	}
+	`)
+
+	tests := map[string]struct {
+		uri            string
+		headers        map[string]string
+		expectedStatus uint32
+	}{
+		"within limits":     {uri: "/", headers: map[string]string{"Accept": "*/*"}, expectedStatus: 200},
+		"uri too long":      {uri: "/" + strings.Repeat("a", 32), expectedStatus: 414},
+		"header too large":  {uri: "/", headers: map[string]string{"Cookie": strings.Repeat("a", 64)}, expectedStatus: 431},
+		"headers too large": {uri: "/", headers: map[string]string{"A": strings.Repeat("a", 50), "B": strings.Repeat("b", 50), "C": strings.Repeat("c", 50)}, expectedStatus: 431},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			oversized := metrics.oversizedRequests.Load()
+
+			req := newMockRequest("GET", test.uri, "")
+			for k, v := range test.headers {
+				req.headers.Set(k, v)
+			}
+			res := newMockResponse(200, "")
+			serve(req, res)
+
+			require.Equal(t, test.expectedStatus, res.statusCode)
+			if test.expectedStatus != 200 {
+				require.Equal(t, uint64(1), metrics.oversizedRequests.Load()-oversized)
+			}
+		})
+	}
+}
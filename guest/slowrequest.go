@@ -0,0 +1,62 @@
+package guest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// Slow request actions, taken on request bodies trickling in.
+const (
+	// slowRequestActionBlock stops reading the body and answers with a 408.
+	slowRequestActionBlock = "block"
+	// slowRequestActionLog only counts and logs the request.
+	slowRequestActionLog = "log"
+)
+
+// slowRequestConfig configures the detection of slow-drip request bodies,
+// which hold buffering memory for as long as they take to arrive.
+type slowRequestConfig struct {
+	enabled bool
+	// minRate is the minimum average body rate, in bytes per second.
+	minRate float64
+	// gracePeriod is the time bodies are given before their rate is
+	// checked, so that the first segments aren't judged on their own.
+	gracePeriod time.Duration
+	action      string
+}
+
+// slowBody measures the rate a request body is read at since start. Once
+// the rate falls below the minimum, slow is set and, when stop is set,
+// reading stops as if the body ended.
+type slowBody struct {
+	body  bodyReader
+	cfg   slowRequestConfig
+	start time.Time
+	now   func() time.Time
+	stop  bool
+
+	read int
+	slow bool
+}
+
+func (b *slowBody) Read(p []byte) (uint32, bool) {
+	if b.slow && b.stop {
+		return 0, true
+	}
+
+	size, eof := b.body.Read(p)
+	b.read += int(size)
+	if !b.slow {
+		elapsed := b.now().Sub(b.start)
+		b.slow = elapsed > b.cfg.gracePeriod && float64(b.read)/elapsed.Seconds() < b.cfg.minRate
+	}
+	return size, eof || b.slow && b.stop
+}
+
+// serveSlowRequest answers the request whose body arrives too slowly.
+func serveSlowRequest(res api.Response) {
+	res.Headers().Set("Connection", "close")
+	res.SetStatusCode(http.StatusRequestTimeout)
+}
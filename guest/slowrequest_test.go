@@ -0,0 +1,92 @@
+package guest
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowBody(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	cfg := slowRequestConfig{enabled: true, minRate: 1024, gracePeriod: 5 * time.Second}
+
+	read := func(stop bool, elapsed ...time.Duration) (*slowBody, int) {
+		payload := bytes.Repeat([]byte("a"), len(elapsed)*bodySegmentSize)
+		mock := &mockBody{r: bytes.NewReader(payload)}
+		body := &slowBody{body: mock, cfg: cfg, start: start, stop: stop}
+		body.now = func() time.Time {
+			return start.Add(elapsed[mock.reads-1])
+		}
+		p := make([]byte, bodySegmentSize)
+		for {
+			if _, eof := body.Read(p); eof {
+				return body, mock.reads
+			}
+		}
+	}
+
+	t.Run("fast", func(t *testing.T) {
+		body, _ := read(true, time.Second, 2*time.Second, 3*time.Second)
+		require.False(t, body.slow)
+	})
+
+	t.Run("slow within the grace period", func(t *testing.T) {
+		body, _ := read(true, time.Second, 4*time.Second)
+		require.False(t, body.slow)
+	})
+
+	t.Run("slow", func(t *testing.T) {
+		body, reads := read(true, time.Second, 6*time.Second, 7*time.Minute, 8*time.Minute)
+		require.True(t, body.slow)
+		require.Equal(t, 3, reads, "reading should stop once the body is slow")
+	})
+
+	t.Run("slow without stopping", func(t *testing.T) {
+		body, reads := read(false, time.Second, 6*time.Second, 7*time.Minute, 8*time.Minute)
+		require.True(t, body.slow)
+		require.Equal(t, 4, reads)
+	})
+}
+
+func TestSlowRequests(t *testing.T) {
+	useSlowRequests := func(t *testing.T, action string) {
+		// Any body is below such a rate.
+		useEngine(t, `
+		{
+			"directives": [
+				"SecRuleEngine On",
+				"SecRequestBodyAccess On",
+				"SecRule ARGS_POST:q \"@contains evil\" \"id:1,phase:2,deny,status:403\""
+			],
+			"slowRequests": {"minRate": 1e15, "gracePeriod": 0, "action": "`+action+`"}
+		}
+		`)
+	}
+
+	post := func() *mockResponse {
+		req := newMockRequest("POST", "/", "q=hello")
+		req.headers.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := newMockResponse(200, "")
+		serve(req, res)
+		return res
+	}
+
+	t.Run("block", func(t *testing.T) {
+		useSlowRequests(t, "block")
+
+		slow := metrics.slowRequests.Load()
+		require.Equal(t, uint32(408), post().statusCode)
+		require.Equal(t, uint64(1), metrics.slowRequests.Load()-slow)
+		require.Zero(t, txs.len())
+	})
+
+	t.Run("log", func(t *testing.T) {
+		useSlowRequests(t, "log")
+
+		slow := metrics.slowRequests.Load()
+		require.Equal(t, uint32(200), post().statusCode)
+		require.Equal(t, uint64(1), metrics.slowRequests.Load()-slow)
+	})
+}
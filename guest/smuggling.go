@@ -0,0 +1,90 @@
+package guest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// requestSmugglingReason returns why the request framing or routing is
+// ambiguous, or an empty string when it isn't. Smuggling relies on the host
+// and the upstream disagreeing on where a request ends or which host it is
+// for, which content rules never see, hence these checks run before the rule
+// phases.
+func requestSmugglingReason(req api.Request) string {
+	headers := req.Headers()
+	contentLengths := headers.GetAll("Content-Length")
+	transferEncodings := headers.GetAll("Transfer-Encoding")
+	hosts := headers.GetAll("Host")
+
+	switch {
+	case len(contentLengths) > 0 && len(transferEncodings) > 0:
+		return "both Content-Length and Transfer-Encoding"
+	case len(contentLengths) > 1:
+		return "duplicate Content-Length"
+	case len(contentLengths) == 1 && !validContentLength(contentLengths[0]):
+		return "invalid Content-Length"
+	case len(transferEncodings) > 1 || len(transferEncodings) == 1 && transferEncodings[0] != "chunked":
+		// Anything but a plain chunked coding may be ignored or parsed
+		// differently by the upstream, e.g. "chunked, identity" or
+		// " chunked".
+		return "unsupported Transfer-Encoding"
+	case len(hosts) > 1:
+		// Hosts and upstreams may route on different ones.
+		return "duplicate Host"
+	case len(hosts) == 0 && req.GetProtocolVersion() == "HTTP/1.1":
+		return "missing Host"
+	}
+
+	return absoluteFormAnomaly(req.GetMethod(), req.GetURI(), headers)
+}
+
+func validContentLength(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// absoluteFormAnomaly checks the request target: an absolute-form target is
+// only expected towards proxies, as is the authority-form target of CONNECT
+// requests, and their authority has to agree with the Host header as some
+// servers route on one and others on the other.
+func absoluteFormAnomaly(method, uri string, headers api.Header) string {
+	authority := uri
+	switch {
+	case method == http.MethodConnect:
+		if uri == "" || strings.ContainsAny(uri, "/?#") {
+			return "invalid request target"
+		}
+	case strings.HasPrefix(uri, "/") || uri == "*":
+		return ""
+	default:
+		scheme, _, ok := strings.Cut(uri, "://")
+		if !ok {
+			return "invalid request target"
+		}
+		if scheme = strings.ToLower(scheme); scheme != "http" && scheme != "https" {
+			return "invalid request target scheme"
+		}
+		authority = parseRequestTarget(method, uri).authority
+	}
+	if host, ok := headers.Get("Host"); ok && !strings.EqualFold(host, authority) {
+		return "request target authority and Host header differ"
+	}
+	return ""
+}
+
+// serveProtocolViolation answers the request rejected by the protocol
+// checks, the reason being given to the client.
+func serveProtocolViolation(res api.Response, reason string) {
+	res.Headers().Set("Content-Type", "text/plain")
+	res.SetStatusCode(http.StatusBadRequest)
+	res.Body().WriteString("Bad request: " + reason)
+}
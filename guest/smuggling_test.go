@@ -0,0 +1,85 @@
+package guest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestSmugglingReason(t *testing.T) {
+	tests := map[string]struct {
+		method         string
+		uri            string
+		proto          string
+		headers        mockHeader
+		expectedReason string
+	}{
+		"plain":                   {uri: "/", headers: mockHeader{"Host": {"localhost"}, "Content-Length": {"3"}}},
+		"chunked":                 {uri: "/", headers: mockHeader{"Host": {"localhost"}, "Transfer-Encoding": {"chunked"}}},
+		"asterisk form":           {uri: "*"},
+		"absolute form":           {uri: "http://localhost/a?b=c", headers: mockHeader{"Host": {"localhost"}}},
+		"CL and TE":               {uri: "/", headers: mockHeader{"Content-Length": {"3"}, "Transfer-Encoding": {"chunked"}}, expectedReason: "both Content-Length and Transfer-Encoding"},
+		"duplicate CL":            {uri: "/", headers: mockHeader{"Content-Length": {"3", "3"}}, expectedReason: "duplicate Content-Length"},
+		"invalid CL":              {uri: "/", headers: mockHeader{"Content-Length": {"+3"}}, expectedReason: "invalid Content-Length"},
+		"obfuscated TE":           {uri: "/", headers: mockHeader{"Transfer-Encoding": {"chunked, identity"}}, expectedReason: "unsupported Transfer-Encoding"},
+		"duplicate TE":            {uri: "/", headers: mockHeader{"Transfer-Encoding": {"chunked", "chunked"}}, expectedReason: "unsupported Transfer-Encoding"},
+		"relative target":         {uri: "a/b", expectedReason: "invalid request target"},
+		"unknown scheme":          {uri: "gopher://localhost/", expectedReason: "invalid request target scheme"},
+		"authority and Host diff": {uri: "http://internal/admin", headers: mockHeader{"Host": {"localhost"}}, expectedReason: "request target authority and Host header differ"},
+		"absolute form fragment":  {uri: "http://localhost#/admin", headers: mockHeader{"Host": {"localhost"}}},
+		"authority form":          {method: "CONNECT", uri: "localhost:443", headers: mockHeader{"Host": {"localhost:443"}}},
+		"CONNECT origin form":     {method: "CONNECT", uri: "/", expectedReason: "invalid request target"},
+		"CONNECT Host diff":       {method: "CONNECT", uri: "internal:443", headers: mockHeader{"Host": {"localhost:443"}}, expectedReason: "request target authority and Host header differ"},
+		"duplicate Host":          {uri: "/", headers: mockHeader{"Host": {"localhost", "internal"}}, expectedReason: "duplicate Host"},
+		"missing Host":            {uri: "/", headers: mockHeader{}, expectedReason: "missing Host"},
+		"HTTP/1.0 without Host":   {uri: "/", proto: "HTTP/1.0", headers: mockHeader{}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = "POST"
+			}
+			req := newMockRequest(method, test.uri, "")
+			if test.proto != "" {
+				req.proto = test.proto
+			}
+			if test.headers != nil {
+				req.headers = test.headers
+			}
+			require.Equal(t, test.expectedReason, requestSmugglingReason(req))
+		})
+	}
+}
+
+func TestProtocolChecks(t *testing.T) {
+	smuggling := func() *mockResponse {
+		req := newMockRequest("POST", "/", "")
+		req.headers.Set("Content-Length", "3")
+		req.headers.Set("Transfer-Encoding", "chunked")
+		res := newMockResponse(200, "")
+		serve(req, res)
+		return res
+	}
+
+	t.Run("enabled by default", func(t *testing.T) {
+		useEngine(t, `{"directives": ["SecRuleEngine On"]}`)
+
+		violations := metrics.protocolViolations.Load()
+		res := smuggling()
+		require.Equal(t, uint32(400), res.statusCode)
+		require.Equal(t, "Bad request: both Content-Length and Transfer-Encoding", string(res.body.written))
+		require.Equal(t, uint64(1), metrics.protocolViolations.Load()-violations)
+	})
+
+	t.Run("detect mode", func(t *testing.T) {
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "mode": "detect"}`)
+		require.Equal(t, uint32(200), smuggling().statusCode)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		useEngine(t, `{"directives": ["SecRuleEngine On"], "protocolChecks": false}`)
+		require.Equal(t, uint32(200), smuggling().statusCode)
+	})
+}
@@ -0,0 +1,109 @@
+package guest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// TX variables holding the TLS connection info, see setTLSInfo.
+const (
+	tlsVersionVariable = "tls_version"
+	tlsCipherVariable  = "tls_cipher"
+	tlsSNIVariable     = "tls_sni"
+)
+
+// tlsPolicyConfig configures the TLS policy. The http-wasm ABI gives no
+// access to the connection, hence the TLS info is read from headers the host
+// sets, e.g. with Traefik's or Envoy's header rewriting, and which it has to
+// strip from the incoming requests.
+type tlsPolicyConfig struct {
+	enabled       bool
+	versionHeader string
+	cipherHeader  string
+	sniHeader     string
+	// minVersion is the minimum TLS version in hundredths, e.g. 12 for TLS
+	// 1.2, requests without TLS being rejected as well when set.
+	minVersion int
+	// sniMatch requires the SNI to match the Host header, as a client may
+	// otherwise reach a virtual host other than the one it negotiated TLS
+	// for.
+	sniMatch bool
+}
+
+// tlsInfo is the TLS info of the connection, empty without TLS.
+type tlsInfo struct {
+	version string
+	cipher  string
+	sni     string
+}
+
+func (cfg tlsPolicyConfig) connectionTLS(headers api.Header) tlsInfo {
+	var info tlsInfo
+	for _, h := range []struct {
+		name  string
+		value *string
+	}{
+		{cfg.versionHeader, &info.version},
+		{cfg.cipherHeader, &info.cipher},
+		{cfg.sniHeader, &info.sni},
+	} {
+		if h.name != "" {
+			*h.value, _ = headers.Get(h.name)
+		}
+	}
+	return info
+}
+
+// tlsViolation returns the status code to answer the request with and why
+// when the connection doesn't comply with the policy, zero otherwise.
+func (cfg tlsPolicyConfig) tlsViolation(info tlsInfo, headers api.Header) (uint32, string) {
+	if cfg.minVersion > 0 {
+		if info.version == "" {
+			return http.StatusForbidden, "TLS required"
+		}
+		if v, ok := parseTLSVersion(info.version); !ok || v < cfg.minVersion {
+			return http.StatusForbidden, "TLS version " + info.version + " below the minimum"
+		}
+	}
+
+	if cfg.sniMatch && info.sni != "" {
+		host, _ := headers.Get("Host")
+		if !strings.EqualFold(stripPort(host), info.sni) {
+			// 421 tells the client to retry on a connection for that host.
+			return http.StatusMisdirectedRequest, "SNI " + info.sni + " and Host " + host + " differ"
+		}
+	}
+	return 0, ""
+}
+
+// parseTLSVersion parses versions such as "1.2", "TLSv1.2" or "TLS 1.2" into
+// hundredths.
+func parseTLSVersion(version string) (int, bool) {
+	version = strings.TrimPrefix(strings.ToUpper(version), "TLS")
+	version = strings.TrimLeft(version, "V ")
+	if len(version) != 3 || version[0] != '1' || version[1] != '.' || version[2] < '0' || version[2] > '3' {
+		return 0, false
+	}
+	return 10 + int(version[2]-'0'), true
+}
+
+// setTLSInfo exposes the TLS info to the rules as TX variables.
+func setTLSInfo(tx types.Transaction, info tlsInfo) {
+	state, ok := tx.(plugintypes.TransactionState)
+	if !ok {
+		return
+	}
+	for name, value := range map[string]string{
+		tlsVersionVariable: info.version,
+		tlsCipherVariable:  info.cipher,
+		tlsSNIVariable:     info.sni,
+	} {
+		if value != "" {
+			state.Variables().TX().Set(name, []string{value})
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package guest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	for version, expected := range map[string]int{"1.2": 12, "TLSv1.3": 13, "TLS 1.0": 10, "tlsv1.1": 11, "SSLv3": 0, "1.4": 0, "": 0} {
+		v, ok := parseTLSVersion(version)
+		require.Equal(t, expected, v, version)
+		require.Equal(t, expected != 0, ok, version)
+	}
+}
+
+func TestTLSPolicy(t *testing.T) {
+	useEngine(t, `
+	{
+		"directives": [
+			"SecRuleEngine On",
+			"SecRule TX:tls_cipher \"@contains RC4\" \"id:1,phase:1,deny,status:403\""
+		],
+		"tls": {
+			"versionHeader": "X-Tls-Version",
+			"cipherHeader": "X-Tls-Cipher",
+			"sniHeader": "X-Tls-Sni",
+			"minVersion": "1.2",
+			"sniMatch": true
+		}
+	}
+	`)
+
+	tests := map[string]struct {
+		headers        map[string]string
+		expectedStatus uint32
+	}{
+		"compliant":      {headers: map[string]string{"X-Tls-Version": "TLSv1.3", "X-Tls-Sni": "localhost"}, expectedStatus: 200},
+		"host with port": {headers: map[string]string{"X-Tls-Version": "TLSv1.2", "X-Tls-Sni": "localhost", "Host": "localhost:8443"}, expectedStatus: 200},
+		"no TLS":         {expectedStatus: 403},
+		"old version":    {headers: map[string]string{"X-Tls-Version": "TLSv1.1"}, expectedStatus: 403},
+		"SNI mismatch":   {headers: map[string]string{"X-Tls-Version": "TLSv1.3", "X-Tls-Sni": "other.example"}, expectedStatus: 421},
+		"rule on cipher": {headers: map[string]string{"X-Tls-Version": "TLSv1.2", "X-Tls-Cipher": "TLS_RSA_WITH_RC4_128_SHA"}, expectedStatus: 403},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := newMockRequest("GET", "/", "")
+			for k, v := range test.headers {
+				req.headers.Set(k, v)
+			}
+			res := newMockResponse(200, "")
+			serve(req, res)
+			require.Equal(t, test.expectedStatus, res.statusCode)
+		})
+	}
+}
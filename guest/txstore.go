@@ -0,0 +1,189 @@
+package guest
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+)
+
+const (
+	// txSlotBits is the number of bits of the request context used for the
+	// slot index, the remaining ones hold the slot generation.
+	txSlotBits = 12
+	// txStoreSize is the maximum number of in-flight transactions.
+	txStoreSize     = 1 << txSlotBits
+	txSlotMask      = txStoreSize - 1
+	maxTxGeneration = 1<<(32-txSlotBits) - 1
+)
+
+// txStore keeps the transactions between HandleRequest and HandleResponse in
+// a fixed number of slots. The request context handed over to the host
+// encodes both the slot index and its generation, hence a lookup is a slice
+// access and a stale context can't retrieve a newer transaction stored in the
+// same slot.
+type txStore struct {
+	mu    sync.Mutex
+	slots [txStoreSize]txSlot
+	// free is a stack with the indexes of the unused slots.
+	free []uint32
+}
+
+type txSlot struct {
+	generation uint32
+	inflight
+}
+
+// inflight is a transaction waiting for its response, along with the one of
+// the shadow WAF if any, see shadowRequest.
+type inflight struct {
+	tx     types.Transaction
+	shadow types.Transaction
+	// canary is true when tx belongs to the canary WAF.
+	canary bool
+	// bypassed is true when the request carries a valid bypass token.
+	bypassed bool
+	// dump is set when tx is dumped for debugging, see debugDump.
+	dump *txDump
+	// started is when the request was handed over to the upstream, see
+	// reap.
+	started time.Time
+}
+
+func newTxStore() *txStore {
+	s := &txStore{free: make([]uint32, txStoreSize)}
+	for i := range s.free {
+		// Slots are handed out from the end of the stack, we reverse them
+		// so that the lowest indexes are used first.
+		s.free[i] = uint32(txStoreSize - 1 - i)
+	}
+	return s
+}
+
+// put stores the transactions and returns the request context to retrieve
+// them. It returns false when all slots are in use. The returned context is
+// never zero as generations start at one.
+func (s *txStore) put(in inflight) (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.free) == 0 {
+		return 0, false
+	}
+
+	idx := s.free[len(s.free)-1]
+	s.free = s.free[:len(s.free)-1]
+
+	slot := &s.slots[idx]
+	if slot.generation == maxTxGeneration {
+		slot.generation = 1
+	} else {
+		slot.generation++
+	}
+	slot.inflight = in
+
+	return slot.generation<<txSlotBits | idx, true
+}
+
+// take removes the transactions for the given request context from the store
+// and returns them.
+func (s *txStore) take(reqCtx uint32) (inflight, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := reqCtx & txSlotMask
+	slot := &s.slots[idx]
+	if slot.tx == nil || slot.generation != reqCtx>>txSlotBits {
+		return inflight{}, false
+	}
+
+	in := slot.inflight
+	slot.inflight = inflight{}
+	s.free = append(s.free, idx)
+	return in, true
+}
+
+// reap removes the transactions handed over to the upstream before the given
+// time from the store and returns them. The host never invokes the response
+// handler for those, e.g. when it aborted the request, and HandleResponse
+// passes the response through, logging it, if it eventually does.
+func (s *txStore) reap(before time.Time) []inflight {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reaped []inflight
+	for idx := range s.slots {
+		slot := &s.slots[idx]
+		if slot.tx == nil || !slot.started.Before(before) {
+			continue
+		}
+		reaped = append(reaped, slot.inflight)
+		slot.inflight = inflight{}
+		s.free = append(s.free, uint32(idx))
+	}
+	return reaped
+}
+
+// len returns the number of in-flight transactions. Any transaction left
+// in the store once the host stops sending requests has been leaked.
+func (s *txStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return txStoreSize - len(s.free)
+}
+
+// defaultInflightTimeout is how long a transaction waits for its response
+// unless configured otherwise, see reapInflight.
+const defaultInflightTimeout = 10 * time.Minute
+
+// txAbortedVar is set to 1 on the transactions closed without their
+// response, so that phase 5 rules and the audit log can tell them apart.
+const txAbortedVar = "aborted"
+
+// maxReapInterval bounds the time between reaps, so that a transaction
+// doesn't outlive inflightTimeout by much more.
+const maxReapInterval = time.Second
+
+// inflightNextReap holds the time of the next reap, in Unix nanoseconds.
+var inflightNextReap atomic.Int64
+
+// reapInflight closes the transactions waiting for their response for longer
+// than inflightTimeout, checking at most once per maxReapInterval. The host
+// may never invoke the response handler of a request it let through, e.g.
+// when the client went away or the upstream timed out without the host
+// reporting an error, and those would otherwise hold a slot and their memory
+// forever.
+func (e *engine) reapInflight(now time.Time) {
+	timeout := e.cfg.inflightTimeout
+	if timeout <= 0 {
+		return
+	}
+
+	next := inflightNextReap.Load()
+	if now.UnixNano() < next || !inflightNextReap.CompareAndSwap(next, now.Add(min(timeout, maxReapInterval)).UnixNano()) {
+		return
+	}
+
+	for _, in := range txs.reap(now.Add(-timeout)) {
+		metrics.abortedTransactions.Add(1)
+		tx := in.tx
+		tx.DebugLogger().Info().Msg("Response never received, closing the transaction as aborted")
+		if state, ok := tx.(plugintypes.TransactionState); ok {
+			state.Variables().TX().Set(txAbortedVar, []string{"1"})
+		}
+		if in.shadow != nil {
+			finishShadow(e.host, tx, in.shadow)
+		}
+
+		start := in.dump.start()
+		tx.ProcessLogging()
+		in.dump.done("logging", start)
+		e.dumpTransaction(tx, in.dump)
+		if err := tx.Close(); err != nil {
+			tx.DebugLogger().Error().Err(err).Msg("Failed to close the transaction")
+		}
+	}
+}
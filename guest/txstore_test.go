@@ -0,0 +1,168 @@
+package guest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corazawaf/coraza/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxStore(t *testing.T) {
+	waf, err := coraza.NewWAF(coraza.NewWAFConfig())
+	require.NoError(t, err)
+
+	t.Run("put and take", func(t *testing.T) {
+		s := newTxStore()
+		tx := waf.NewTransaction()
+
+		shadow := waf.NewTransaction()
+
+		reqCtx, ok := s.put(inflight{tx: tx, shadow: shadow})
+		require.True(t, ok)
+		require.NotZero(t, reqCtx)
+		require.Equal(t, 1, s.len())
+
+		stored, ok := s.take(reqCtx)
+		require.True(t, ok)
+		require.Equal(t, tx, stored.tx)
+		require.Equal(t, shadow, stored.shadow)
+		require.Zero(t, s.len())
+
+		_, ok = s.take(reqCtx)
+		require.False(t, ok)
+	})
+
+	t.Run("stale context", func(t *testing.T) {
+		s := newTxStore()
+
+		staleCtx, _ := s.put(inflight{tx: waf.NewTransaction()})
+		_, _ = s.take(staleCtx)
+
+		reqCtx, ok := s.put(inflight{tx: waf.NewTransaction()})
+		require.True(t, ok)
+		require.Equal(t, staleCtx&txSlotMask, reqCtx&txSlotMask, "slot should be reused")
+		require.NotEqual(t, staleCtx, reqCtx)
+
+		_, ok = s.take(staleCtx)
+		require.False(t, ok)
+		require.Equal(t, 1, s.len())
+	})
+
+	t.Run("unknown context", func(t *testing.T) {
+		s := newTxStore()
+		_, ok := s.take(0)
+		require.False(t, ok)
+		_, ok = s.take(1<<txSlotBits | 5)
+		require.False(t, ok)
+	})
+
+	t.Run("full", func(t *testing.T) {
+		s := newTxStore()
+		tx := waf.NewTransaction()
+		for i := 0; i < txStoreSize; i++ {
+			_, ok := s.put(inflight{tx: tx})
+			require.True(t, ok)
+		}
+
+		_, ok := s.put(inflight{tx: tx})
+		require.False(t, ok)
+		require.Equal(t, txStoreSize, s.len())
+	})
+
+	t.Run("reap", func(t *testing.T) {
+		s := newTxStore()
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		oldCtx, _ := s.put(inflight{tx: waf.NewTransaction(), started: start})
+		newCtx, _ := s.put(inflight{tx: waf.NewTransaction(), started: start.Add(time.Minute)})
+
+		reaped := s.reap(start.Add(time.Second))
+		require.Len(t, reaped, 1)
+		require.Equal(t, 1, s.len())
+
+		_, ok := s.take(oldCtx)
+		require.False(t, ok, "a reaped transaction should not be taken")
+		_, ok = s.take(newCtx)
+		require.True(t, ok)
+		require.Empty(t, s.reap(start.Add(time.Hour)))
+	})
+
+	t.Run("generation wraps", func(t *testing.T) {
+		s := newTxStore()
+		s.slots[0].generation = maxTxGeneration
+
+		reqCtx, ok := s.put(inflight{tx: waf.NewTransaction()})
+		require.True(t, ok)
+		require.Equal(t, uint32(1<<txSlotBits), reqCtx)
+	})
+}
+
+func TestAbortedTransactions(t *testing.T) {
+	clk := useDeterministicHandlers(t)
+	inflightNextReap.Store(0)
+
+	// The rules log through the host the engine is initialized with, hence
+	// useEngine can't be used.
+	var logs bytes.Buffer
+	host := recordingHost{mockAPIHost: mockAPIHost{t: t, features: allFeatures, getConfig: func() []byte {
+		return []byte(`{"inflightTimeout": 60, "directives": [
+			"SecRuleEngine On",
+			"SecRule RESPONSE_STATUS \"@streq 500\" \"id:1,phase:3,deny,status:403\"",
+			"SecRule TX:aborted \"@eq 1\" \"id:2,phase:5,pass,log,msg:'Aborted transaction'\""
+		]}`)
+	}}, logs: &logs}
+	e, err := initializeWAF(host, overrides{})
+	require.NoError(t, err)
+	e.features = negotiateFeatures(host, e.ruleset)
+	previous := activeEngine.Swap(e)
+	t.Cleanup(func() { activeEngine.Store(previous) })
+	aborted := metrics.abortedTransactions.Load()
+
+	// The host never invokes the response handler for this request.
+	req := newMockRequest("GET", "/", "")
+	next, reqCtx := HandleRequest(req, newMockResponse(200, ""))
+	require.True(t, next)
+	require.NotZero(t, reqCtx)
+
+	clk.Advance(30 * time.Second)
+	serve(newMockRequest("GET", "/", ""), newMockResponse(200, ""))
+	require.Equal(t, 1, txs.len(), "the transaction should wait for the timeout")
+	require.NotContains(t, logs.String(), `[msg "Aborted transaction"]`)
+
+	clk.Advance(31 * time.Second)
+	next, lastCtx := HandleRequest(newMockRequest("GET", "/", ""), newMockResponse(200, ""))
+	require.True(t, next)
+	require.Equal(t, 1, txs.len(), "only the request being served should be in flight")
+	require.Equal(t, aborted+1, metrics.abortedTransactions.Load())
+	require.Equal(t, 1, strings.Count(logs.String(), `[msg "Aborted transaction"]`))
+	require.Contains(t, logs.String(), `[unique_id "tx-1"]`)
+
+	// A late response for the aborted transaction can't be inspected, it
+	// is logged and counted.
+	late := metrics.lateResponses.Load()
+	res := newMockResponse(500, "")
+	HandleResponse(reqCtx, req, res, false)
+	require.Equal(t, uint32(500), res.statusCode)
+	require.Equal(t, late+1, metrics.lateResponses.Load())
+	require.Contains(t, logs.String(), "Response 500 for / received once its transaction was closed")
+
+	HandleResponse(lastCtx, req, newMockResponse(200, ""), false)
+	require.Zero(t, txs.len())
+	require.Equal(t, 1, strings.Count(logs.String(), `[msg "Aborted transaction"]`))
+
+	// The timeout is checked every second at most rather than once per
+	// timeout, so that a transaction started right after a check doesn't
+	// wait for almost twice the timeout.
+	clk.Advance(time.Second)
+	HandleRequest(newMockRequest("GET", "/", ""), newMockResponse(200, ""))
+	clk.Advance(59 * time.Second)
+	serve(newMockRequest("GET", "/", ""), newMockResponse(200, ""))
+	require.Equal(t, 1, txs.len())
+	clk.Advance(2 * time.Second)
+	serve(newMockRequest("GET", "/", ""), newMockResponse(200, ""))
+	require.Zero(t, txs.len())
+	require.Equal(t, aborted+2, metrics.abortedTransactions.Load())
+}
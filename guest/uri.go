@@ -0,0 +1,151 @@
+package guest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// requestTarget is the request target given by the host, which hosts deliver
+// in different shapes, normalized so that the rules see the same variables
+// whatever the host.
+type requestTarget struct {
+	// uri is passed to ProcessURI: the path and query of the target in
+	// origin form, "*", or the authority of a CONNECT request.
+	uri string
+	// authority is the authority of an absolute-form target or of a CONNECT
+	// request, empty otherwise.
+	authority string
+}
+
+// parseRequestTarget normalizes the target of a request:
+//   - absolute-form targets, sent to proxies, are reduced to their path and
+//     query, the authority being kept apart.
+//   - fragments, which clients should not send, are dropped.
+//   - leading slashes are merged, "//a/b" being otherwise parsed as the path
+//     "/b" of the authority "a".
+//   - invalid percent-encodings and control characters are escaped, as
+//     Coraza would otherwise fail to parse the target and extract no query
+//     arguments, letting any payload in them through.
+func parseRequestTarget(method, target string) requestTarget {
+	if method == http.MethodConnect {
+		return requestTarget{uri: target, authority: target}
+	}
+
+	target, _, _ = strings.Cut(target, "#")
+	if target == "*" {
+		return requestTarget{uri: target}
+	}
+
+	var authority string
+	if !strings.HasPrefix(target, "/") {
+		if scheme, rest, ok := strings.Cut(target, "://"); ok && validScheme(scheme) {
+			end := strings.IndexAny(rest, "/?")
+			if end == -1 {
+				end = len(rest)
+			}
+			authority, target = rest[:end], rest[end:]
+		}
+		target = "/" + target
+	}
+	if strings.HasPrefix(target, "//") {
+		target = "/" + strings.TrimLeft(target, "/")
+	}
+	return requestTarget{uri: escapeTarget(target), authority: authority}
+}
+
+// path returns the path of the target, without the query.
+func (t requestTarget) path() string {
+	path, _, _ := strings.Cut(t.uri, "?")
+	return path
+}
+
+// serverName returns the host the request is for, lowercased and without
+// port, from the first of:
+//   - the authority of the request, given by HTTP/2 and HTTP/3 hosts as the
+//     :authority pseudo-header and in the target of absolute-form requests,
+//     the Host header having then to be ignored (RFC 9112 section 3.2.2).
+//   - sni, the SNI given by the host, see tlsPolicyConfig.
+//   - the first Host header, the protocol checks rejecting the requests with
+//     several.
+func serverName(headers api.Header, target requestTarget, sni string) string {
+	host, _ := headers.Get(":authority")
+	if host == "" {
+		host = target.authority
+	}
+	if host == "" {
+		host = sni
+	}
+	if host == "" {
+		host, _ = headers.Get("Host")
+	}
+	return strings.ToLower(stripPort(host))
+}
+
+// stripPort returns the host of an authority, without userinfo, port nor the
+// brackets of IPv6 literals, e.g. "2001:db8::1" for "[2001:db8::1]:8080".
+func stripPort(authority string) string {
+	if i := strings.LastIndexByte(authority, '@'); i != -1 {
+		authority = authority[i+1:]
+	}
+	if strings.HasPrefix(authority, "[") {
+		if end := strings.IndexByte(authority, ']'); end != -1 {
+			return authority[1:end]
+		}
+		return authority
+	}
+	if i := strings.LastIndexByte(authority, ':'); i != -1 && strings.IndexByte(authority, ':') == i {
+		return authority[:i]
+	}
+	return authority
+}
+
+// validScheme tells whether scheme is a URI scheme, see RFC 3986 section 3.1.
+func validScheme(scheme string) bool {
+	if scheme == "" {
+		return false
+	}
+	for i := 0; i < len(scheme); i++ {
+		c := scheme[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// escapeTarget percent-encodes the '%' not starting a valid percent-encoding
+// and the control characters of target, returning it as is when there are
+// none.
+func escapeTarget(target string) string {
+	var b strings.Builder
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		escape := c < 0x20 || c == 0x7f || c == '%' && (i+2 >= len(target) || !isHex(target[i+1]) || !isHex(target[i+2]))
+		if !escape {
+			if b.Len() > 0 {
+				b.WriteByte(c)
+			}
+			continue
+		}
+		if b.Len() == 0 {
+			b.Grow(len(target) + 8)
+			b.WriteString(target[:i])
+		}
+		b.WriteByte('%')
+		b.WriteByte("0123456789ABCDEF"[c>>4])
+		b.WriteByte("0123456789ABCDEF"[c&0xf])
+	}
+	if b.Len() == 0 {
+		return target
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,146 @@
+package guest
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequestTarget(t *testing.T) {
+	tests := map[string]struct {
+		method, target    string
+		expectedURI       string
+		expectedAuthority string
+	}{
+		"origin form":                {target: "/a/b?c=d", expectedURI: "/a/b?c=d"},
+		"asterisk form":              {method: "OPTIONS", target: "*", expectedURI: "*"},
+		"absolute form":              {target: "http://example.com/a?c=d", expectedURI: "/a?c=d", expectedAuthority: "example.com"},
+		"absolute form without path": {target: "https://example.com:8443?c=d", expectedURI: "/?c=d", expectedAuthority: "example.com:8443"},
+		"absolute form fragment":     {target: "http://example.com#/a", expectedURI: "/", expectedAuthority: "example.com"},
+		"authority form":             {method: "CONNECT", target: "example.com:443", expectedURI: "example.com:443", expectedAuthority: "example.com:443"},
+		"fragment":                   {target: "/a?c=d#e", expectedURI: "/a?c=d"},
+		"empty":                      {target: "", expectedURI: "/"},
+		"relative":                   {target: "a/b", expectedURI: "/a/b"},
+		"leading slashes":            {target: "//example.com/a", expectedURI: "/example.com/a"},
+		"valid escapes":              {target: "/a%2Fb?c=%3d", expectedURI: "/a%2Fb?c=%3d"},
+		"invalid escape":             {target: "/a%zz?c=1", expectedURI: "/a%25zz?c=1"},
+		"truncated escape":           {target: "/a?c=%4", expectedURI: "/a?c=%254"},
+		"control characters":         {target: "/a\tb\x7f", expectedURI: "/a%09b%7F"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = "GET"
+			}
+			target := parseRequestTarget(method, test.target)
+			require.Equal(t, test.expectedURI, target.uri)
+			require.Equal(t, test.expectedAuthority, target.authority)
+		})
+	}
+}
+
+func FuzzParseRequestTarget(f *testing.F) {
+	for _, target := range []string{"/a?b=c", "*", "http://example.com/a", "//a/b", "/a%zz", "/a%", "/a\x00b", "a:b/c", "HTTP://x?y#z"} {
+		f.Add(target)
+	}
+	f.Fuzz(func(t *testing.T, target string) {
+		uri := parseRequestTarget("GET", target).uri
+		if uri == "*" {
+			return
+		}
+		require.True(t, strings.HasPrefix(uri, "/") && !strings.HasPrefix(uri, "//"), "the target should be in origin form")
+		_, err := url.Parse(uri)
+		require.NoError(t, err, "Coraza should parse the target")
+	})
+}
+
+func TestRequestTargetNormalization(t *testing.T) {
+	useEngine(t, `{"directives": [
+		"SecRuleEngine On",
+		"SecRule ARGS:attack \"@rx .\" \"id:1,phase:1,deny,status:403\"",
+		"SecRule REQUEST_FILENAME \"@beginsWith /admin\" \"id:2,phase:1,deny,status:401\""
+	]}`)
+
+	// The targets a host may deliver for the same request are seen the
+	// same way by the rules.
+	tests := map[string]struct {
+		target         string
+		expectedStatus uint32
+	}{
+		"origin form":         {target: "/?attack=1", expectedStatus: 403},
+		"absolute form":       {target: "http://localhost/?attack=1", expectedStatus: 403},
+		"invalid escape":      {target: "/%zz?attack=1", expectedStatus: 403},
+		"control character":   {target: "/\x01?attack=1", expectedStatus: 403},
+		"fragment":            {target: "/?attack=1#top", expectedStatus: 403},
+		"absolute form path":  {target: "http://localhost/admin/users", expectedStatus: 401},
+		"leading slashes":     {target: "//localhost/admin", expectedStatus: 200},
+		"double slashed path": {target: "//admin", expectedStatus: 401},
+		"clean":               {target: "/index.html", expectedStatus: 200},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := newMockResponse(200, "")
+			serve(newMockRequest("GET", test.target, ""), res)
+			require.Equal(t, test.expectedStatus, res.statusCode)
+		})
+	}
+}
+
+func TestServerName(t *testing.T) {
+	tests := map[string]struct {
+		target  string
+		headers map[string]string
+		// expectedServerName is checked against "example.com".
+		expectedServerName bool
+	}{
+		"Host":                   {target: "/", headers: map[string]string{"Host": "example.com"}, expectedServerName: true},
+		"lowercased":             {target: "/", headers: map[string]string{"Host": "EXAMPLE.com"}, expectedServerName: true},
+		"port":                   {target: "/", headers: map[string]string{"Host": "example.com:8080"}, expectedServerName: true},
+		"other host":             {target: "/", headers: map[string]string{"Host": "localhost"}},
+		"absolute form":          {target: "http://example.com:8080/", headers: map[string]string{"Host": "example.com:8080"}, expectedServerName: true},
+		"authority pseudoheader": {target: "/", headers: map[string]string{":authority": "example.com:443", "Host": "localhost"}, expectedServerName: true},
+		"SNI":                    {target: "/", headers: map[string]string{"X-Tls-Sni": "example.com", "Host": "localhost"}, expectedServerName: true},
+		"authority over SNI":     {target: "/", headers: map[string]string{":authority": "localhost", "X-Tls-Sni": "example.com", "Host": "localhost"}},
+	}
+
+	useEngine(t, `{"tls": {"sniHeader": "X-Tls-Sni"}, "directives": [
+		"SecRuleEngine On",
+		"SecRule &REQUEST_HEADERS:Host \"@gt 1\" \"id:1,phase:1,deny,status:400\"",
+		"SecRule SERVER_NAME \"@streq example.com\" \"id:2,phase:1,deny,status:401\""
+	]}`)
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := newMockRequest("GET", test.target, "")
+			req.headers = mockHeader{}
+			for k, v := range test.headers {
+				req.headers[k] = []string{v}
+			}
+			res := newMockResponse(200, "")
+			serve(req, res)
+			if test.expectedServerName {
+				require.Equal(t, uint32(401), res.statusCode)
+			} else {
+				require.Equal(t, uint32(200), res.statusCode)
+			}
+		})
+	}
+}
+
+func TestStripPort(t *testing.T) {
+	for authority, expected := range map[string]string{
+		"example.com":           "example.com",
+		"example.com:8080":      "example.com",
+		"user@example.com:8080": "example.com",
+		"10.0.0.1:80":           "10.0.0.1",
+		"[2001:db8::1]:8080":    "2001:db8::1",
+		"[2001:db8::1]":         "2001:db8::1",
+		"2001:db8::1":           "2001:db8::1",
+		"":                      "",
+	} {
+		require.Equal(t, expected, stripPort(authority), authority)
+	}
+}
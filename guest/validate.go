@@ -0,0 +1,89 @@
+package guest
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// ValidationReport summarizes a host config checked by Validate.
+type ValidationReport struct {
+	// Directives and Rules count the directives and the rules, chained ones
+	// included, of the enforced ruleset with its includes followed. They are
+	// zero when the includes can't be followed.
+	Directives int
+	Rules      int
+	// HeapBytes estimates the memory held by the WAFs built from the config.
+	// It is measured natively, the guest usually needs somewhat more.
+	HeapBytes uint64
+	// Warnings are the warnings logged while building the WAFs.
+	Warnings []string
+}
+
+// Validate builds the WAFs from the host config the same way Init does,
+// including the embedded CRS, so that bad configs are caught before being
+// deployed, e.g. in CI. Includes not in the CRS are resolved in the current
+// directory.
+func Validate(config []byte) (ValidationReport, error) {
+	if len(bytes.TrimSpace(config)) == 0 {
+		// The guest runs without a config, but it's most likely a mistake
+		// here.
+		return ValidationReport{}, errors.New("empty host config")
+	}
+	host := &validationHost{config: config}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	e, err := initializeWAF(host, overrides{})
+	if err != nil {
+		return ValidationReport{}, err
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	report := ValidationReport{Warnings: host.warnings}
+	if after.HeapAlloc > before.HeapAlloc {
+		report.HeapBytes = after.HeapAlloc - before.HeapAlloc
+	}
+	runtime.KeepAlive(e)
+
+	directives := e.cfg.directives
+	if len(e.cfg.virtualPatches) > 0 {
+		directives += "\n" + virtualPatchDirectives(e.cfg.virtualPatches)
+	}
+	var ok bool
+	if report.Directives, report.Rules, ok = countDirectives(rootFS(e.cfg), directives); !ok {
+		report.Directives, report.Rules = 0, 0
+		report.Warnings = append(report.Warnings, "Includes could not be followed, directives are not counted")
+	}
+	return report, nil
+}
+
+// validationHost is the host Validate builds the WAFs with, it grants every
+// feature and keeps the warnings.
+type validationHost struct {
+	api.Host
+	config   []byte
+	warnings []string
+}
+
+func (h *validationHost) GetConfig() []byte {
+	return h.config
+}
+
+func (h *validationHost) EnableFeatures(features api.Features) api.Features {
+	return features
+}
+
+func (h *validationHost) LogEnabled(level api.LogLevel) bool {
+	return level >= api.LogLevelWarn
+}
+
+func (h *validationHost) Log(level api.LogLevel, msg string) {
+	if h.LogEnabled(level) {
+		h.warnings = append(h.warnings, msg)
+	}
+}
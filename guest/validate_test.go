@@ -0,0 +1,50 @@
+package guest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		report, err := Validate([]byte(`
+		{
+			"directives": [
+				"SecRuleEngine On",
+				"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403,chain\"",
+				"SecRule REQUEST_METHOD \"@streq GET\" \"t:none\""
+			],
+			"includeCRS": false,
+			"virtualPatches": [{"id": "CVE-1", "path": "^/admin"}]
+		}`))
+		require.NoError(t, err)
+		require.Equal(t, 4, report.Directives)
+		require.Equal(t, 3, report.Rules)
+		require.Empty(t, report.Warnings)
+	})
+
+	t.Run("CRS", func(t *testing.T) {
+		report, err := Validate([]byte(`{"directives": ["Include @coraza.conf-recommended", "Include @crs-setup.conf.example", "Include @owasp_crs/*.conf"]}`))
+		require.NoError(t, err)
+		require.Greater(t, report.Rules, 500)
+		require.NotZero(t, report.HeapBytes)
+	})
+
+	t.Run("warnings", func(t *testing.T) {
+		report, err := Validate([]byte(`{"directives": ["SecRuleEngine On"], "mode": "detect"}`))
+		require.NoError(t, err)
+		require.Contains(t, report.Warnings, "Running in detect mode, requests are not blocked")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for config, expectedErr := range map[string]string{
+			``:                            "empty host config",
+			`{"directives": ["SecRule"]}`: "failed to compile the directive",
+			`{"directives": ["SecRuleEngine On"], "mode": "on"}`: "enforce, detect or bypass expected for field mode",
+		} {
+			_, err := Validate([]byte(config))
+			require.ErrorContains(t, err, expectedErr, config)
+		}
+	})
+}
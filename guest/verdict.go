@@ -0,0 +1,84 @@
+package guest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// Verdict headers are set on the request so that they reach the upstream and
+// any host filter running after this one (e.g. Envoy RBAC or rate limiting)
+// or logging the request headers (e.g. Envoy access logs with %REQ(...)%).
+const (
+	// verdictActionHeader holds the interruption action (e.g. deny), "log"
+	// when rules matched without interrupting or "pass" otherwise.
+	verdictActionHeader = "x-waf-action"
+	// verdictRuleIDsHeader holds the comma separated IDs of the matched rules
+	// with a message.
+	verdictRuleIDsHeader = "x-waf-rule-ids"
+	// verdictScoreHeader holds the CRS inbound anomaly score.
+	verdictScoreHeader = "x-waf-score"
+)
+
+// removeVerdictHeaders removes the verdict headers sent by the client, which
+// would otherwise reach the upstream as is when the transaction doesn't run.
+func removeVerdictHeaders(headers api.Header) {
+	headers.Remove(verdictActionHeader)
+	headers.Remove(verdictRuleIDsHeader)
+	headers.Remove(verdictScoreHeader)
+}
+
+// setVerdictHeaders overrides any verdict header sent by the client with the
+// transaction verdict.
+func setVerdictHeaders(tx types.Transaction, headers api.Header) {
+	var ruleIDs strings.Builder
+	for _, mr := range tx.MatchedRules() {
+		if mr.Message() == "" {
+			continue
+		}
+
+		if ruleIDs.Len() > 0 {
+			ruleIDs.WriteByte(',')
+		}
+		ruleIDs.WriteString(strconv.Itoa(mr.Rule().ID()))
+	}
+
+	switch {
+	case tx.IsInterrupted():
+		headers.Set(verdictActionHeader, tx.Interruption().Action)
+	case ruleIDs.Len() > 0:
+		headers.Set(verdictActionHeader, "log")
+	default:
+		headers.Set(verdictActionHeader, "pass")
+	}
+
+	if ruleIDs.Len() > 0 {
+		headers.Set(verdictRuleIDsHeader, ruleIDs.String())
+	} else {
+		headers.Remove(verdictRuleIDsHeader)
+	}
+
+	if score := anomalyScore(tx); score != "" {
+		headers.Set(verdictScoreHeader, score)
+	} else {
+		headers.Remove(verdictScoreHeader)
+	}
+}
+
+// anomalyScore returns the CRS inbound anomaly score, or an empty string
+// when CRS is not in use.
+func anomalyScore(tx types.Transaction) string {
+	state, ok := tx.(plugintypes.TransactionState)
+	if !ok {
+		return ""
+	}
+
+	if score := state.Variables().TX().Get("blocking_inbound_anomaly_score"); len(score) > 0 {
+		return score[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,108 @@
+package guest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerdictHeaders(t *testing.T) {
+	useEngine(t, `
+	{
+		"verdictHeaders": true,
+		"directives": [
+			"SecRuleEngine On",
+			"SecRule ARGS:q \"@contains attack\" \"id:1,phase:1,deny,status:403,msg:'Attack'\"",
+			"SecRule ARGS:q \"@contains suspicious\" \"id:2,phase:1,pass,log,msg:'Suspicious'\"",
+			"SecAction \"id:3,phase:1,pass,nolog\""
+		]
+	}`)
+
+	tests := map[string]struct {
+		uri             string
+		expectedAction  string
+		expectedRuleIDs string
+	}{
+		"pass":     {uri: "/?q=hello", expectedAction: "pass"},
+		"detected": {uri: "/?q=suspicious", expectedAction: "log", expectedRuleIDs: "2"},
+		"blocked":  {uri: "/?q=attack", expectedAction: "deny", expectedRuleIDs: "1"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := newMockRequest("GET", test.uri, "")
+			// Headers sent by the client must be overridden.
+			req.headers.Set(verdictActionHeader, "pass")
+			req.headers.Set(verdictRuleIDsHeader, "0")
+			req.headers.Set(verdictScoreHeader, "0")
+
+			serve(req, newMockResponse(200, ""))
+
+			action, _ := req.headers.Get(verdictActionHeader)
+			require.Equal(t, test.expectedAction, action)
+
+			ruleIDs, _ := req.headers.Get(verdictRuleIDsHeader)
+			require.Equal(t, test.expectedRuleIDs, ruleIDs)
+
+			_, ok := req.headers.Get(verdictScoreHeader)
+			require.False(t, ok, "no score expected without CRS")
+		})
+	}
+
+	t.Run("CRS score", func(t *testing.T) {
+		useEngine(t, `
+		{
+			"verdictHeaders": true,
+			"directives": [
+				"Include @coraza.conf-recommended",
+				"Include @crs-setup.conf.example",
+				"Include @owasp_crs/*.conf",
+				"SecRuleEngine DetectionOnly"
+			]
+		}`)
+
+		req := newMockRequest("GET", "/?q=<script>alert(1)</script>", "")
+		req.headers.Set("Accept", "*/*")
+		req.headers.Set("User-Agent", "coraza")
+		serve(req, newMockResponse(200, ""))
+
+		action, _ := req.headers.Get(verdictActionHeader)
+		require.Equal(t, "log", action)
+
+		score, ok := req.headers.Get(verdictScoreHeader)
+		require.True(t, ok)
+		require.NotEqual(t, "0", score)
+	})
+	t.Run("not inspected", func(t *testing.T) {
+		tests := map[string]struct {
+			options string
+			token   bool
+		}{
+			"bypass mode":     {options: `"mode": "bypass", "directives": ["SecRuleEngine On"],`},
+			"bypass token":    {options: `"bypassTokens": {"secret": "` + bypassSecret + `"}, "directives": ["SecRuleEngine On"],`, token: true},
+			"rule engine off": {options: `"directives": ["SecRuleEngine Off"],`},
+		}
+
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				useEngine(t, `{`+test.options+` "verdictHeaders": true}`)
+
+				req := newMockRequest("GET", "/", "")
+				if test.token {
+					req.headers.Set(defaultBypassHeader, bypassToken(bypassSecret, "scanner", time.Now().Add(time.Hour)))
+				}
+				req.headers.Set(verdictActionHeader, "pass")
+				req.headers.Set(verdictRuleIDsHeader, "0")
+				req.headers.Set(verdictScoreHeader, "0")
+
+				next, _ := HandleRequest(req, newMockResponse(200, ""))
+				require.True(t, next)
+				for _, name := range []string{verdictActionHeader, verdictRuleIDsHeader, verdictScoreHeader} {
+					_, ok := req.headers.Get(name)
+					require.False(t, ok, "%s sent by the client must not reach the upstream", name)
+				}
+			})
+		}
+	})
+}
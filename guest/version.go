@@ -0,0 +1,125 @@
+package guest
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Version and Commit identify the build, they are set with the linker, e.g.
+//
+//	-ldflags "-X github.com/corazawaf/coraza-http-wasm/guest.Version=v1.2.0"
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)
+
+// versionConstraint is a list of comparisons the running version has to
+// satisfy all of, e.g. ">=1.2.0, <2.0.0".
+type versionConstraint []versionComparison
+
+type versionComparison struct {
+	op      string
+	version [3]int
+}
+
+// versionOps are the comparison operators, longer ones first so that they are
+// matched before their prefixes.
+var versionOps = []string{">=", "<=", "!=", ">", "<", "="}
+
+func parseVersionCheck(value gjson.Result) (versionConstraint, error) {
+	c, ok := parseVersionConstraint(value.Str)
+	if value.Type != gjson.String || !ok {
+		return nil, errors.New("invalid host config, version constraint expected for field versionCheck")
+	}
+	return c, nil
+}
+
+func parseVersionConstraint(s string) (versionConstraint, bool) {
+	var c versionConstraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, o := range versionOps {
+			if strings.HasPrefix(part, o) {
+				op = o
+				break
+			}
+		}
+		v, ok := parseVersion(strings.TrimSpace(strings.TrimPrefix(part, op)))
+		if !ok {
+			return nil, false
+		}
+		c = append(c, versionComparison{op: op, version: v})
+	}
+	return c, true
+}
+
+// parseVersion parses a semantic version, with an optional "v" prefix.
+// Pre-release and build suffixes, e.g. the "-3-gabcdef" git describe adds,
+// are ignored.
+func parseVersion(s string) ([3]int, bool) {
+	var v [3]int
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// check returns an error when version doesn't satisfy the constraint,
+// including when it is not a release version e.g. a dev build.
+func (c versionConstraint) check(version string) error {
+	v, ok := parseVersion(version)
+	if !ok {
+		return errors.New("running version " + version + " can't be checked against versionCheck")
+	}
+
+	for _, cmp := range c {
+		d := compareVersions(v, cmp.version)
+		var satisfied bool
+		switch cmp.op {
+		case ">=":
+			satisfied = d >= 0
+		case "<=":
+			satisfied = d <= 0
+		case ">":
+			satisfied = d > 0
+		case "<":
+			satisfied = d < 0
+		case "!=":
+			satisfied = d != 0
+		default:
+			satisfied = d == 0
+		}
+		if !satisfied {
+			return errors.New("running version " + version + " doesn't satisfy versionCheck")
+		}
+	}
+	return nil
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
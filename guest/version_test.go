@@ -0,0 +1,47 @@
+package guest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionCheck(t *testing.T) {
+	for constraint, versions := range map[string]struct{ satisfying, others []string }{
+		">=1.2.0":         {[]string{"1.2.0", "v1.2.1", "v1.10.0", "v2.0.0-rc1", "v1.2.0-3-gabcdef"}, []string{"v1.1.9", "0.9.0"}},
+		">1.2, <2":        {[]string{"v1.2.1", "1.9.9"}, []string{"v1.2.0", "v2.0.0"}},
+		"1.2.3":           {[]string{"v1.2.3"}, []string{"v1.2.4"}},
+		"!=1.2.3, <=1.3":  {[]string{"v1.2.2", "v1.3.0"}, []string{"v1.2.3", "v1.3.1"}},
+		">= v1.0.0,<v1.1": {[]string{"v1.0.5"}, []string{"v1.1.0"}},
+	} {
+		c, ok := parseVersionConstraint(constraint)
+		require.True(t, ok, constraint)
+		for _, v := range versions.satisfying {
+			require.NoError(t, c.check(v), constraint+" "+v)
+		}
+		for _, v := range versions.others {
+			require.ErrorContains(t, c.check(v), "doesn't satisfy versionCheck", constraint+" "+v)
+		}
+	}
+
+	t.Run("dev build", func(t *testing.T) {
+		c, _ := parseVersionConstraint(">=1.0.0")
+		require.ErrorContains(t, c.check("dev"), "can't be checked")
+	})
+
+	t.Run("init", func(t *testing.T) {
+		version := Version
+		t.Cleanup(func() { Version = version })
+		Version = "v1.4.0"
+
+		_, err := initializeWAF(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"directives": ["SecRuleEngine On"], "versionCheck": ">=1.5.0"}`)
+		}}, overrides{})
+		require.ErrorContains(t, err, "running version v1.4.0 doesn't satisfy versionCheck")
+
+		_, err = initializeWAF(mockAPIHost{getConfig: func() []byte {
+			return []byte(`{"directives": ["SecRuleEngine On"], "versionCheck": ">=1.4.0"}`)
+		}}, overrides{})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,186 @@
+package guest
+
+import (
+	"errors"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// virtualPatchBaseID is the first rule ID of the virtual patches, each
+	// patch having maxVirtualPatchRules IDs in one of virtualPatchSlots.
+	virtualPatchBaseID   = 4800000
+	maxVirtualPatchRules = 100
+	virtualPatchSlots    = 1000
+)
+
+// virtualPatchIDPattern restricts the patch IDs, used as rule tags, e.g. a
+// CVE ID.
+var virtualPatchIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// virtualPatchParamPattern restricts the parameter names, used as ARGS keys.
+var virtualPatchParamPattern = regexp.MustCompile(`^[A-Za-z0-9._\-\[\]]+$`)
+
+// virtualPatch is an emergency mitigation, compiled into blocking rules so
+// that operators don't have to write SecLang under pressure. Requests to a
+// path matching Path, with one of Methods if any, are blocked when one of
+// the Params constraints is violated, or unconditionally without any.
+type virtualPatch struct {
+	// ID identifies the patch, e.g. the CVE ID, it tags the rules.
+	ID      string                  `json:"id"`
+	Path    string                  `json:"path"`
+	Methods []string                `json:"methods,omitempty"`
+	Params  []virtualPatchParameter `json:"params,omitempty"`
+	// Status is the status code of the blocked requests, 403 by default.
+	Status int `json:"status,omitempty"`
+	// RuleID is the ID of the first rule of the patch, derived from ID
+	// unless set, see ruleID.
+	RuleID int `json:"ruleId,omitempty"`
+}
+
+// virtualPatchParameter constrains a request parameter, from the query string
+// or the body.
+type virtualPatchParameter struct {
+	Name string `json:"name"`
+	// Pattern blocks the values matching it.
+	Pattern string `json:"pattern,omitempty"`
+	// Allow blocks the values not matching it.
+	Allow string `json:"allow,omitempty"`
+	// MaxLength blocks the values longer than it.
+	MaxLength int `json:"maxLength,omitempty"`
+}
+
+// validate checks the patch compiles into valid rules, the patterns being
+// interpolated in them.
+func (p virtualPatch) validate() error {
+	if !virtualPatchIDPattern.MatchString(p.ID) {
+		return errors.New("letters, digits, '.', '_' or '-' expected for patch id")
+	}
+	if p.Path == "" {
+		return errors.New("path pattern expected for patch " + p.ID)
+	}
+	if err := validatePattern(p.Path); err != nil {
+		return errors.New("invalid path pattern for patch " + p.ID + ": " + err.Error())
+	}
+	for _, m := range p.Methods {
+		if m == "" || strings.ToUpper(m) != m || strings.ContainsAny(m, " |()\"") {
+			return errors.New("invalid method " + strconv.Quote(m) + " for patch " + p.ID)
+		}
+	}
+	if p.Status != 0 && (p.Status < 100 || p.Status > 599) {
+		return errors.New("invalid status for patch " + p.ID)
+	}
+	if p.RuleID != 0 && (p.RuleID < virtualPatchBaseID || p.RuleID >= virtualPatchBaseID+virtualPatchSlots*maxVirtualPatchRules || p.RuleID%maxVirtualPatchRules != 0) {
+		return errors.New("invalid rule id for patch " + p.ID + ", multiple of 100 from 4800000 to 4899900 expected")
+	}
+
+	constraints := 0
+	for _, param := range p.Params {
+		if !virtualPatchParamPattern.MatchString(param.Name) {
+			return errors.New("invalid parameter name " + strconv.Quote(param.Name) + " for patch " + p.ID)
+		}
+		if param.Pattern == "" && param.Allow == "" && param.MaxLength <= 0 {
+			return errors.New("pattern, allow or maxLength expected for parameter " + param.Name + " of patch " + p.ID)
+		}
+		for _, pattern := range []string{param.Pattern, param.Allow} {
+			if err := validatePattern(pattern); pattern != "" && err != nil {
+				return errors.New("invalid pattern for parameter " + param.Name + " of patch " + p.ID + ": " + err.Error())
+			}
+		}
+		constraints += 3
+	}
+	if constraints >= maxVirtualPatchRules {
+		return errors.New("too many parameters for patch " + p.ID)
+	}
+
+	return nil
+}
+
+// ruleID returns the ID of the first rule of the patch. Unless set, it is
+// derived from the patch ID rather than from the position of the patch, so
+// that it doesn't change as other patches come and go, e.g. for exclusions
+// or when searching the logs.
+func (p virtualPatch) ruleID() int {
+	if p.RuleID != 0 {
+		return p.RuleID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(p.ID))
+	return virtualPatchBaseID + int(h.Sum32()%virtualPatchSlots)*maxVirtualPatchRules
+}
+
+// checkVirtualPatchRuleIDs reports the patches getting the same rule IDs,
+// one of them has then to set RuleID.
+func checkVirtualPatchRuleIDs(patches []virtualPatch) error {
+	seen := map[int]string{}
+	for _, p := range patches {
+		id := p.ruleID()
+		if other, ok := seen[id]; ok {
+			return errors.New("patches " + other + " and " + p.ID + " get the same rule ids, set ruleId on one of them")
+		}
+		seen[id] = p.ID
+	}
+	return nil
+}
+
+func validatePattern(pattern string) error {
+	if strings.ContainsAny(pattern, "\"\n") {
+		return errors.New("double quotes and new lines are not supported")
+	}
+	_, err := regexp.Compile(pattern)
+	return err
+}
+
+// virtualPatchDirectives compiles the patches into phase 2 rules, so that
+// parameters from the body are checked as well. Each constraint gets its own
+// chain starting at the path.
+func virtualPatchDirectives(patches []virtualPatch) string {
+	var b strings.Builder
+	for _, p := range patches {
+		status := p.Status
+		if status == 0 {
+			status = 403
+		}
+		id := p.ruleID()
+		head := func(chain bool) {
+			b.WriteString(`SecRule REQUEST_FILENAME "@rx ` + p.Path + `" "id:` + strconv.Itoa(id) +
+				`,phase:2,deny,status:` + strconv.Itoa(status) + `,log,msg:'Virtual patch ` + p.ID +
+				`',tag:'virtual-patch',tag:'` + p.ID + `'`)
+			id++
+			if chain || len(p.Methods) > 0 {
+				b.WriteString(`,chain`)
+			}
+			b.WriteString("\"\n")
+			if len(p.Methods) > 0 {
+				b.WriteString(`SecRule REQUEST_METHOD "@rx ^(?:` + strings.Join(p.Methods, "|") + `)$" "t:none`)
+				if chain {
+					b.WriteString(`,chain`)
+				}
+				b.WriteString("\"\n")
+			}
+		}
+
+		if len(p.Params) == 0 {
+			head(false)
+			continue
+		}
+		for _, param := range p.Params {
+			variable := "ARGS:" + param.Name
+			if param.Pattern != "" {
+				head(true)
+				b.WriteString(`SecRule ` + variable + ` "@rx ` + param.Pattern + `" "t:none"` + "\n")
+			}
+			if param.Allow != "" {
+				head(true)
+				b.WriteString(`SecRule ` + variable + ` "!@rx ` + param.Allow + `" "t:none"` + "\n")
+			}
+			if param.MaxLength > 0 {
+				head(true)
+				b.WriteString(`SecRule ` + variable + ` "@gt ` + strconv.Itoa(param.MaxLength) + `" "t:none,t:length"` + "\n")
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package guest
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualPatches(t *testing.T) {
+	const cfg = `{
+		"admin": {"path": "/_coraza", "token": "secret"},
+		"virtualPatches": [
+			{"id": "CVE-2021-44228", "path": "^/api/", "params": [{"name": "q", "pattern": "\\$\\{jndi:"}]},
+			{"id": "CVE-0000-0001", "path": "^/upload$", "methods": ["PUT", "POST"], "status": 404},
+			{"id": "CVE-0000-0002", "path": "^/search$", "params": [{"name": "page", "allow": "^[0-9]+$", "maxLength": 3}]}
+		],
+		"directives": ["SecRuleEngine On", "SecRequestBodyAccess On"]
+	}`
+
+	tests := map[string]struct {
+		method, uri, body string
+		expectedStatus    uint32
+	}{
+		"pattern in query":      {method: "GET", uri: "/api/users?q=${jndi:ldap://x}", expectedStatus: 403},
+		"pattern in body":       {method: "POST", uri: "/api/users", body: "q=${jndi:ldap://x}", expectedStatus: 403},
+		"pattern on other path": {method: "GET", uri: "/users?q=${jndi:ldap://x}", expectedStatus: 200},
+		"pattern not matching":  {method: "GET", uri: "/api/users?q=hello", expectedStatus: 200},
+		"method":                {method: "POST", uri: "/upload", expectedStatus: 404},
+		"other method":          {method: "GET", uri: "/upload", expectedStatus: 200},
+		"allowed value":         {method: "GET", uri: "/search?page=12", expectedStatus: 200},
+		"value not allowed":     {method: "GET", uri: "/search?page=1'", expectedStatus: 403},
+		"value too long":        {method: "GET", uri: "/search?page=1234", expectedStatus: 403},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			useEngine(t, cfg)
+
+			req := newMockRequest(test.method, test.uri, test.body)
+			if test.body != "" {
+				req.headers.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			res := newMockResponse(200, "")
+			serve(req, res)
+			require.Equal(t, test.expectedStatus, res.statusCode)
+		})
+	}
+
+	t.Run("admin", func(t *testing.T) {
+		useEngine(t, cfg)
+
+		admin := func(method, uri, body string) *mockResponse {
+			req := newMockRequest(method, uri, body)
+			req.headers.Set("Authorization", "Bearer secret")
+			res := newMockResponse(200, "")
+			HandleRequest(req, res)
+			return res
+		}
+		blocked := func() bool {
+			res := newMockResponse(200, "")
+			serve(newMockRequest("GET", "/struts?class.module=x", ""), res)
+			return res.statusCode == 403
+		}
+
+		require.False(t, blocked())
+
+		patch := `{"id": "CVE-2014-0094", "path": "^/struts", "params": [{"name": "class.module", "maxLength": 0, "pattern": "."}]}`
+		require.Equal(t, uint32(200), admin("POST", "/_coraza/patches", patch).statusCode)
+		require.True(t, blocked())
+
+		var patches adminPatches
+		require.NoError(t, json.Unmarshal(admin("GET", "/_coraza/patches", "").body.written, &patches))
+		require.Len(t, patches.Configured, 3)
+		require.Len(t, patches.Runtime, 1)
+		require.Equal(t, "CVE-2014-0094", patches.Runtime[0].ID)
+
+		require.Equal(t, uint32(409), admin("POST", "/_coraza/patches", `{"id": "CVE-2021-44228", "path": "^/"}`).statusCode)
+		require.Equal(t, uint32(409), admin("POST", "/_coraza/patches", `{"id": "x", "path": "^/", "ruleId": 4882800}`).statusCode, "same rule ids")
+		require.Equal(t, uint32(400), admin("POST", "/_coraza/patches", `{"id": "x", "path": "("}`).statusCode)
+		require.Equal(t, uint32(400), admin("POST", "/_coraza/patches", `{`).statusCode)
+		require.Equal(t, uint32(413), admin("POST", "/_coraza/patches", string(bytes.Repeat([]byte(" "), maxAdminBodySize+1))).statusCode)
+
+		require.Equal(t, uint32(404), admin("DELETE", "/_coraza/patches/CVE-2021-44228", "").statusCode)
+		require.Equal(t, uint32(200), admin("DELETE", "/_coraza/patches/CVE-2014-0094", "").statusCode)
+		require.False(t, blocked())
+	})
+}
+
+func TestVirtualPatchRuleIDs(t *testing.T) {
+	a := virtualPatch{ID: "CVE-2021-44228", Path: "^/"}
+	b := virtualPatch{ID: "CVE-0000-0001", Path: "^/"}
+
+	// The IDs don't depend on the position of the patch.
+	require.Contains(t, virtualPatchDirectives([]virtualPatch{a}), `"id:4882800,`)
+	require.Contains(t, virtualPatchDirectives([]virtualPatch{b, a}), `"id:4882800,`)
+	require.Contains(t, virtualPatchDirectives([]virtualPatch{b, a}), `"id:4872200,`)
+
+	// These two get the same slot.
+	c := virtualPatch{ID: "CVE-2024-25", Path: "^/"}
+	d := virtualPatch{ID: "CVE-2024-58", Path: "^/"}
+	require.ErrorContains(t, checkVirtualPatchRuleIDs([]virtualPatch{a, c, d}), "patches CVE-2024-25 and CVE-2024-58 get the same rule ids")
+
+	d.RuleID = 4800000
+	require.NoError(t, checkVirtualPatchRuleIDs([]virtualPatch{a, c, d}))
+	require.Contains(t, virtualPatchDirectives([]virtualPatch{d}), `"id:4800000,`)
+
+	_, err := getConfigFromHost(mockAPIHost{getConfig: func() []byte {
+		return []byte(`{"directives": ["SecRuleEngine On"], "virtualPatches": [{"id": "CVE-2024-25", "path": "^/"}, {"id": "CVE-2024-58", "path": "^/"}]}`)
+	}})
+	require.ErrorContains(t, err, "invalid host config, patches CVE-2024-25 and CVE-2024-58 get the same rule ids")
+}
+
+func TestValidateVirtualPatch(t *testing.T) {
+	tests := map[string]struct {
+		patch       virtualPatch
+		expectedErr string
+	}{
+		"valid":                {patch: virtualPatch{ID: "CVE-1", Path: "^/"}},
+		"invalid id":           {patch: virtualPatch{ID: "CVE 1", Path: "^/"}, expectedErr: "expected for patch id"},
+		"missing path":         {patch: virtualPatch{ID: "CVE-1"}, expectedErr: "path pattern expected"},
+		"invalid path":         {patch: virtualPatch{ID: "CVE-1", Path: "("}, expectedErr: "invalid path pattern"},
+		"quote in path":        {patch: virtualPatch{ID: "CVE-1", Path: `"`}, expectedErr: "double quotes"},
+		"invalid method":       {patch: virtualPatch{ID: "CVE-1", Path: "^/", Methods: []string{"get"}}, expectedErr: "invalid method"},
+		"invalid status":       {patch: virtualPatch{ID: "CVE-1", Path: "^/", Status: 1}, expectedErr: "invalid status"},
+		"valid rule id":        {patch: virtualPatch{ID: "CVE-1", Path: "^/", RuleID: 4899900}},
+		"rule id out of range": {patch: virtualPatch{ID: "CVE-1", Path: "^/", RuleID: 4900000}, expectedErr: "invalid rule id"},
+		"unaligned rule id":    {patch: virtualPatch{ID: "CVE-1", Path: "^/", RuleID: 4800001}, expectedErr: "invalid rule id"},
+		"invalid param name":   {patch: virtualPatch{ID: "CVE-1", Path: "^/", Params: []virtualPatchParameter{{Name: "a b", Pattern: "x"}}}, expectedErr: "invalid parameter name"},
+		"missing constraint":   {patch: virtualPatch{ID: "CVE-1", Path: "^/", Params: []virtualPatchParameter{{Name: "a"}}}, expectedErr: "pattern, allow or maxLength expected"},
+		"invalid param regex":  {patch: virtualPatch{ID: "CVE-1", Path: "^/", Params: []virtualPatchParameter{{Name: "a", Allow: "["}}}, expectedErr: "invalid pattern for parameter"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.patch.validate()
+			if test.expectedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, test.expectedErr)
+			}
+		})
+	}
+}
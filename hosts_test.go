@@ -0,0 +1,191 @@
+//go:build e2e
+// +build e2e
+
+package main_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/corazawaf/coraza-http-wasm/wasmtest"
+	"github.com/http-wasm/http-wasm-host-go/handler"
+	nethttp "github.com/http-wasm/http-wasm-host-go/handler/nethttp"
+	"github.com/stretchr/testify/require"
+)
+
+const hostsDirectives = `
+	SecRequestBodyAccess On
+	SecResponseBodyAccess On
+	SecResponseBodyMimeType text/plain
+	SecRule ARGS:attack "@rx ." "id:1,phase:1,deny,status:403,log"
+	SecRule ARGS_POST:q "@contains evil" "id:2,phase:2,deny,status:403,log"
+	SecRule RESPONSE_HEADERS:X-Leak "@rx ." "id:3,phase:3,deny,status:403,log"
+	SecRule RESPONSE_BODY "@contains secret" "id:4,phase:4,deny,status:403,log"
+`
+
+// upstreamResponse is the response of the upstream for the given path, the
+// same for every host.
+func upstreamResponse(path string) (int, http.Header, string) {
+	header := http.Header{"Content-Type": {"text/plain"}}
+	switch path {
+	case "/leak":
+		// The body must not reach the client once the headers are blocked.
+		header.Set("X-Leak", "yes")
+		return http.StatusOK, header, "secret"
+	case "/secret":
+		return http.StatusOK, header, "secret"
+	case "/trailers":
+		// The body is chunked to be followed by the trailers, see
+		// upstreamTrailers.
+		header.Set("Trailer", "X-Checksum")
+		return http.StatusOK, header, "secret"
+	case "/error":
+		// The error page is scrubbed, its Content-Length has to be replaced.
+		body := "Fatal error: Uncaught Exception in /var/www/index.php:3"
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		return http.StatusInternalServerError, header, body
+	}
+	return http.StatusOK, header, "hello"
+}
+
+// upstreamTrailers are the trailers of the upstream response for the given
+// path, for the hosts supporting them.
+func upstreamTrailers(path string) http.Header {
+	if path == "/trailers" {
+		return http.Header{"X-Checksum": {"2bb80d5"}}
+	}
+	return nil
+}
+
+// e2eHost runs the module with the given directives and returns a function
+// sending a request through it and returning the status code and the body
+// of the response.
+type e2eHost func(t *testing.T, directives string) func(method, uri, body string) (int, string)
+
+var e2eHosts = map[string]e2eHost{
+	// nethttp is the wazero based reference host.
+	"nethttp": nethttpHost(func(*testing.T) []byte { return guest }),
+	// wasmtest is the fake host of the wasmtest package.
+	"wasmtest": func(t *testing.T, directives string) func(method, uri, body string) (int, string) {
+		h, err := wasmtest.New(testCtx, guest,
+			wasmtest.Logger(testLogger{t}),
+			wasmtest.Config(hostsConfig(directives)),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close(context.Background()) })
+
+		return func(method, uri, body string) (int, string) {
+			u, err := url.Parse(uri)
+			require.NoError(t, err)
+			status, header, respBody := upstreamResponse(u.Path)
+			res, err := h.Do(testCtx, &wasmtest.Request{
+				Method: method,
+				URI:    uri,
+				Header: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+				Body:   []byte(body),
+			}, &wasmtest.Response{StatusCode: uint32(status), Header: header, Body: []byte(respBody)})
+			require.NoError(t, err)
+			// There is no transport to fail on a framing mismatch here.
+			if contentLength := res.Response.Header.Get("Content-Length"); contentLength != "" {
+				require.Equal(t, contentLength, strconv.Itoa(len(res.Response.Body)))
+			}
+			return int(res.Response.StatusCode), string(res.Response.Body)
+		}
+	},
+}
+
+// nethttpHost returns the e2e host running the wasm binary returned by wasm
+// under the wazero based reference host.
+func nethttpHost(wasm func(t *testing.T) []byte, options ...handler.Option) e2eHost {
+	return func(t *testing.T, directives string) func(method, uri, body string) (int, string) {
+		mw, err := nethttp.NewMiddleware(testCtx, wasm(t), append([]handler.Option{
+			handler.Logger(testLogger{t}),
+			handler.GuestConfig(hostsConfig(directives)),
+		}, options...)...)
+		require.NoError(t, err)
+		t.Cleanup(func() { mw.Close(testCtx) })
+
+		ts := httptest.NewServer(mw.NewHandler(testCtx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status, header, body := upstreamResponse(r.URL.Path)
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			_, _ = io.WriteString(w, body)
+			for k, v := range upstreamTrailers(r.URL.Path) {
+				w.Header()[k] = v
+			}
+		})))
+		t.Cleanup(ts.Close)
+
+		return func(method, uri, body string) (int, string) {
+			req, err := http.NewRequest(method, ts.URL+uri, strings.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			// A Content-Length not matching the body fails the read.
+			resBody, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			if res.ContentLength >= 0 {
+				require.Equal(t, res.ContentLength, int64(len(resBody)))
+			}
+			return res.StatusCode, string(resBody)
+		}
+	}
+}
+
+func hostsConfig(directives string) []byte {
+	return []byte(fmt.Sprintf("{\"directives\": [ %q ], \"scrubResponse\": {\"errorPages\": true}}", directives))
+}
+
+// TestE2EHosts runs the same scenarios under every host so that differences
+// in host behavior are caught before release.
+func TestE2EHosts(t *testing.T) {
+	tests := map[string]struct {
+		method, uri, body string
+		// expectedStatus is the status with SecRuleEngine On, nothing is
+		// blocked with DetectionOnly.
+		expectedStatus int
+	}{
+		"allowed":               {method: "GET", uri: "/", expectedStatus: 200},
+		"blocked request":       {method: "GET", uri: "/?attack=1", expectedStatus: 403},
+		"allowed request body":  {method: "POST", uri: "/", body: "q=good", expectedStatus: 200},
+		"blocked request body":  {method: "POST", uri: "/", body: "q=evil", expectedStatus: 403},
+		"blocked response":      {method: "GET", uri: "/leak", expectedStatus: 403},
+		"blocked response body": {method: "GET", uri: "/secret", expectedStatus: 403},
+		"scrubbed error page":   {method: "GET", uri: "/error", expectedStatus: 500},
+		"blocked chunked response body with trailers": {method: "GET", uri: "/trailers", expectedStatus: 403},
+	}
+
+	for hostName, newHost := range e2eHosts {
+		t.Run(hostName, func(t *testing.T) {
+			for _, engine := range []string{"On", "DetectionOnly"} {
+				t.Run(engine, func(t *testing.T) {
+					do := newHost(t, "SecRuleEngine "+engine+"\n"+hostsDirectives)
+					for name, test := range tests {
+						t.Run(name, func(t *testing.T) {
+							expectedStatus := test.expectedStatus
+							if engine == "DetectionOnly" && expectedStatus == 403 {
+								expectedStatus = 200
+							}
+							status, body := do(test.method, test.uri, test.body)
+							require.Equal(t, expectedStatus, status)
+							if status == http.StatusForbidden {
+								require.NotContains(t, body, "secret", "the blocked body should not reach the client")
+							}
+						})
+					}
+				})
+			}
+		})
+	}
+}
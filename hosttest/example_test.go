@@ -0,0 +1,35 @@
+package hosttest_test
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+
+	"github.com/corazawaf/coraza-http-wasm/guest"
+	"github.com/corazawaf/coraza-http-wasm/hosttest"
+)
+
+func Example() {
+	host := &hosttest.Host{
+		Config: []byte(`{"directives": [
+			"SecRuleEngine On",
+			"SecRule ARGS:id \"@eq 0\" \"id:1,phase:1,deny,status:403\""
+		]}`),
+		// A host that can't buffer bodies, e.g. to test the header only mode.
+		Features: api.FeatureTrailers,
+		LogLevel: api.LogLevelError,
+	}
+	if err := guest.Init(host); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, uri := range []string{"/?id=0", "/?id=1"} {
+		req, res := hosttest.NewRequest("GET", uri, ""), hosttest.NewResponse(200, "upstream")
+		next := hosttest.Serve(guest.HandleRequest, guest.HandleResponse, req, res)
+		fmt.Println(uri, res.StatusCode, next)
+	}
+	// Output:
+	// /?id=0 403 false
+	// /?id=1 200 true
+}
@@ -0,0 +1,242 @@
+// Package hosttest implements the http-wasm guest API in memory, so that
+// handlers written against it, such as the ones of the guest package, can be
+// unit tested natively, without compiling them to wasm nor running a host.
+//
+// The host config, the features the host supports and the log level are
+// scripted on Host, the request and upstream response on Request and
+// Response, and Serve runs them through the handlers the way a host does.
+package hosttest
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+// Host implements api.Host. The zero value supports no feature and enables
+// the logs from the info level.
+type Host struct {
+	// Config is the config returned by GetConfig.
+	Config []byte
+	// Features are the features the host supports, the others are never
+	// granted.
+	Features api.Features
+	// LogLevel is the lowest level of the enabled logs.
+	LogLevel api.LogLevel
+
+	mu      sync.Mutex
+	enabled api.Features
+	logs    []string
+}
+
+var _ api.Host = (*Host)(nil)
+
+// EnableFeatures grants the features the host supports, returning all the
+// features granted so far as hosts do.
+func (h *Host) EnableFeatures(features api.Features) api.Features {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.enabled |= features & h.Features
+	return h.enabled
+}
+
+// EnabledFeatures returns the features granted so far.
+func (h *Host) EnabledFeatures() api.Features {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.enabled
+}
+
+func (h *Host) GetConfig() []byte {
+	return h.Config
+}
+
+func (h *Host) LogEnabled(level api.LogLevel) bool {
+	return level >= h.LogLevel && level < api.LogLevelNone
+}
+
+// Log records the message when its level is enabled, see Logs.
+func (h *Host) Log(level api.LogLevel, msg string) {
+	if !h.LogEnabled(level) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.logs = append(h.logs, msg)
+}
+
+// Logs returns the messages logged so far, in order.
+func (h *Host) Logs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.logs...)
+}
+
+// Header implements api.Header on top of http.Header, names being
+// canonicalized.
+type Header http.Header
+
+var _ api.Header = Header{}
+
+// Names returns the names of the headers, sorted so that handlers iterating
+// over them behave the same from a run to the other.
+func (h Header) Names() []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (h Header) Get(name string) (string, bool) {
+	values := http.Header(h).Values(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+func (h Header) GetAll(name string) []string {
+	return http.Header(h).Values(name)
+}
+
+func (h Header) Set(name, value string) {
+	http.Header(h).Set(name, value)
+}
+
+func (h Header) Add(name, value string) {
+	http.Header(h).Add(name, value)
+}
+
+func (h Header) Remove(name string) {
+	http.Header(h).Del(name)
+}
+
+// Body implements api.Body: the guest reads the content it is created with,
+// and what it writes is kept apart, see Written. WriteTo is not implemented,
+// its signature clashing with io.WriterTo's.
+type Body struct {
+	api.Body
+	r       *bytes.Reader
+	reads   int
+	written []byte
+}
+
+var _ api.Body = (*Body)(nil)
+
+// NewBody returns a body with the content.
+func NewBody(content string) *Body {
+	return &Body{r: bytes.NewReader([]byte(content))}
+}
+
+// Read reads up to len(p) bytes, eof being true once the content is all read.
+func (b *Body) Read(p []byte) (uint32, bool) {
+	b.reads++
+	n, _ := b.r.Read(p)
+	return uint32(n), b.r.Len() == 0
+}
+
+func (b *Body) Write(p []byte) {
+	if b.written == nil {
+		b.written = []byte{}
+	}
+	b.written = append(b.written, p...)
+}
+
+func (b *Body) WriteString(s string) {
+	b.Write([]byte(s))
+}
+
+// Truncate empties the body, as writing no bytes does through the ABI.
+func (b *Body) Truncate() {
+	b.written = []byte{}
+}
+
+// Reads returns the number of Read calls, e.g. to check a body is read in
+// segments or not read at all.
+func (b *Body) Reads() int {
+	return b.reads
+}
+
+// Written returns what the guest wrote, nil when it never wrote.
+func (b *Body) Written() []byte {
+	return b.written
+}
+
+// Request implements api.Request.
+type Request struct {
+	Method     string
+	URI        string
+	Proto      string
+	SourceAddr string
+	Header     Header
+	Trailer    Header
+	Payload    *Body
+}
+
+var _ api.Request = (*Request)(nil)
+
+// NewRequest returns an HTTP/1.1 request for localhost from 127.0.0.1.
+func NewRequest(method, uri, body string) *Request {
+	return &Request{
+		Method:     method,
+		URI:        uri,
+		Proto:      "HTTP/1.1",
+		SourceAddr: "127.0.0.1:54321",
+		Header:     Header{"Host": {"localhost"}},
+		Trailer:    Header{},
+		Payload:    NewBody(body),
+	}
+}
+
+func (r *Request) GetMethod() string          { return r.Method }
+func (r *Request) SetMethod(method string)    { r.Method = method }
+func (r *Request) GetURI() string             { return r.URI }
+func (r *Request) SetURI(uri string)          { r.URI = uri }
+func (r *Request) GetProtocolVersion() string { return r.Proto }
+func (r *Request) GetSourceAddr() string      { return r.SourceAddr }
+func (r *Request) Headers() api.Header        { return r.Header }
+func (r *Request) Body() api.Body             { return r.Payload }
+func (r *Request) Trailers() api.Header       { return r.Trailer }
+
+// Response implements api.Response. Before the request is handled, it holds
+// the response of the upstream, which the guest may replace.
+type Response struct {
+	StatusCode uint32
+	Header     Header
+	Trailer    Header
+	Payload    *Body
+}
+
+var _ api.Response = (*Response)(nil)
+
+// NewResponse returns an upstream response with a text body.
+func NewResponse(statusCode uint32, body string) *Response {
+	return &Response{
+		StatusCode: statusCode,
+		Header:     Header{"Content-Type": {"text/plain"}},
+		Trailer:    Header{},
+		Payload:    NewBody(body),
+	}
+}
+
+func (r *Response) GetStatusCode() uint32           { return r.StatusCode }
+func (r *Response) SetStatusCode(statusCode uint32) { r.StatusCode = statusCode }
+func (r *Response) Headers() api.Header             { return r.Header }
+func (r *Response) Body() api.Body                  { return r.Payload }
+func (r *Response) Trailers() api.Header            { return r.Trailer }
+
+// Serve runs the request through the handlers the way a host does, the
+// response handler only running when the request handler lets the request
+// reach the upstream. It returns whether it did.
+func Serve(handleRequest api.HandleRequest, handleResponse api.HandleResponse, req *Request, res *Response) bool {
+	next, reqCtx := handleRequest(req, res)
+	if next {
+		handleResponse(reqCtx, req, res, false)
+	}
+	return next
+}
@@ -0,0 +1,84 @@
+package hosttest
+
+import (
+	"testing"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHost(t *testing.T) {
+	t.Run("features", func(t *testing.T) {
+		h := &Host{Features: api.FeatureBufferRequest | api.FeatureTrailers}
+		require.Equal(t, api.FeatureBufferRequest, h.EnableFeatures(api.FeatureBufferRequest|api.FeatureBufferResponse))
+		require.Equal(t, api.FeatureBufferRequest|api.FeatureTrailers, h.EnableFeatures(api.FeatureTrailers))
+		require.Equal(t, api.FeatureBufferRequest|api.FeatureTrailers, h.EnabledFeatures())
+	})
+
+	t.Run("logs", func(t *testing.T) {
+		h := &Host{LogLevel: api.LogLevelWarn}
+		require.False(t, h.LogEnabled(api.LogLevelInfo))
+		require.True(t, h.LogEnabled(api.LogLevelError))
+		require.False(t, h.LogEnabled(api.LogLevelNone))
+		h.Log(api.LogLevelInfo, "dropped")
+		h.Log(api.LogLevelWarn, "kept")
+		require.Equal(t, []string{"kept"}, h.Logs())
+	})
+}
+
+func TestHeader(t *testing.T) {
+	h := Header{}
+	h.Add("x-b", "1")
+	h.Add("X-B", "2")
+	h.Set("x-a", "3")
+	require.Equal(t, []string{"X-A", "X-B"}, h.Names())
+	require.Equal(t, []string{"1", "2"}, h.GetAll("x-b"))
+	v, ok := h.Get("X-B")
+	require.True(t, ok)
+	require.Equal(t, "1", v)
+	h.Remove("x-b")
+	_, ok = h.Get("X-B")
+	require.False(t, ok)
+}
+
+func TestBody(t *testing.T) {
+	b := NewBody("hello world")
+	p := make([]byte, 6)
+	n, eof := b.Read(p)
+	require.Equal(t, "hello ", string(p[:n]))
+	require.False(t, eof)
+
+	n, eof = b.Read(p)
+	require.Equal(t, "world", string(p[:n]))
+	require.True(t, eof)
+	require.Equal(t, 2, b.Reads())
+
+	require.Nil(t, b.Written())
+	b.Write(nil)
+	require.NotNil(t, b.Written(), "an empty write should be told apart from none")
+	b.WriteString("bye")
+	require.Equal(t, "bye", string(b.Written()))
+	b.Truncate()
+	require.Empty(t, b.Written())
+}
+
+func TestServe(t *testing.T) {
+	var responses int
+	handleResponse := func(reqCtx uint32, _ api.Request, _ api.Response, _ bool) {
+		require.Equal(t, uint32(42), reqCtx)
+		responses++
+	}
+
+	req, res := NewRequest("GET", "/", ""), NewResponse(200, "")
+	next := Serve(func(api.Request, api.Response) (bool, uint32) { return true, 42 }, handleResponse, req, res)
+	require.True(t, next)
+	require.Equal(t, 1, responses)
+
+	next = Serve(func(_ api.Request, res api.Response) (bool, uint32) {
+		res.SetStatusCode(403)
+		return false, 0
+	}, handleResponse, req, res)
+	require.False(t, next)
+	require.Equal(t, 1, responses)
+	require.Equal(t, uint32(403), res.StatusCode)
+}
@@ -0,0 +1,275 @@
+//go:build wasip1 && !tinygo && go1.24
+
+// Package abi implements the http-wasm guest API for the standard Go
+// wasip1 target, as github.com/http-wasm/http-wasm-guest-tinygo only binds
+// the host functions when compiling with TinyGo.
+package abi
+
+import (
+	"io"
+	"runtime"
+	"unsafe"
+
+	"github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+)
+
+type headerKind uint32
+
+const (
+	headerKindRequest headerKind = iota
+	headerKindResponse
+	headerKindRequestTrailers
+	headerKindResponseTrailers
+)
+
+type bodyKind uint32
+
+const (
+	bodyKindRequest bodyKind = iota
+	bodyKindResponse
+)
+
+// readBufLimit is the constant memory overhead for reading fields.
+const readBufLimit = 2048
+
+// readBuf is sharable because a wasm instance is single threaded, hosts
+// running one handler at a time per instance.
+var readBuf = make([]byte, readBufLimit)
+
+var (
+	Host     api.Host     = host{}
+	Request  api.Request  = request{}
+	Response api.Response = response{}
+)
+
+func sliceToPtr(b []byte) (uint32, uint32) {
+	return uint32(uintptr(unsafe.Pointer(unsafe.SliceData(b)))), uint32(len(b))
+}
+
+func stringToPtr(s string) (uint32, uint32) {
+	return uint32(uintptr(unsafe.Pointer(unsafe.StringData(s)))), uint32(len(s))
+}
+
+// getBytes copies the bytes returned by fn, so that they can safely be used
+// without risk of corruption.
+func getBytes(fn func(ptr uint32, limit uint32) uint32) []byte {
+	ptr, limit := sliceToPtr(readBuf)
+	size := fn(ptr, limit)
+	if size == 0 {
+		return nil
+	}
+
+	result := make([]byte, size)
+	if size <= limit {
+		copy(result, readBuf)
+		return result
+	}
+
+	ptr, _ = sliceToPtr(result)
+	_ = fn(ptr, size)
+	return result
+}
+
+func getString(fn func(ptr uint32, limit uint32) uint32) string {
+	return string(getBytes(fn))
+}
+
+// getNULTerminated splits a sequence of NUL-terminated strings.
+func getNULTerminated(b []byte) (entries []string) {
+	start := 0
+	for i, c := range b {
+		if c == 0 {
+			entries = append(entries, string(b[start:i]))
+			start = i + 1
+		}
+	}
+	return
+}
+
+type host struct{}
+
+func (host) EnableFeatures(features api.Features) api.Features {
+	return enableFeatures(features)
+}
+
+func (host) GetConfig() []byte {
+	return getBytes(getConfig)
+}
+
+func (host) LogEnabled(level api.LogLevel) bool {
+	return logEnabled(level) == 1
+}
+
+func (host) Log(level api.LogLevel, message string) {
+	if len(message) == 0 {
+		return // don't incur host call overhead
+	}
+	ptr, size := stringToPtr(message)
+	log(level, ptr, size)
+	runtime.KeepAlive(message)
+}
+
+type request struct{}
+
+func (request) GetMethod() string {
+	return getString(getMethod)
+}
+
+func (request) SetMethod(method string) {
+	ptr, size := stringToPtr(method)
+	setMethod(ptr, size)
+	runtime.KeepAlive(method)
+}
+
+func (request) GetURI() string {
+	return getString(getURI)
+}
+
+func (request) SetURI(uri string) {
+	ptr, size := stringToPtr(uri)
+	setURI(ptr, size)
+	runtime.KeepAlive(uri)
+}
+
+func (request) GetProtocolVersion() string {
+	return getString(getProtocolVersion)
+}
+
+func (request) Headers() api.Header {
+	return header(headerKindRequest)
+}
+
+func (request) GetSourceAddr() string {
+	return getString(getSourceAddr)
+}
+
+func (request) Body() api.Body {
+	return body(bodyKindRequest)
+}
+
+func (request) Trailers() api.Header {
+	return header(headerKindRequestTrailers)
+}
+
+type response struct{}
+
+func (response) GetStatusCode() uint32 {
+	return getStatusCode()
+}
+
+func (response) SetStatusCode(statusCode uint32) {
+	setStatusCode(statusCode)
+}
+
+func (response) Headers() api.Header {
+	return header(headerKindResponse)
+}
+
+func (response) Body() api.Body {
+	return body(bodyKindResponse)
+}
+
+func (response) Trailers() api.Header {
+	return header(headerKindResponseTrailers)
+}
+
+type header headerKind
+
+func (h header) Names() []string {
+	return getNULTerminated(getBytes(func(ptr uint32, limit uint32) uint32 {
+		return uint32(getHeaderNames(headerKind(h), ptr, limit))
+	}))
+}
+
+func (h header) Get(name string) (string, bool) {
+	if values := h.GetAll(name); len(values) > 0 {
+		return values[0], true
+	}
+	return "", false
+}
+
+func (h header) GetAll(name string) []string {
+	namePtr, nameSize := stringToPtr(name)
+	values := getNULTerminated(getBytes(func(ptr uint32, limit uint32) uint32 {
+		return uint32(getHeaderValues(headerKind(h), namePtr, nameSize, ptr, limit))
+	}))
+	runtime.KeepAlive(name)
+	return values
+}
+
+func (h header) Set(name, value string) {
+	namePtr, nameSize := stringToPtr(name)
+	valuePtr, valueSize := stringToPtr(value)
+	setHeaderValue(headerKind(h), namePtr, nameSize, valuePtr, valueSize)
+	runtime.KeepAlive(name)
+	runtime.KeepAlive(value)
+}
+
+func (h header) Add(name, value string) {
+	namePtr, nameSize := stringToPtr(name)
+	valuePtr, valueSize := stringToPtr(value)
+	addHeaderValue(headerKind(h), namePtr, nameSize, valuePtr, valueSize)
+	runtime.KeepAlive(name)
+	runtime.KeepAlive(value)
+}
+
+func (h header) Remove(name string) {
+	namePtr, nameSize := stringToPtr(name)
+	removeHeader(headerKind(h), namePtr, nameSize)
+	runtime.KeepAlive(name)
+}
+
+type body bodyKind
+
+func (b body) WriteTo(w io.Writer) (written uint64, err error) {
+	for {
+		size, eof := b.Read(readBuf)
+		if size > 0 {
+			var n int
+			n, err = w.Write(readBuf[:size])
+			written += uint64(n)
+			if err != nil {
+				return
+			}
+		}
+
+		if eof || size == 0 {
+			return
+		}
+	}
+}
+
+func (b body) Read(p []byte) (size uint32, eof bool) {
+	ptr, limit := sliceToPtr(p)
+	if limit == 0 {
+		return // invalid, but prevent crashing.
+	}
+
+	eofLen := readBody(bodyKind(b), ptr, limit)
+	runtime.KeepAlive(p)
+	return uint32(eofLen), eofLen>>32 == 1
+}
+
+func (b body) Write(p []byte) {
+	ptr, size := sliceToPtr(p)
+	if size == 0 {
+		return
+	}
+	writeBody(bodyKind(b), ptr, size)
+	runtime.KeepAlive(p)
+}
+
+// Truncate empties the body, writing no bytes overwriting it with nothing
+// while Write skips empty writes.
+func (b body) Truncate() {
+	writeBody(bodyKind(b), 0, 0)
+}
+
+func (b body) WriteString(s string) {
+	ptr, size := stringToPtr(s)
+	if size == 0 {
+		return
+	}
+	writeBody(bodyKind(b), ptr, size)
+	runtime.KeepAlive(s)
+}
@@ -0,0 +1,66 @@
+//go:build wasip1 && !tinygo && go1.24
+
+package abi
+
+import "github.com/http-wasm/http-wasm-guest-tinygo/handler/api"
+
+// The host functions below mirror the ones imported by
+// github.com/http-wasm/http-wasm-guest-tinygo, which are only available
+// when compiling with TinyGo.
+
+//go:wasmimport http_handler enable_features
+func enableFeatures(features api.Features) api.Features
+
+//go:wasmimport http_handler get_config
+func getConfig(ptr uint32, limit uint32) (len uint32)
+
+//go:wasmimport http_handler log
+func log(level api.LogLevel, ptr, size uint32)
+
+//go:wasmimport http_handler log_enabled
+func logEnabled(level api.LogLevel) uint32
+
+//go:wasmimport http_handler get_method
+func getMethod(ptr uint32, limit uint32) (len uint32)
+
+//go:wasmimport http_handler set_method
+func setMethod(ptr, size uint32)
+
+//go:wasmimport http_handler get_uri
+func getURI(ptr uint32, limit uint32) (len uint32)
+
+//go:wasmimport http_handler set_uri
+func setURI(ptr, size uint32)
+
+//go:wasmimport http_handler get_protocol_version
+func getProtocolVersion(ptr uint32, limit uint32) (len uint32)
+
+//go:wasmimport http_handler get_header_names
+func getHeaderNames(kind headerKind, ptr uint32, limit uint32) (countLen uint64)
+
+//go:wasmimport http_handler get_header_values
+func getHeaderValues(kind headerKind, namePtr, nameSize uint32, bufPtr uint32, bufLimit uint32) (countLen uint64)
+
+//go:wasmimport http_handler set_header_value
+func setHeaderValue(kind headerKind, namePtr, nameSize uint32, valuePtr, valueLen uint32)
+
+//go:wasmimport http_handler add_header_value
+func addHeaderValue(kind headerKind, namePtr, nameSize uint32, valuePtr, valueLen uint32)
+
+//go:wasmimport http_handler remove_header
+func removeHeader(kind headerKind, namePtr, nameSize uint32)
+
+//go:wasmimport http_handler read_body
+func readBody(kind bodyKind, bufPtr uint32, bufLimit uint32) (eofLen uint64)
+
+//go:wasmimport http_handler write_body
+func writeBody(kind bodyKind, bufPtr uint32, bufLen uint32)
+
+//go:wasmimport http_handler get_status_code
+func getStatusCode() uint32
+
+//go:wasmimport http_handler set_status_code
+func setStatusCode(statusCode uint32)
+
+//go:wasmimport http_handler get_source_addr
+func getSourceAddr(ptr uint32, limit uint32) (len uint32)
@@ -0,0 +1,55 @@
+// Package hostconfig reads host configs for the command line tools, which
+// accept them in YAML as well as in the JSON the guest expects.
+package hostconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Read reads the config from the file, or the standard input for "-",
+// converting YAML into JSON.
+func Read(path string) ([]byte, error) {
+	var (
+		content []byte
+		err     error
+	)
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FromYAML(content)
+	case ".json":
+		return content, nil
+	}
+	// Without an extension to tell, JSON is a subset of YAML.
+	if json.Valid(content) {
+		return content, nil
+	}
+	return FromYAML(content)
+}
+
+// FromYAML converts a YAML config into JSON.
+func FromYAML(content []byte) ([]byte, error) {
+	var config any
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+	if _, ok := config.(map[string]any); !ok {
+		return nil, errors.New("object expected")
+	}
+	return json.Marshal(config)
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"archive/zip"
 	"errors"
 	"fmt"
 	"io"
@@ -21,10 +22,11 @@ import (
 var Default = Build
 
 var (
-	minGoVersion     = "1.22"
-	minTinygoVersion = "0.33.0"
-	golangCILintVer  = "v1.61.0" // https://github.com/golangci/golangci-lint/releases
-	gosImportsVer    = "v0.3.8"  // https://github.com/rinchsan/gosimports/releases/tag/v0.3.1
+	minGoVersion       = "1.22"
+	minGoWasip1Version = "1.24" // first version supporting go:wasmexport
+	minTinygoVersion   = "0.33.0"
+	golangCILintVer    = "v1.61.0" // https://github.com/golangci/golangci-lint/releases
+	gosImportsVer      = "v0.3.8"  // https://github.com/rinchsan/gosimports/releases/tag/v0.3.1
 )
 
 var errCommitFormatting = errors.New("files not formatted, please commit formatting changes")
@@ -134,7 +136,7 @@ func Build() error {
 		return err
 	}
 
-	err := sh.RunV("tinygo", "build", "-o", filepath.Join("build", "coraza-http-wasm-raw.wasm"), "-opt=2", "-gc=custom", "-tags='custommalloc no_fs_access'", "-scheduler=none", "--no-debug", "-target=wasip1")
+	err := sh.RunV("tinygo", "build", "-o", filepath.Join("build", "coraza-http-wasm-raw.wasm"), "-opt=2", "-gc=custom", "-tags='custommalloc no_fs_access'", "-scheduler=none", "--no-debug", "-target=wasip1", "-ldflags="+versionLdflags())
 	if err != nil {
 		return err
 	}
@@ -142,6 +144,67 @@ func Build() error {
 	return patchWasm(filepath.Join("build", "coraza-http-wasm-raw.wasm"), filepath.Join("build", "coraza-http-wasm.wasm"), 1050)
 }
 
+// BuildGo builds the wasm binary with the standard Go toolchain (GOOS=wasip1).
+func BuildGo() error {
+	if err := checkVersion("go", minGoWasip1Version); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll("build", 0755); err != nil {
+		return err
+	}
+
+	return sh.RunWithV(map[string]string{"GOOS": "wasip1", "GOARCH": "wasm"},
+		"go", "build", "-o", filepath.Join("build", "coraza-http-wasm-go.wasm"), "-buildmode=c-shared", "-tags=no_fs_access", "-ldflags="+versionLdflags(), ".")
+}
+
+// versionLdflags returns the linker flags setting the version and the commit
+// the binary is built from, leaving the defaults outside of a git checkout.
+func versionLdflags() string {
+	const pkg = "github.com/corazawaf/coraza-http-wasm/guest"
+	var flags []string
+	if version, err := sh.Output("git", "describe", "--tags", "--always", "--dirty"); err == nil && version != "" {
+		flags = append(flags, "-X "+pkg+".Version="+version)
+	}
+	if commit, err := sh.Output("git", "rev-parse", "HEAD"); err == nil && commit != "" {
+		flags = append(flags, "-X "+pkg+".Commit="+commit)
+	}
+	return strings.Join(flags, " ")
+}
+
+// Traefik packages the wasm binary as a Traefik plugin.
+func Traefik() error {
+	mg.SerialDeps(Build)
+
+	out, err := os.Create(filepath.Join("build", "coraza-http-wasm-traefik.zip"))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	for _, f := range []struct{ src, dst string }{
+		{".traefik.yml", ".traefik.yml"},
+		{filepath.Join("build", "coraza-http-wasm.wasm"), "plugin.wasm"},
+	} {
+		w, err := zw.Create(f.dst)
+		if err != nil {
+			return err
+		}
+
+		content, err := os.ReadFile(f.src)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
 func patchWasm(inPath, outPath string, initialPages int) error {
 	raw, err := os.ReadFile(inPath)
 	if err != nil {
@@ -167,11 +230,33 @@ func Test() error {
 	return sh.RunV("go", "test", "./...")
 }
 
+// fuzzTargets are the fuzz targets of the guest package, see Fuzz.
+var fuzzTargets = []string{"FuzzGetConfigFromHost", "FuzzParseAddr", "FuzzParseRequestTarget", "FuzzRequestHeaders"}
+
+// Fuzz runs the fuzz targets, each for FUZZTIME (30s by default).
+func Fuzz() error {
+	fuzzTime := os.Getenv("FUZZTIME")
+	if fuzzTime == "" {
+		fuzzTime = "30s"
+	}
+	for _, target := range fuzzTargets {
+		if err := sh.RunV("go", "test", "-run=^$", "-fuzz=^"+target+"$", "-fuzztime="+fuzzTime, "./guest"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // E2e runs e2e tests
 func E2e() error {
 	return sh.RunV("go", "test", "-count=1", "-run=^TestE2E", "-tags=e2e", "-v", ".")
 }
 
+// Bench runs the benchmarks against the built wasm binary
+func Bench() error {
+	return sh.RunV("go", "test", "-count=1", "-run=^$", "-bench=.", "-benchmem", "-tags=e2e", ".")
+}
+
 func copy(src, dst string) error {
 	sourceFileStat, err := os.Stat(src)
 	if err != nil {
@@ -202,7 +287,7 @@ func copy(src, dst string) error {
 	return err
 }
 
-// FTW runs the FTW test suite
+// FTW runs the FTW test suite, or the tests whose ID matches FTW_INCLUDE.
 func FTW() error {
 	var (
 		binSrc = filepath.Join("build", "coraza-http-wasm.wasm")
@@ -0,0 +1,32 @@
+//go:build !tinygo && go1.24
+
+package main
+
+import (
+	"github.com/corazawaf/coraza-http-wasm/internal/abi"
+	httpwasm "github.com/http-wasm/http-wasm-guest-tinygo/handler"
+)
+
+// When built with the standard Go toolchain the module is a WASI reactor
+// (-buildmode=c-shared), whose _initialize export runs the package init
+// functions but not main, hence we start from here. The http-wasm exports
+// are declared below as the guest library only exports them with TinyGo.
+func init() {
+	httpwasm.Host = abi.Host
+	main()
+}
+
+//go:wasmexport handle_request
+func exportedHandleRequest() uint64 {
+	next, reqCtx := httpwasm.HandleRequestFn(abi.Request, abi.Response)
+	ctxNext := uint64(reqCtx) << 32
+	if next {
+		ctxNext |= 1
+	}
+	return ctxNext
+}
+
+//go:wasmexport handle_response
+func exportedHandleResponse(reqCtx uint32, isError uint32) {
+	httpwasm.HandleResponseFn(reqCtx, abi.Request, abi.Response, isError == 1)
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
@@ -54,7 +55,7 @@ func TestFTW(t *testing.T) {
 Include @coraza.conf-recommended
 
 # Custom Rules for testing and eventually overrides of the basic Coraza config
-SecResponseBodyMimeType text/plain",
+SecResponseBodyMimeType text/plain
 SecDefaultAction "phase:3,log,auditlog,pass"
 SecDefaultAction "phase:4,log,auditlog,pass"
 SecDefaultAction "phase:5,log,auditlog,pass"
@@ -151,7 +152,17 @@ Include @owasp_crs/*.conf
 	cfg.TestOverride.Overrides.DestAddr = &host
 	cfg.TestOverride.Overrides.Port = &port
 
+	// FTW_INCLUDE narrows the run to the matching test IDs, e.g. for a quick
+	// smoke run.
+	var include *regexp.Regexp
+	if pattern := os.Getenv("FTW_INCLUDE"); pattern != "" {
+		if include, err = regexp.Compile(pattern); err != nil {
+			t.Fatalf("invalid FTW_INCLUDE: %v", err)
+		}
+	}
+
 	res, err := runner.Run(cfg, tests, runner.RunnerConfig{
+		Include:     include,
 		ShowTime:    false,
 		ReadTimeout: 5 * time.Second,
 	}, output.NewOutput("quiet", os.Stdout))
@@ -159,6 +170,10 @@ Include @owasp_crs/*.conf
 		t.Fatal(err)
 	}
 
+	if res.Stats.Run == 0 {
+		t.Fatal("no tests run")
+	}
+
 	if len(res.Stats.Failed) > 0 {
 		t.Errorf("failed tests: %v", res.Stats.Failed)
 	}
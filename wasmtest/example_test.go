@@ -0,0 +1,79 @@
+package wasmtest_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/corazawaf/coraza-http-wasm/wasmtest"
+)
+
+func Example() {
+	ctx := context.Background()
+
+	// Built with "go run mage.go build".
+	guest, err := os.ReadFile("../build/coraza-http-wasm.wasm")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h, err := wasmtest.New(ctx, guest, wasmtest.Directives(
+		"SecRuleEngine On",
+		"SecRequestBodyAccess On",
+		`SecRule ARGS:id "@eq 0" "id:1,phase:1,deny,status:403"`,
+		`SecRule ARGS_POST:q "@contains evil" "id:2,phase:2,deny,status:401"`,
+	))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer h.Close(ctx)
+
+	for _, req := range []*wasmtest.Request{
+		{URI: "/?id=0"},
+		{
+			Method: http.MethodPost,
+			Header: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+			Body:   []byte("q=evil"),
+		},
+		{URI: "/?id=1"},
+	} {
+		res, err := h.Do(ctx, req, &wasmtest.Response{Body: []byte("Hello world")})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(res.Response.StatusCode, res.Upstream != nil)
+	}
+	// Output:
+	// 403 false
+	// 401 false
+	// 200 true
+}
+
+func Example_headerOnly() {
+	ctx := context.Background()
+
+	guest, err := os.ReadFile("../build/coraza-http-wasm.wasm")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// A host not supporting buffering, hence response rules only detect.
+	h, err := wasmtest.New(ctx, guest, wasmtest.Features(0), wasmtest.Directives(
+		"SecRuleEngine On",
+		`SecRule RESPONSE_STATUS "@eq 500" "id:1,phase:3,deny,status:403"`,
+	))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer h.Close(ctx)
+
+	res, err := h.Do(ctx, nil, &wasmtest.Response{StatusCode: http.StatusInternalServerError})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("features: %q, status: %d\n", h.Features(), res.Response.StatusCode)
+	// Output:
+	// features: "", status: 500
+}
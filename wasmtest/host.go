@@ -0,0 +1,201 @@
+package wasmtest
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	handlerapi "github.com/http-wasm/http-wasm-host-go/api/handler"
+)
+
+// host implements handlerapi.Host on top of the exchange of the current
+// request. Trailers are not supported.
+type host struct {
+	h *Host
+}
+
+var _ handlerapi.Host = host{}
+
+func (host) exchange(ctx context.Context) *exchange {
+	return ctx.Value(exchangeKey{}).(*exchange)
+}
+
+// EnableFeatures implements the same method as documented on handlerapi.Host.
+func (h host) EnableFeatures(ctx context.Context, features handlerapi.Features) handlerapi.Features {
+	granted := features & h.h.supports
+	if x, ok := ctx.Value(exchangeKey{}).(*exchange); ok {
+		x.features = granted
+	} else {
+		h.h.features.Store(uint32(granted))
+	}
+	return granted
+}
+
+// GetMethod implements the same method as documented on handlerapi.Host.
+func (h host) GetMethod(ctx context.Context) string {
+	return h.exchange(ctx).req.Method
+}
+
+// SetMethod implements the same method as documented on handlerapi.Host.
+func (h host) SetMethod(ctx context.Context, method string) {
+	h.exchange(ctx).req.Method = method
+}
+
+// GetURI implements the same method as documented on handlerapi.Host.
+func (h host) GetURI(ctx context.Context) string {
+	return h.exchange(ctx).req.URI
+}
+
+// SetURI implements the same method as documented on handlerapi.Host.
+func (h host) SetURI(ctx context.Context, uri string) {
+	h.exchange(ctx).req.URI = uri
+}
+
+// GetProtocolVersion implements the same method as documented on handlerapi.Host.
+func (h host) GetProtocolVersion(ctx context.Context) string {
+	return h.exchange(ctx).req.Protocol
+}
+
+// GetRequestHeaderNames implements the same method as documented on handlerapi.Host.
+func (h host) GetRequestHeaderNames(ctx context.Context) []string {
+	return headerNames(h.exchange(ctx).req.Header)
+}
+
+// GetRequestHeaderValues implements the same method as documented on handlerapi.Host.
+func (h host) GetRequestHeaderValues(ctx context.Context, name string) []string {
+	return h.exchange(ctx).req.Header.Values(name)
+}
+
+// SetRequestHeaderValue implements the same method as documented on handlerapi.Host.
+func (h host) SetRequestHeaderValue(ctx context.Context, name, value string) {
+	h.exchange(ctx).req.Header.Set(name, value)
+}
+
+// AddRequestHeaderValue implements the same method as documented on handlerapi.Host.
+func (h host) AddRequestHeaderValue(ctx context.Context, name, value string) {
+	h.exchange(ctx).req.Header.Add(name, value)
+}
+
+// RemoveRequestHeader implements the same method as documented on handlerapi.Host.
+func (h host) RemoveRequestHeader(ctx context.Context, name string) {
+	h.exchange(ctx).req.Header.Del(name)
+}
+
+// RequestBodyReader implements the same method as documented on handlerapi.Host.
+func (h host) RequestBodyReader(ctx context.Context) io.ReadCloser {
+	x := h.exchange(ctx)
+	x.reqBody = bytes.NewReader(x.req.Body)
+	return io.NopCloser(x.reqBody)
+}
+
+// RequestBodyWriter implements the same method as documented on handlerapi.Host.
+func (h host) RequestBodyWriter(ctx context.Context) io.Writer {
+	x := h.exchange(ctx)
+	x.reqBodyWritten = &bytes.Buffer{}
+	return x.reqBodyWritten
+}
+
+// GetRequestTrailerNames implements the same method as documented on handlerapi.Host.
+func (host) GetRequestTrailerNames(context.Context) []string { return nil }
+
+// GetRequestTrailerValues implements the same method as documented on handlerapi.Host.
+func (host) GetRequestTrailerValues(context.Context, string) []string { return nil }
+
+// SetRequestTrailerValue implements the same method as documented on handlerapi.Host.
+func (host) SetRequestTrailerValue(context.Context, string, string) {
+	panic("trailers are not supported")
+}
+
+// AddRequestTrailerValue implements the same method as documented on handlerapi.Host.
+func (host) AddRequestTrailerValue(context.Context, string, string) {
+	panic("trailers are not supported")
+}
+
+// RemoveRequestTrailer implements the same method as documented on handlerapi.Host.
+func (host) RemoveRequestTrailer(context.Context, string) {
+	panic("trailers are not supported")
+}
+
+// GetStatusCode implements the same method as documented on handlerapi.Host.
+func (h host) GetStatusCode(ctx context.Context) uint32 {
+	return h.exchange(ctx).resp.StatusCode
+}
+
+// SetStatusCode implements the same method as documented on handlerapi.Host.
+func (h host) SetStatusCode(ctx context.Context, statusCode uint32) {
+	h.exchange(ctx).resp.StatusCode = statusCode
+}
+
+// GetResponseHeaderNames implements the same method as documented on handlerapi.Host.
+func (h host) GetResponseHeaderNames(ctx context.Context) []string {
+	return headerNames(h.exchange(ctx).resp.Header)
+}
+
+// GetResponseHeaderValues implements the same method as documented on handlerapi.Host.
+func (h host) GetResponseHeaderValues(ctx context.Context, name string) []string {
+	return h.exchange(ctx).resp.Header.Values(name)
+}
+
+// SetResponseHeaderValue implements the same method as documented on handlerapi.Host.
+func (h host) SetResponseHeaderValue(ctx context.Context, name, value string) {
+	h.exchange(ctx).resp.Header.Set(name, value)
+}
+
+// AddResponseHeaderValue implements the same method as documented on handlerapi.Host.
+func (h host) AddResponseHeaderValue(ctx context.Context, name, value string) {
+	h.exchange(ctx).resp.Header.Add(name, value)
+}
+
+// RemoveResponseHeader implements the same method as documented on handlerapi.Host.
+func (h host) RemoveResponseHeader(ctx context.Context, name string) {
+	h.exchange(ctx).resp.Header.Del(name)
+}
+
+// ResponseBodyReader implements the same method as documented on handlerapi.Host.
+func (h host) ResponseBodyReader(ctx context.Context) io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(h.exchange(ctx).resp.Body))
+}
+
+// ResponseBodyWriter implements the same method as documented on handlerapi.Host.
+func (h host) ResponseBodyWriter(ctx context.Context) io.Writer {
+	x := h.exchange(ctx)
+	x.respBodyWritten = &bytes.Buffer{}
+	return x.respBodyWritten
+}
+
+// GetResponseTrailerNames implements the same method as documented on handlerapi.Host.
+func (host) GetResponseTrailerNames(context.Context) []string { return nil }
+
+// GetResponseTrailerValues implements the same method as documented on handlerapi.Host.
+func (host) GetResponseTrailerValues(context.Context, string) []string { return nil }
+
+// SetResponseTrailerValue implements the same method as documented on handlerapi.Host.
+func (host) SetResponseTrailerValue(context.Context, string, string) {
+	panic("trailers are not supported")
+}
+
+// AddResponseTrailerValue implements the same method as documented on handlerapi.Host.
+func (host) AddResponseTrailerValue(context.Context, string, string) {
+	panic("trailers are not supported")
+}
+
+// RemoveResponseTrailer implements the same method as documented on handlerapi.Host.
+func (host) RemoveResponseTrailer(context.Context, string) {
+	panic("trailers are not supported")
+}
+
+// GetSourceAddr implements the same method as documented on handlerapi.Host.
+func (h host) GetSourceAddr(ctx context.Context) string {
+	return h.exchange(ctx).req.SourceAddr
+}
+
+func headerNames(header map[string][]string) []string {
+	if len(header) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	return names
+}
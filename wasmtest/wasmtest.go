@@ -0,0 +1,272 @@
+// Package wasmtest runs the compiled coraza-http-wasm module under wazero
+// behind a scriptable fake host, so that directive sets can be tested against
+// the real binary without setting up a proxy:
+//
+//	h, err := wasmtest.New(ctx, guest, wasmtest.Directives(
+//		"SecRuleEngine On",
+//		`SecRule ARGS:id "@eq 0" "id:1,phase:1,deny,status:403"`,
+//	))
+//	...
+//	res, err := h.Do(ctx, &wasmtest.Request{URI: "/?id=0"}, nil)
+//	// res.Response.StatusCode == 403
+//
+// The fake host grants the features selected with Features, which allows
+// testing how the module behaves on hosts not supporting buffering.
+package wasmtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/http-wasm/http-wasm-host-go/api"
+	handlerapi "github.com/http-wasm/http-wasm-host-go/api/handler"
+	"github.com/http-wasm/http-wasm-host-go/handler"
+	"github.com/tetratelabs/wazero"
+)
+
+// AllFeatures are the features supported by default. Trailers are not
+// supported by the fake host.
+const AllFeatures = handlerapi.FeatureBufferRequest | handlerapi.FeatureBufferResponse
+
+// Option configures the Host created by New.
+type Option func(*options)
+
+type options struct {
+	features     handlerapi.Features
+	guestConfig  []byte
+	moduleConfig wazero.ModuleConfig
+	logger       api.Logger
+}
+
+// Features sets the features the host supports, defaults to AllFeatures. The
+// module is only granted the ones it asks for among them.
+func Features(features handlerapi.Features) Option {
+	return func(o *options) {
+		o.features = features
+	}
+}
+
+// Config sets the raw JSON config passed to the module, see the README for
+// the available options.
+func Config(config []byte) Option {
+	return func(o *options) {
+		o.guestConfig = config
+	}
+}
+
+// Directives sets the config passed to the module to the given directives.
+func Directives(directives ...string) Option {
+	return func(o *options) {
+		// Marshalling a string slice can't fail.
+		o.guestConfig, _ = json.Marshal(map[string][]string{"directives": directives})
+	}
+}
+
+// ModuleConfig sets the configuration used to instantiate the module, e.g. to
+// mount the directory holding included rule files or, for the standard Go
+// build, to run "_initialize" as start function.
+func ModuleConfig(moduleConfig wazero.ModuleConfig) Option {
+	return func(o *options) {
+		o.moduleConfig = moduleConfig
+	}
+}
+
+// Logger sets the logger receiving the logs of the module, they are discarded
+// by default.
+func Logger(logger api.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Request is a request sent through the module, zero fields take the defaults
+// of a GET request to "/" over HTTP/1.1. A Host header for localhost is added
+// when there is none.
+type Request struct {
+	Method     string
+	URI        string
+	Protocol   string
+	SourceAddr string
+	Header     http.Header
+	Body       []byte
+}
+
+// Response is either the response of the upstream or the one received by the
+// client. A zero StatusCode means 200.
+type Response struct {
+	StatusCode uint32
+	Header     http.Header
+	Body       []byte
+}
+
+// Result is the outcome of a request sent through the module.
+type Result struct {
+	// Upstream is the request as received by the upstream, it is nil when the
+	// module did not let the request through.
+	Upstream *Request
+	// Response is the response received by the client.
+	Response Response
+}
+
+// Host runs the module behind a fake http-wasm host. It is safe for
+// concurrent use.
+type Host struct {
+	mw       handler.Middleware
+	supports handlerapi.Features
+	// features holds the features granted to the module at startup.
+	features atomic.Uint32
+}
+
+// New compiles and instantiates guest, the module binary, behind a fake host.
+// It fails when the module fails to initialize, e.g. on invalid directives.
+func New(ctx context.Context, guest []byte, opts ...Option) (*Host, error) {
+	o := &options{
+		features:     AllFeatures,
+		moduleConfig: wazero.NewModuleConfig(),
+		logger:       api.NoopLogger{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	h := &Host{supports: o.features}
+	mw, err := handler.NewMiddleware(ctx, guest, host{h},
+		handler.GuestConfig(o.guestConfig),
+		handler.ModuleConfig(o.moduleConfig),
+		handler.Logger(o.logger),
+	)
+	if err != nil {
+		return nil, err
+	}
+	h.mw = mw
+	return h, nil
+}
+
+// Features returns the features granted to the module at startup.
+func (h *Host) Features() handlerapi.Features {
+	return handlerapi.Features(h.features.Load())
+}
+
+// Do sends req through the module. When the module lets it through, the
+// upstream answers with upstream, nil meaning an empty 200 response.
+//
+// As on a real host, changes made by the module to the response are only
+// received by the client when response buffering is granted.
+func (h *Host) Do(ctx context.Context, req *Request, upstream *Response) (*Result, error) {
+	x := newExchange(req, h.Features())
+	ctx = context.WithValue(ctx, exchangeKey{}, x)
+
+	outCtx, ctxNext, err := h.mw.HandleRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Returning zero means the module answered the request by itself.
+	if uint32(ctxNext) == 0 {
+		return &Result{Response: x.response()}, nil
+	}
+
+	res := &Result{Upstream: x.forward()}
+	x.resp = cloneResponse(upstream)
+	if !x.features.IsEnabled(handlerapi.FeatureBufferResponse) {
+		// The response is sent as is before the module sees it.
+		res.Response = cloneResponse(&x.resp)
+	}
+
+	if err := h.mw.HandleResponse(outCtx, uint32(ctxNext>>32), nil); err != nil {
+		return nil, err
+	}
+
+	if x.features.IsEnabled(handlerapi.FeatureBufferResponse) {
+		res.Response = x.response()
+	}
+	return res, nil
+}
+
+// Close releases the module and the runtime.
+func (h *Host) Close(ctx context.Context) error {
+	return h.mw.Close(ctx)
+}
+
+type exchangeKey struct{}
+
+// exchange holds the state of a request sent through the module.
+type exchange struct {
+	req      Request
+	features handlerapi.Features
+
+	reqBody        *bytes.Reader
+	reqBodyWritten *bytes.Buffer
+
+	resp            Response
+	respBodyWritten *bytes.Buffer
+}
+
+func newExchange(req *Request, features handlerapi.Features) *exchange {
+	x := &exchange{features: features}
+	if req != nil {
+		x.req = *req
+		x.req.Header = req.Header.Clone()
+	}
+	if x.req.Method == "" {
+		x.req.Method = http.MethodGet
+	}
+	if x.req.URI == "" {
+		x.req.URI = "/"
+	}
+	if x.req.Protocol == "" {
+		x.req.Protocol = "HTTP/1.1"
+	}
+	if x.req.SourceAddr == "" {
+		x.req.SourceAddr = "127.0.0.1:12345"
+	}
+	if x.req.Header == nil {
+		x.req.Header = http.Header{}
+	}
+	if len(x.req.Header.Values("Host")) == 0 {
+		x.req.Header.Set("Host", "localhost")
+	}
+	x.resp = Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	return x
+}
+
+// forward returns the request as received by the upstream.
+func (x *exchange) forward() *Request {
+	req := x.req
+	switch {
+	case x.reqBodyWritten != nil:
+		req.Body = x.reqBodyWritten.Bytes()
+	case x.reqBody != nil && !x.features.IsEnabled(handlerapi.FeatureBufferRequest):
+		// Without buffering, what the module read is consumed.
+		req.Body = req.Body[len(req.Body)-x.reqBody.Len():]
+	}
+	return &req
+}
+
+// response returns the response as left by the module, the body written by
+// the module replacing the original one.
+func (x *exchange) response() Response {
+	resp := x.resp
+	if x.respBodyWritten != nil {
+		resp.Body = x.respBodyWritten.Bytes()
+	}
+	return resp
+}
+
+func cloneResponse(resp *Response) Response {
+	if resp == nil {
+		return Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	}
+
+	c := Response{StatusCode: resp.StatusCode, Header: resp.Header.Clone(), Body: bytes.Clone(resp.Body)}
+	if c.StatusCode == 0 {
+		c.StatusCode = http.StatusOK
+	}
+	if c.Header == nil {
+		c.Header = http.Header{}
+	}
+	return c
+}